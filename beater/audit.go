@@ -0,0 +1,448 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+const (
+	transportField string = "_TRANSPORT"
+	auditTypeField string = "_AUDIT_TYPE"
+	auditIdField   string = "_AUDIT_ID"
+
+	// maxExecveArgs bounds the arguments collected from an EXECVE record
+	maxExecveArgs int = 4096
+
+	// auditRecordKey marks the events of audit records for the correlation in
+	// flushOrBufferLogs, it holds the parsed record and is removed before
+	// they are published
+	auditRecordKey string = "auditRecord"
+
+	auditTransport string = "audit"
+	// auditEventType is the type of the audit events, their records are
+	// stored under the key of the same name
+	auditEventType string = "audit"
+)
+
+// auditClassificationRule types the entries of the audit transport, which
+// have no SYSLOG_IDENTIFIER
+var auditClassificationRule = config.ClassificationRule{
+	Match:        map[string]string{transportField: auditTransport},
+	Type:         auditEventType,
+	BufferingKey: auditEventType,
+	Fields:       []string{auditTypeField, auditIdField},
+}
+
+// rawAuditHeader is the header of records in the format of auditd, e.g.
+// "type=SYSCALL msg=audit(1500000000.123:42): ". journald strips it and
+// keeps the type name only.
+var rawAuditHeader = regexp.MustCompile(`^type=(\S+) msg=audit\(([0-9]+)\.([0-9]{3}):([0-9]+)\): ?`)
+
+// execveArg is the name of the arguments of EXECVE records
+var execveArg = regexp.MustCompile(`^a([0-9]+)$`)
+
+// auditIntFields are the record fields with integer values
+var auditIntFields = map[string]struct{}{
+	"argc": {}, "auid": {}, "egid": {}, "euid": {}, "exit": {}, "fsgid": {},
+	"fsuid": {}, "gid": {}, "inode": {}, "items": {}, "item": {}, "old-auid": {},
+	"old-ses": {}, "ouid": {}, "ogid": {}, "pid": {}, "ppid": {}, "ses": {},
+	"sgid": {}, "suid": {}, "syscall": {}, "uid": {},
+}
+
+// auditEncodedFields are the record fields whose values are hex encoded
+// when they are not quoted, because they contain spaces or control characters
+var auditEncodedFields = map[string]struct{}{
+	"acct": {}, "cmd": {}, "comm": {}, "cwd": {}, "data": {}, "dir": {},
+	"exe": {}, "file": {}, "key": {}, "name": {}, "ocomm": {}, "path": {},
+	"proctitle": {}, "watch": {},
+}
+
+// auditHexValue is a value as the kernel and libaudit hex encode it
+var auditHexValue = regexp.MustCompile(`^(?:[0-9A-F]{2})+$`)
+
+// auditArchs are the names of the audit architecture codes
+var auditArchs = map[string]string{
+	"40000003": "i386",
+	"40000028": "arm",
+	"80000015": "ppc64",
+	"80000016": "s390x",
+	"c000003e": "x86_64",
+	"c00000b7": "aarch64",
+	"c0000015": "ppc64le",
+	"c00000f3": "riscv64",
+}
+
+// auditRecordTypes are the names of the audit record types, indexed by their
+// code
+var auditRecordTypes = map[int]string{
+	1006: "LOGIN",
+	1100: "USER_AUTH", 1101: "USER_ACCT", 1102: "USER_MGMT", 1103: "CRED_ACQ",
+	1104: "CRED_DISP", 1105: "USER_START", 1106: "USER_END", 1107: "USER_AVC",
+	1108: "USER_CHAUTHTOK", 1109: "USER_ERR", 1110: "CRED_REFR", 1111: "USYS_CONFIG",
+	1112: "USER_LOGIN", 1113: "USER_LOGOUT", 1114: "ADD_USER", 1115: "DEL_USER",
+	1116: "ADD_GROUP", 1117: "DEL_GROUP", 1118: "DAC_CHECK", 1119: "CHGRP_ID",
+	1120: "TEST", 1121: "TRUSTED_APP", 1122: "USER_SELINUX_ERR", 1123: "USER_CMD",
+	1124: "USER_TTY", 1125: "CHUSER_ID", 1126: "GRP_AUTH", 1127: "SYSTEM_BOOT",
+	1128: "SYSTEM_SHUTDOWN", 1129: "SYSTEM_RUNLEVEL", 1130: "SERVICE_START",
+	1131: "SERVICE_STOP", 1132: "GRP_MGMT", 1133: "GRP_CHAUTHTOK", 1134: "MAC_CHECK",
+	1135: "ACCT_LOCK", 1136: "ACCT_UNLOCK", 1137: "USER_DEVICE", 1138: "SOFTWARE_UPDATE",
+	1200: "DAEMON_START", 1201: "DAEMON_END", 1202: "DAEMON_ABORT", 1203: "DAEMON_CONFIG",
+	1204: "DAEMON_RECONFIG", 1205: "DAEMON_ROTATE", 1206: "DAEMON_RESUME",
+	1207: "DAEMON_ACCEPT", 1208: "DAEMON_CLOSE", 1209: "DAEMON_ERR",
+	1300: "SYSCALL", 1302: "PATH", 1303: "IPC", 1304: "SOCKETCALL", 1305: "CONFIG_CHANGE",
+	1306: "SOCKADDR", 1307: "CWD", 1309: "EXECVE", 1311: "IPC_SET_PERM", 1312: "MQ_OPEN",
+	1313: "MQ_SENDRECV", 1314: "MQ_NOTIFY", 1315: "MQ_GETSETATTR", 1316: "KERNEL_OTHER",
+	1317: "FD_PAIR", 1318: "OBJ_PID", 1319: "TTY", 1320: "EOE", 1321: "BPRM_FCAPS",
+	1322: "CAPSET", 1323: "MMAP", 1324: "NETFILTER_PKT", 1325: "NETFILTER_CFG",
+	1326: "SECCOMP", 1327: "PROCTITLE", 1328: "FEATURE_CHANGE", 1329: "REPLACE",
+	1330: "KERN_MODULE", 1331: "FANOTIFY", 1332: "TIME_INJOFFSET", 1333: "TIME_ADJNTPVAL",
+	1334: "BPF", 1335: "EVENT_LISTENER",
+	1400: "AVC", 1401: "SELINUX_ERR", 1402: "AVC_PATH", 1403: "MAC_POLICY_LOAD",
+	1404: "MAC_STATUS", 1405: "MAC_CONFIG_CHANGE", 1406: "MAC_UNLBL_ALLOW",
+	1407: "MAC_CIPSOV4_ADD", 1408: "MAC_CIPSOV4_DEL", 1409: "MAC_MAP_ADD",
+	1410: "MAC_MAP_DEL", 1411: "MAC_IPSEC_ADDSA", 1412: "MAC_IPSEC_DELSA",
+	1413: "MAC_IPSEC_ADDSPD", 1414: "MAC_IPSEC_DELSPD", 1415: "MAC_IPSEC_EVENT",
+	1416: "MAC_UNLBL_STCADD", 1417: "MAC_UNLBL_STCDEL", 1418: "MAC_CALIPSO_ADD",
+	1419: "MAC_CALIPSO_DEL",
+	1700: "ANOM_PROMISCUOUS", 1701: "ANOM_ABEND", 1702: "ANOM_LINK", 1703: "ANOM_CREAT",
+	2000: "KERNEL",
+}
+
+// auditRecord is a parsed audit record
+type auditRecord struct {
+	recordType string
+	code       int
+	serial     int64
+	// timestamp is the time of the audit event in microseconds, the
+	// records of an event share it with the serial
+	timestamp int64
+	values    common.MapStr
+}
+
+type auditPair struct {
+	key   string
+	value string
+	quote byte
+}
+
+// parseAuditRecord parses the message of an entry of the audit transport.
+// The values of the record are typed: ids and counts are integers, success
+// is a boolean, the arch is named and hex encoded values are decoded. The
+// arguments of EXECVE records are collected into args.
+func parseAuditRecord(fields map[string]string) *auditRecord {
+	message := fields[messageField]
+	rec := &auditRecord{values: common.MapStr{}}
+	rec.serial, _ = strconv.ParseInt(fields[auditIdField], 10, 64)
+	// journald takes the source timestamp from the audit header
+	rec.timestamp, _ = strconv.ParseInt(fields[timestampField], 10, 64)
+
+	if m := rawAuditHeader.FindStringSubmatch(message); m != nil {
+		rec.recordType = m[1]
+		sec, _ := strconv.ParseInt(m[2], 10, 64)
+		msec, _ := strconv.ParseInt(m[3], 10, 64)
+		rec.timestamp = sec*1000000 + msec*1000
+		rec.serial, _ = strconv.ParseInt(m[4], 10, 64)
+		message = message[len(m[0]):]
+	} else if i := strings.IndexByte(message, ' '); i > 0 && !strings.Contains(message[:i], "=") {
+		rec.recordType = message[:i]
+		message = message[i+1:]
+	}
+	if code, err := strconv.Atoi(fields[auditTypeField]); err == nil {
+		if name, ok := auditRecordTypes[code]; ok {
+			rec.recordType = name
+		}
+		rec.code = code
+		rec.values["record_type_code"] = code
+	} else {
+		for code, name := range auditRecordTypes {
+			if name == rec.recordType {
+				rec.code = code
+			}
+		}
+	}
+
+	var args []interface{}
+	for _, pair := range parseAuditPairs(message) {
+		if pair.key == "msg" && pair.quote == '\'' {
+			// user space records nest their fields in msg
+			for _, nested := range parseAuditPairs(pair.value) {
+				rec.set(nested)
+			}
+			continue
+		}
+		if m := execveArg.FindStringSubmatch(pair.key); m != nil && rec.recordType == "EXECVE" {
+			i, err := strconv.Atoi(m[1])
+			if err != nil || i >= maxExecveArgs {
+				continue
+			}
+			for len(args) <= i {
+				args = append(args, "")
+			}
+			args[i] = decodeAuditValue(pair, true)
+			continue
+		}
+		rec.set(pair)
+	}
+	if args != nil {
+		rec.values["args"] = args
+	}
+	rec.values["record_type"] = rec.recordType
+	if rec.serial != 0 {
+		rec.values["serial"] = rec.serial
+	}
+	return rec
+}
+
+// set adds a typed value to the record
+func (rec *auditRecord) set(pair auditPair) {
+	if pair.value == "(null)" || pair.value == "?" {
+		return
+	}
+	if _, ok := auditIntFields[pair.key]; ok && pair.quote == 0 {
+		if i, err := strconv.ParseInt(pair.value, 10, 64); err == nil {
+			rec.values[pair.key] = i
+			return
+		}
+	}
+	switch pair.key {
+	case "success":
+		rec.values[pair.key] = pair.value == "yes"
+	case "arch":
+		if arch, ok := auditArchs[pair.value]; ok {
+			rec.values[pair.key] = arch
+		} else {
+			rec.values[pair.key] = pair.value
+		}
+	default:
+		_, encoded := auditEncodedFields[pair.key]
+		rec.values[pair.key] = decodeAuditValue(pair, encoded)
+	}
+}
+
+// decodeAuditValue decodes an unquoted hex value of an encoded field, other
+// values are kept as they are. NULs, which separate the arguments of
+// proctitle, become spaces.
+func decodeAuditValue(pair auditPair, encoded bool) string {
+	if !encoded || pair.quote != 0 || !auditHexValue.MatchString(pair.value) {
+		return pair.value
+	}
+	b, err := hex.DecodeString(pair.value)
+	if err != nil {
+		return pair.value
+	}
+	return strings.Replace(strings.TrimRight(string(b), "\x00"), "\x00", " ", -1)
+}
+
+// parseAuditPairs returns the key=value pairs of an audit record. Values are
+// bare, double quoted or, for the msg of user space records, single quoted.
+// Audit quotes have no escapes.
+func parseAuditPairs(s string) []auditPair {
+	var pairs []auditPair
+	i := 0
+	for i < len(s) {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' {
+			i++
+		}
+		if i == len(s) || s[i] != '=' || i == start {
+			// not a pair, skip the word
+			for i < len(s) && s[i] != ' ' {
+				i++
+			}
+			continue
+		}
+		pair := auditPair{key: s[start:i]}
+		i++
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			pair.quote = s[i]
+			end := strings.IndexByte(s[i+1:], pair.quote)
+			if end < 0 {
+				pair.value, i = s[i+1:], len(s)
+			} else {
+				pair.value, i = s[i+1:i+1+end], i+end+2
+			}
+		} else {
+			start = i
+			for i < len(s) && s[i] != ' ' {
+				i++
+			}
+			pair.value = s[start:i]
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// auditCorrelator groups the records of audit events, which share their
+// serial and timestamp, into single events. It is only used by the log
+// processor.
+type auditCorrelator struct {
+	timeout time.Duration
+	events  map[auditEventKey]*auditEvent
+}
+
+// auditEventKey identifies an audit event. Serials restart at boot and every
+// machine counts its own, so the records of an event are only grouped with
+// the records of the same journal root and machine.
+type auditEventKey struct {
+	source    string
+	timestamp int64
+	serial    int64
+}
+
+// auditEvent is an audit event whose records are being collected
+type auditEvent struct {
+	serial   int64
+	time     time.Time
+	event    common.MapStr
+	types    []string
+	records  common.MapStr
+	messages []string
+}
+
+func newAuditCorrelator(timeout time.Duration) *auditCorrelator {
+	return &auditCorrelator{timeout: timeout, events: make(map[auditEventKey]*auditEvent)}
+}
+
+// add adds the record of an event marked with auditRecordKey to its audit
+// event. It returns the audit event once it is complete, that is at its EOE
+// record or right away for the single record events of user space. late
+// tells that the record is the EOE of an event that was published by the
+// timeout already, only its cursor remains to be saved.
+func (c *auditCorrelator) add(event common.MapStr) (complete common.MapStr, late bool) {
+	rec := event[auditRecordKey].(*auditRecord)
+	delete(event, auditRecordKey)
+
+	source, _ := event[logBufferKey].(string)
+	key := auditEventKey{source: source, timestamp: rec.timestamp, serial: rec.serial}
+	ev, found := c.events[key]
+	if !found {
+		if rec.recordType == "EOE" {
+			return nil, true
+		}
+		ev = &auditEvent{serial: rec.serial, time: time.Now(), event: event, records: common.MapStr{}}
+		c.events[key] = ev
+	} else {
+		// the cursor of the last part is the one to save
+		ev.event["cursor"] = event["cursor"]
+	}
+
+	if rec.recordType != "EOE" {
+		ev.add(rec, event["message"].(string))
+	}
+	if rec.recordType == "EOE" || isUserAuditRecord(rec.code) {
+		delete(c.events, key)
+		return ev.complete(), false
+	}
+	return nil, false
+}
+
+// expire returns the audit events that waited longer than the timeout for
+// their EOE record, in the order of their timestamps and serials, and
+// forgets them
+func (c *auditCorrelator) expire(now time.Time) []common.MapStr {
+	var keys []auditEventKey
+	for key, ev := range c.events {
+		if now.Sub(ev.time) >= c.timeout {
+			keys = append(keys, key)
+		}
+	}
+	return c.remove(keys)
+}
+
+// flush returns all the pending audit events and forgets them
+func (c *auditCorrelator) flush() []common.MapStr {
+	keys := make([]auditEventKey, 0, len(c.events))
+	for key := range c.events {
+		keys = append(keys, key)
+	}
+	return c.remove(keys)
+}
+
+func (c *auditCorrelator) remove(keys []auditEventKey) []common.MapStr {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].timestamp != keys[j].timestamp {
+			return keys[i].timestamp < keys[j].timestamp
+		}
+		if keys[i].serial != keys[j].serial {
+			return keys[i].serial < keys[j].serial
+		}
+		return keys[i].source < keys[j].source
+	})
+	events := make([]common.MapStr, 0, len(keys))
+	for _, key := range keys {
+		events = append(events, c.events[key].complete())
+		delete(c.events, key)
+	}
+	return events
+}
+
+// add stores the values of a record under the lower case name of its type,
+// the values of repeated types, e.g. PATH, become a list
+func (ev *auditEvent) add(rec *auditRecord, message string) {
+	ev.types = append(ev.types, rec.recordType)
+	ev.messages = append(ev.messages, message)
+
+	values := common.MapStr{}
+	for k, v := range rec.values {
+		switch k {
+		case "record_type", "record_type_code", "serial":
+		default:
+			values[k] = v
+		}
+	}
+	key := strings.ToLower(rec.recordType)
+	switch prev := ev.records[key].(type) {
+	case nil:
+		ev.records[key] = values
+	case []common.MapStr:
+		ev.records[key] = append(prev, values)
+	default:
+		ev.records[key] = []common.MapStr{prev.(common.MapStr), values}
+	}
+}
+
+// complete returns the event of the first record with the records of all
+// parts and their messages, one per line
+func (ev *auditEvent) complete() common.MapStr {
+	audit := common.MapStr{
+		"serial":       ev.serial,
+		"record_types": ev.types,
+	}
+	for k, v := range ev.records {
+		audit[k] = v
+	}
+	ev.event[auditEventType] = audit
+	ev.event["message"] = strings.Join(ev.messages, "\n")
+	return ev.event
+}
+
+// isUserAuditRecord tells if a record type is one of the user space
+// messages, which are events of their own
+func isUserAuditRecord(code int) bool {
+	return code >= 1100 && code < 1300 || code >= 2100 && code < 3000
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestParseAuditRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   common.MapStr
+	}{
+		{
+			name: "syscall",
+			fields: map[string]string{
+				"_AUDIT_TYPE": "1300",
+				"_AUDIT_ID":   "42",
+				"MESSAGE":     `arch=c000003e syscall=59 success=yes exit=0 pid=7 auid=1000 comm="ls" exe="/usr/bin/ls" key=(null)`,
+			},
+			want: common.MapStr{
+				"record_type": "SYSCALL", "record_type_code": 1300, "serial": int64(42),
+				"arch": "x86_64", "syscall": int64(59), "success": true, "exit": int64(0),
+				"pid": int64(7), "auid": int64(1000), "comm": "ls", "exe": "/usr/bin/ls",
+			},
+		},
+		{
+			name: "encoded",
+			fields: map[string]string{
+				"_AUDIT_TYPE": "1327",
+				"_AUDIT_ID":   "42",
+				"MESSAGE":     `proctitle=6C73002D6C61 comm=6D7920636F6D6D`,
+			},
+			want: common.MapStr{
+				"record_type": "PROCTITLE", "record_type_code": 1327, "serial": int64(42),
+				"proctitle": "ls -la", "comm": "my comm",
+			},
+		},
+		{
+			name: "not encoded",
+			fields: map[string]string{
+				"_AUDIT_TYPE": "1302",
+				"_AUDIT_ID":   "42",
+				// quoted, odd length, lower case and non-hex values are kept
+				"MESSAGE": `name="6C73" cwd=ABC key=cafe dir=/tmp`,
+			},
+			want: common.MapStr{
+				"record_type": "PATH", "record_type_code": 1302, "serial": int64(42),
+				"name": "6C73", "cwd": "ABC", "key": "cafe", "dir": "/tmp",
+			},
+		},
+		{
+			name: "config change",
+			fields: map[string]string{
+				"_AUDIT_TYPE": "1305",
+				"_AUDIT_ID":   "42",
+				"MESSAGE":     `op=set audit_enabled=1 old=10 new=AB res=1`,
+			},
+			want: common.MapStr{
+				"record_type": "CONFIG_CHANGE", "record_type_code": 1305, "serial": int64(42),
+				"op": "set", "audit_enabled": "1", "old": "10", "new": "AB", "res": "1",
+			},
+		},
+		{
+			name: "execve",
+			fields: map[string]string{
+				"_AUDIT_TYPE": "1309",
+				"_AUDIT_ID":   "42",
+				"MESSAGE":     `argc=3 a0="echo" a1=68656C6C6F20776F726C64 a2="A1"`,
+			},
+			want: common.MapStr{
+				"record_type": "EXECVE", "record_type_code": 1309, "serial": int64(42),
+				"argc": int64(3), "args": []interface{}{"echo", "hello world", "A1"},
+			},
+		},
+		{
+			name: "user space",
+			fields: map[string]string{
+				"_AUDIT_TYPE": "1112",
+				"_AUDIT_ID":   "43",
+				"MESSAGE":     `pid=9 uid=0 auid=1000 msg='op=login acct="alice" exe="/usr/sbin/sshd" res=success'`,
+			},
+			want: common.MapStr{
+				"record_type": "USER_LOGIN", "record_type_code": 1112, "serial": int64(43),
+				"pid": int64(9), "uid": int64(0), "auid": int64(1000),
+				"op": "login", "acct": "alice", "exe": "/usr/sbin/sshd", "res": "success",
+			},
+		},
+		{
+			name: "raw header",
+			fields: map[string]string{
+				"MESSAGE": `type=CWD msg=audit(1500000000.123:44): cwd="/root"`,
+			},
+			want: common.MapStr{
+				"record_type": "CWD", "serial": int64(44), "cwd": "/root",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := parseAuditRecord(tt.fields)
+			if !reflect.DeepEqual(rec.values, tt.want) {
+				t.Errorf("values = %v, want %v", rec.values, tt.want)
+			}
+		})
+	}
+
+	rec := parseAuditRecord(map[string]string{"MESSAGE": `type=EOE msg=audit(1500000000.123:44): `})
+	if rec.timestamp != 1500000000123000 {
+		t.Errorf("timestamp = %d, want 1500000000123000", rec.timestamp)
+	}
+}
+
+// auditTestEvent is the event of an audit record of a source as the log
+// processor gets it
+func auditTestEvent(source, timestamp, serial, recordType, message string) common.MapStr {
+	fields := map[string]string{
+		"_AUDIT_ID":                  serial,
+		"_SOURCE_REALTIME_TIMESTAMP": timestamp,
+		"MESSAGE":                    recordType + " " + message,
+	}
+	return common.MapStr{
+		"message":      message,
+		"cursor":       source + ";" + serial + ";" + recordType,
+		logBufferKey:   source,
+		auditRecordKey: parseAuditRecord(fields),
+	}
+}
+
+func TestAuditCorrelator(t *testing.T) {
+	c := newAuditCorrelator(time.Minute)
+	add := func(event common.MapStr) common.MapStr {
+		complete, late := c.add(event)
+		if late {
+			t.Fatalf("%v is late", event)
+		}
+		return complete
+	}
+	// two machines count the same serials, a machine counts them again after
+	// a reboot
+	for _, event := range []common.MapStr{
+		auditTestEvent("a", "1000", "42", "SYSCALL", "syscall=59"),
+		auditTestEvent("b", "1000", "42", "SYSCALL", "syscall=2"),
+		auditTestEvent("a", "5000", "42", "SYSCALL", "syscall=3"),
+		auditTestEvent("a", "1000", "42", "CWD", `cwd="/a"`),
+		auditTestEvent("b", "1000", "42", "CWD", `cwd="/b"`),
+	} {
+		if complete := add(event); complete != nil {
+			t.Fatalf("%v completed early", complete)
+		}
+	}
+	complete := add(auditTestEvent("b", "1000", "42", "EOE", ""))
+	if complete == nil {
+		t.Fatal("the EOE record did not complete the event")
+	}
+	if complete["cursor"] != "b;42;EOE" || complete["message"] != "syscall=2\ncwd=\"/b\"" {
+		t.Errorf("completed event = %v", complete)
+	}
+
+	pending := c.flush()
+	if len(pending) != 2 {
+		t.Fatalf("flushed %d events, want 2", len(pending))
+	}
+	if pending[0]["message"] != "syscall=59\ncwd=\"/a\"" || pending[1]["message"] != "syscall=3" {
+		t.Errorf("flushed events = %v", pending)
+	}
+
+	// the EOE of a flushed event only has its cursor to save
+	complete, late := c.add(auditTestEvent("a", "1000", "42", "EOE", ""))
+	if complete != nil || !late {
+		t.Errorf("late EOE = %v, %t, want nil, true", complete, late)
+	}
+}
+
+func TestAuditLateEOECursor(t *testing.T) {
+	jb, _ := newTestBeat(t, map[string]interface{}{
+		"parse_audit":        true,
+		"correlate_audit":    true,
+		"write_cursor_state": true,
+	}, nil)
+	entry := testEntry(0, map[string]string{
+		"_TRANSPORT":                 "audit",
+		"_AUDIT_TYPE":                "1320",
+		"_AUDIT_ID":                  "42",
+		"_SOURCE_REALTIME_TIMESTAMP": "1483326245000000",
+		"MESSAGE":                    "",
+	})
+	event := jb.convertEntry(jb.roots[0], entry)
+	saved := make(chan string, 1)
+	go func() { saved <- <-jb.roots[0].cursorChan }()
+	jb.flushOrBufferLogs(event)
+	select {
+	case cursor := <-saved:
+		if cursor != entry.Cursor {
+			t.Errorf("saved cursor %s, want %s", cursor, entry.Cursor)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cursor of the late EOE record was not saved")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"path"
+
+	"github.com/medallia/journalbeat/config"
+)
+
+// defaultClassificationRules tell container logs from host process logs.
+// They are checked after the configured rules, the last one matches every
+// entry.
+var defaultClassificationRules = []config.ClassificationRule{
+	{
+		Match:        map[string]string{containerIdField: "*"},
+		Type:         "container",
+		BufferingKey: "%{" + containerIdField + "}",
+		Fields:       []string{containerTagField, containerIdField},
+	},
+	{
+		Type:         "%{" + tagField + "}",
+		BufferingKey: "%{" + processField + "}",
+		Fields:       []string{tagField, processField},
+	},
+}
+
+// classifier assigns events to the first classification rule they match
+type classifier struct {
+	rules []*classificationRule
+}
+
+type classificationRule struct {
+	config.ClassificationRule
+	// defaultFields are the common fields plus the fields of the rule
+	defaultFields []string
+}
+
+// classification is the outcome of a rule for a journal entry
+type classification struct {
+	eventType     string
+	bufferingKey  string
+	tags          []string
+	defaultFields []string
+}
+
+func newClassifier(rules []config.ClassificationRule) *classifier {
+	c := &classifier{}
+	for _, rule := range append(append([]config.ClassificationRule{}, rules...), defaultClassificationRules...) {
+		c.rules = append(c.rules, &classificationRule{
+			ClassificationRule: rule,
+			defaultFields:      append(commonFields[:len(commonFields):len(commonFields)], rule.Fields...),
+		})
+	}
+	return c
+}
+
+// classify applies the first rule that matches the fields of an entry
+func (c *classifier) classify(fields map[string]string) classification {
+	for _, rule := range c.rules {
+		if !rule.matches(fields) {
+			continue
+		}
+		cl := classification{
+			eventType:     config.ExpandTemplate(rule.Type, fields),
+			bufferingKey:  config.ExpandTemplate(rule.BufferingKey, fields),
+			defaultFields: rule.defaultFields,
+		}
+		for _, tag := range rule.Tags {
+			if tag = config.ExpandTemplate(tag, fields); tag != "" {
+				cl.tags = append(cl.tags, tag)
+			}
+		}
+		return cl
+	}
+	// not reached, the last default rule matches everything
+	return classification{defaultFields: commonFields}
+}
+
+// matches tells if every field of the rule is present and matches its pattern
+func (rule *classificationRule) matches(fields map[string]string) bool {
+	for field, pattern := range rule.Match {
+		v, ok := fields[field]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, v); !matched {
+			return false
+		}
+	}
+	return true
+}
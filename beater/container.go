@@ -0,0 +1,222 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/medallia/journalbeat/config"
+)
+
+const (
+	// containerIdFullField is the full id docker and podman log next to the
+	// short CONTAINER_ID
+	containerIdFullField string = "CONTAINER_ID_FULL"
+
+	// containerAPIHost is the host of the API URLs, the requests go to the
+	// socket whatever it is
+	containerAPIHost string = "http://container-runtime"
+)
+
+// errNoSuchContainer is returned for containers the runtime does not know
+var errNoSuchContainer = errors.New("no such container")
+
+// containerInfo is the metadata of a container that is added to its events.
+// A nil info is cached for the containers the runtime does not know.
+type containerInfo struct {
+	name        string
+	image       string
+	imageID     string
+	imageDigest string
+	labels      map[string]string
+}
+
+// cachedContainer is a cache entry, it is refreshed after expires
+type cachedContainer struct {
+	info    *containerInfo
+	expires time.Time
+}
+
+// containerEnricher adds the name, image and labels of containers from the
+// docker compatible API of docker or podman to their events. The metadata
+// is cached by container id, unknown containers for a shorter time. While
+// the runtime is unreachable the cached metadata is used even if it
+// expired.
+type containerEnricher struct {
+	client      *http.Client
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu    sync.Mutex
+	cache *lruCache
+	// downUntil is when the runtime is asked again after it failed
+	downUntil time.Time
+}
+
+func newContainerEnricher(c config.Config) *containerEnricher {
+	socket := strings.TrimPrefix(c.ContainerSocket, "unix://")
+	dialer := &net.Dialer{Timeout: c.ContainerTimeout}
+	return &containerEnricher{
+		client: &http.Client{
+			Timeout: c.ContainerTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		ttl:         c.ContainerCacheTTL,
+		negativeTTL: c.ContainerNegativeTTL,
+		cache:       newLRUCache(c.ContainerCacheSize),
+	}
+}
+
+// enrich adds the metadata of the container of an entry under container
+func (e *containerEnricher) enrich(event common.MapStr, fields map[string]string) {
+	id := fields[containerIdFullField]
+	if id == "" {
+		id = fields[containerIdField]
+	}
+	if id == "" {
+		return
+	}
+	info := e.lookup(id, time.Now())
+	if info == nil {
+		return
+	}
+
+	if info.name != "" {
+		event.Put("container.name", info.name)
+	}
+	if info.image != "" {
+		event.Put("container.image.name", info.image)
+	}
+	if info.imageID != "" {
+		event.Put("container.image.id", info.imageID)
+	}
+	if info.imageDigest != "" {
+		event.Put("container.image.digest", info.imageDigest)
+	}
+	if len(info.labels) > 0 {
+		event.Put("container.labels", labelMap(info.labels))
+	}
+}
+
+// lookup returns the metadata of a container from the cache, or from the
+// runtime if it is missing or expired
+func (e *containerEnricher) lookup(id string, now time.Time) *containerInfo {
+	e.mu.Lock()
+	var cached *cachedContainer
+	if v, ok := e.cache.get(id); ok {
+		cached = v.(*cachedContainer)
+	}
+	if (cached != nil && now.Before(cached.expires)) || now.Before(e.downUntil) {
+		e.mu.Unlock()
+		return cachedInfo(cached)
+	}
+	e.mu.Unlock()
+
+	info, err := e.inspect(id)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case err == nil:
+		if !e.downUntil.IsZero() {
+			logp.Info("The container runtime is reachable again")
+			e.downUntil = time.Time{}
+		}
+		e.cache.add(id, &cachedContainer{info: info, expires: now.Add(e.ttl)})
+	case err == errNoSuchContainer:
+		e.cache.add(id, &cachedContainer{expires: now.Add(e.negativeTTL)})
+	default:
+		if e.downUntil.IsZero() {
+			logp.Warn("Looking up container %s failed, using the cached container metadata: %v", id, err)
+		}
+		e.downUntil = now.Add(e.negativeTTL)
+		return cachedInfo(cached)
+	}
+	return info
+}
+
+func cachedInfo(cached *cachedContainer) *containerInfo {
+	if cached == nil {
+		return nil
+	}
+	return cached.info
+}
+
+// inspect asks the runtime for the metadata of a container and the digest
+// of its image. A failed image lookup only leaves the digest out.
+func (e *containerEnricher) inspect(id string) (*containerInfo, error) {
+	var container struct {
+		Name   string
+		Image  string
+		Config struct {
+			Image  string
+			Labels map[string]string
+		}
+	}
+	if err := e.get("/containers/"+url.PathEscape(id)+"/json", &container); err != nil {
+		return nil, err
+	}
+	info := &containerInfo{
+		name:    strings.TrimPrefix(container.Name, "/"),
+		image:   container.Config.Image,
+		imageID: container.Image,
+		labels:  container.Config.Labels,
+	}
+
+	var image struct {
+		RepoDigests []string
+	}
+	if container.Image != "" {
+		if err := e.get("/images/"+url.PathEscape(container.Image)+"/json", &image); err != nil {
+			logp.Debug("journalbeat", "Looking up image %s of container %s failed: %v", container.Image, id, err)
+		} else if len(image.RepoDigests) > 0 {
+			digest := image.RepoDigests[0]
+			info.imageDigest = digest[strings.LastIndex(digest, "@")+1:]
+		}
+	}
+	return info, nil
+}
+
+// get decodes the JSON response of the API to a GET of path into v
+func (e *containerEnricher) get(path string, v interface{}) error {
+	resp, err := e.client.Get(containerAPIHost + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusNotFound:
+		return errNoSuchContainer
+	default:
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+}
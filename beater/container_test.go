@@ -0,0 +1,201 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+// testRuntime is a container runtime that serves the docker compatible API
+// on a unix socket and counts the lookups of containers
+type testRuntime struct {
+	mu      sync.Mutex
+	down    bool
+	lookups map[string]int
+}
+
+func (r *testRuntime) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		http.Error(w, "runtime down", http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/containers/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/containers/"), "/json")
+		r.lookups[id]++
+		if strings.HasPrefix(id, "unknown") {
+			http.NotFound(w, req)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Name":  "/" + id,
+			"Image": "sha256:" + id,
+			"Config": map[string]interface{}{
+				"Image":  "nginx:1.25",
+				"Labels": map[string]string{"com.docker.compose.service": "web", "team": "infra"},
+			},
+		})
+	case strings.HasPrefix(req.URL.Path, "/images/"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"RepoDigests": []string{"nginx@sha256:abc"}})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *testRuntime) setDown(down bool) {
+	r.mu.Lock()
+	r.down = down
+	r.mu.Unlock()
+}
+
+func (r *testRuntime) lookupsOf(id string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups[id]
+}
+
+// newTestRuntime serves a testRuntime on a unix socket and returns an
+// enricher that asks it
+func newTestRuntime(t *testing.T, cacheSize int) (*testRuntime, *containerEnricher) {
+	dir, err := ioutil.TempDir("", "jb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "runtime.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := &testRuntime{lookups: map[string]int{}}
+	server := httptest.NewUnstartedServer(runtime)
+	server.Listener = l
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return runtime, newContainerEnricher(config.Config{
+		ContainerSocket:      "unix://" + socket,
+		ContainerTimeout:     time.Second,
+		ContainerCacheSize:   cacheSize,
+		ContainerCacheTTL:    time.Minute,
+		ContainerNegativeTTL: 10 * time.Second,
+	})
+}
+
+func TestContainerEnrich(t *testing.T) {
+	_, e := newTestRuntime(t, 10)
+	event := common.MapStr{}
+	e.enrich(event, map[string]string{"CONTAINER_ID": "abc", "CONTAINER_ID_FULL": "abcdef"})
+	want := common.MapStr{"container": common.MapStr{
+		"name":   "abcdef",
+		"image":  common.MapStr{"name": "nginx:1.25", "id": "sha256:abcdef", "digest": "sha256:abc"},
+		"labels": common.MapStr{"com_docker_compose_service": "web", "team": "infra"},
+	}}
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("event = %v, want %v", event, want)
+	}
+}
+
+func TestContainerCache(t *testing.T) {
+	runtime, e := newTestRuntime(t, 10)
+	now := time.Now()
+
+	// known containers are cached for the TTL
+	for _, at := range []time.Duration{0, time.Second, 59 * time.Second} {
+		if info := e.lookup("abc", now.Add(at)); info == nil || info.name != "abc" {
+			t.Fatalf("lookup after %v = %+v", at, info)
+		}
+	}
+	if n := runtime.lookupsOf("abc"); n != 1 {
+		t.Errorf("looked up a cached container %d times, want 1", n)
+	}
+	e.lookup("abc", now.Add(time.Minute))
+	if n := runtime.lookupsOf("abc"); n != 2 {
+		t.Errorf("looked up an expired container %d times, want 2", n)
+	}
+
+	// unknown containers for the negative TTL
+	for _, at := range []time.Duration{0, 9 * time.Second} {
+		if info := e.lookup("unknown", now.Add(at)); info != nil {
+			t.Fatalf("lookup of an unknown container = %+v", info)
+		}
+	}
+	if n := runtime.lookupsOf("unknown"); n != 1 {
+		t.Errorf("looked up an unknown container %d times, want 1", n)
+	}
+	e.lookup("unknown", now.Add(10*time.Second))
+	if n := runtime.lookupsOf("unknown"); n != 2 {
+		t.Errorf("looked up an unknown container %d times after the negative TTL, want 2", n)
+	}
+}
+
+func TestContainerRuntimeDown(t *testing.T) {
+	runtime, e := newTestRuntime(t, 10)
+	now := time.Now()
+	e.lookup("abc", now)
+
+	// the expired metadata is used while the runtime is down, which is only
+	// asked again after the negative TTL
+	runtime.setDown(true)
+	later := now.Add(2 * time.Minute)
+	if info := e.lookup("abc", later); info == nil || info.name != "abc" {
+		t.Fatalf("lookup while the runtime is down = %+v, want the cached metadata", info)
+	}
+	if info := e.lookup("other", later.Add(time.Second)); info != nil {
+		t.Errorf("lookup of an uncached container while the runtime is down = %+v", info)
+	}
+	if n := runtime.lookupsOf("other"); n != 0 {
+		t.Errorf("asked the runtime that is down %d times, want 0", n)
+	}
+
+	runtime.setDown(false)
+	if info := e.lookup("other", later.Add(10*time.Second)); info == nil || info.name != "other" {
+		t.Errorf("lookup after the runtime is back = %+v", info)
+	}
+	if !e.downUntil.IsZero() {
+		t.Errorf("the runtime is still down until %v", e.downUntil)
+	}
+}
+
+func TestContainerCacheEviction(t *testing.T) {
+	runtime, e := newTestRuntime(t, 2)
+	now := time.Now()
+	for _, id := range []string{"a", "b", "a", "c", "a", "b"} {
+		e.lookup(id, now)
+	}
+	// c evicted b, the least recently used, a stayed cached
+	want := map[string]int{"a": 1, "b": 2, "c": 1}
+	for id, n := range want {
+		if got := runtime.lookupsOf(id); got != n {
+			t.Errorf("looked up %s %d times, want %d", id, got, n)
+		}
+	}
+}
@@ -18,19 +18,109 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/coreos/go-systemd/sdjournal"
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+	"github.com/medallia/journalbeat/journal"
 )
 
+// defaultFieldSchema holds the types of the well-known journal fields, see
+// systemd.journal-fields(7). Fields that are not in the schema are strings.
+var defaultFieldSchema = FieldSchema{
+	"PRIORITY":                    config.FieldTypeInteger,
+	"SYSLOG_FACILITY":             config.FieldTypeInteger,
+	"SYSLOG_PID":                  config.FieldTypeInteger,
+	"ERRNO":                       config.FieldTypeInteger,
+	"CODE_LINE":                   config.FieldTypeInteger,
+	"TID":                         config.FieldTypeInteger,
+	"_PID":                        config.FieldTypeInteger,
+	"_UID":                        config.FieldTypeInteger,
+	"_GID":                        config.FieldTypeInteger,
+	"_AUDIT_SESSION":              config.FieldTypeInteger,
+	"_AUDIT_LOGINUID":             config.FieldTypeInteger,
+	"_SOURCE_REALTIME_TIMESTAMP":  config.FieldTypeInteger,
+	"_SOURCE_MONOTONIC_TIMESTAMP": config.FieldTypeInteger,
+	"OBJECT_PID":                  config.FieldTypeInteger,
+	"OBJECT_UID":                  config.FieldTypeInteger,
+	"OBJECT_GID":                  config.FieldTypeInteger,
+	"OBJECT_AUDIT_SESSION":        config.FieldTypeInteger,
+	"OBJECT_AUDIT_LOGINUID":       config.FieldTypeInteger,
+	"COREDUMP_PID":                config.FieldTypeInteger,
+	"COREDUMP_UID":                config.FieldTypeInteger,
+	"COREDUMP_GID":                config.FieldTypeInteger,
+	"COREDUMP_SIGNAL":             config.FieldTypeInteger,
+	"COREDUMP_TIMESTAMP":          config.FieldTypeInteger,
+}
+
+// unconvertedKey holds the values that failed to convert to their type, as
+// strings under their key
+const unconvertedKey = "unconverted"
+
+// FieldSchema maps journal field names to the type their values are
+// converted to
+type FieldSchema map[string]string
+
+// NewFieldSchema returns the default schema with the given entries added or
+// replaced
+func NewFieldSchema(types map[string]string) FieldSchema {
+	schema := make(FieldSchema, len(defaultFieldSchema)+len(types))
+	for field, typ := range defaultFieldSchema {
+		schema[field] = typ
+	}
+	for field, typ := range types {
+		schema[field] = typ
+	}
+	return schema
+}
+
+// convert converts the value of a field to the type of the schema
+func (schema FieldSchema) convert(field, value string) (interface{}, error) {
+	switch schema[field] {
+	case config.FieldTypeInteger:
+		return strconv.ParseInt(value, 10, 64)
+	case config.FieldTypeFloat:
+		return strconv.ParseFloat(value, 64)
+	case config.FieldTypeBoolean:
+		return strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+}
+
+// guessValue types a value without a schema type. Booleans are converted
+// if they are spelled out, strconv.ParseBool is too forgiving, and with
+// convertToNumbers numbers are converted to unsigned or signed integers or
+// floats, whichever works first.
+func guessValue(value string, convertToNumbers bool) interface{} {
+	switch value {
+	case "true", "TRUE", "True":
+		return true
+	case "false", "FALSE", "False":
+		return false
+	}
+	if !convertToNumbers {
+		return value
+	}
+	if ui, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return ui
+	}
+	if si, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return si
+	}
+	if fl, err := strconv.ParseFloat(value, 64); err == nil {
+		return fl
+	}
+	return value
+}
+
 // MapStrFromJournalEntry takes a JournalD entry and converts it to an event
 // that is more compatible with the Elasitc products. It will perform the
 // following additional steps to an event:
-// - lowercase all fields (seriously, who wants to type caps all day?!?)
-// - remove underscores from the beginning of fields as they are reserved in
-//   ElasticSearch for metadata information
-// - fields that can be converted to numbers, will be converted to numbers
-func MapStrFromJournalEntry(ev *sdjournal.JournalEntry, cleanKeys bool, convertToNumbers bool,
-	MoveMetadataLocation string, whitelistedFields []string) common.MapStr {
+// - rename the fields with keys, e.g. JournalKeys or ECSKeys
+// - fields are converted to the type the schema assigns them, if there is
+//   one. Fields that fail to convert are returned and kept as they are under
+//   unconvertedKey, so a field never changes its type between events.
+func MapStrFromJournalEntry(ev *journal.Entry, keys KeyMapper, schema FieldSchema,
+	MoveMetadataLocation string, whitelistedFields []string) (common.MapStr, []string) {
 	m := common.MapStr{}
 	// for the sake of MoveMetadataLocation we will write all the JournalEntry data except the "message" here
 	target := m
@@ -45,20 +135,48 @@ func MapStrFromJournalEntry(ev *sdjournal.JournalEntry, cleanKeys bool, convertT
 	}
 
 	// range over the JournalEntry Fields and convert to the common.MapStr
+	var failed []string
 	for _, k := range whitelistedFields {
-		nk := makeNewKey(k, cleanKeys)
+		nk := keys(k)
 		if v, ok := ev.Fields[k]; ok {
-			nv := makeNewValue(v, convertToNumbers)
+			nv, err := schema.convert(k, v)
+			if err != nil {
+				failed = append(failed, k)
+				m.Put(unconvertedKey+"."+nk, v)
+				continue
+			}
 			// message Field should be on the top level of the event
 			if nk == "message" {
 				m[nk] = nv
 				continue
 			}
-			target[nk] = nv
+			target.Put(nk, nv)
 		}
 	}
 
-	return m
+	return m, failed
+}
+
+// KeyMapper returns the event key of a journal field, dots in the key
+// separate nested objects
+type KeyMapper func(field string) string
+
+// JournalKeys keeps the journal field names. With cleanKeys it will
+// - lowercase all fields (seriously, who wants to type caps all day?!?)
+// - remove underscores from the beginning of fields as they are reserved in
+//   ElasticSearch for metadata information
+func JournalKeys(cleanKeys bool) KeyMapper {
+	return func(field string) string {
+		return makeNewKey(field, cleanKeys)
+	}
+}
+
+// fieldKeys returns the KeyMapper of a field_mapping setting
+func fieldKeys(mapping string, cleanKeys bool) KeyMapper {
+	if mapping == config.FieldMappingECS {
+		return ECSKeys
+	}
+	return JournalKeys(cleanKeys)
 }
 
 func makeNewKey(key string, cleanKeys bool) string {
@@ -68,32 +186,3 @@ func makeNewKey(key string, cleanKeys bool) string {
 
 	return strings.TrimLeft(strings.ToLower(key), "_")
 }
-
-func makeNewValue(value string, convertToNumbers bool) interface{} {
-	switch value {
-	// convert booleans if possible
-	// strconv.ParseBool is unfortunately too forgiving,
-	// we only want the hard words
-	case "true", "TRUE", "True":
-		return true
-	case "false", "FALSE", "False":
-		return false
-	default:
-		if !convertToNumbers {
-			return value
-		}
-		// convert to unsigned integers if that works
-		if ui, err := strconv.ParseUint(value, 10, 64); err == nil {
-			return ui
-		}
-		// convert to signed integers if that works
-		if si, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return si
-		}
-		// convert to float if that works
-		if fl, err := strconv.ParseFloat(value, 64); err == nil {
-			return fl
-		}
-		return value
-	}
-}
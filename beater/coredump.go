@@ -0,0 +1,155 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+const (
+	messageIdField string = "MESSAGE_ID"
+	// coredumpMessageID is the MESSAGE_ID of the entries of systemd-coredump
+	coredumpMessageID string = "fc2e22bc6ee647b6b90729ab34a250b1"
+	// coredumpPayloadField holds the core itself if systemd-coredump stores
+	// it in the journal
+	coredumpPayloadField string = "COREDUMP"
+
+	coredumpEventType string = "coredump"
+)
+
+// coredumpClassificationRule types the entries of systemd-coredump
+var coredumpClassificationRule = config.ClassificationRule{
+	Match:        map[string]string{messageIdField: coredumpMessageID},
+	Type:         coredumpEventType,
+	BufferingKey: coredumpEventType,
+}
+
+// coredumpFields map the COREDUMP_* fields to the keys below coredump, the
+// integer ones are listed in coredumpIntFields
+var coredumpFields = map[string]string{
+	"COREDUMP_PID":               "pid",
+	"COREDUMP_UID":               "uid",
+	"COREDUMP_GID":               "gid",
+	"COREDUMP_SIGNAL":            "signal",
+	"COREDUMP_SIGNAL_NAME":       "signal_name",
+	"COREDUMP_TIMESTAMP":         "timestamp",
+	"COREDUMP_COMM":              "comm",
+	"COREDUMP_EXE":               "exe",
+	"COREDUMP_CMDLINE":           "cmdline",
+	"COREDUMP_CWD":               "cwd",
+	"COREDUMP_HOSTNAME":          "hostname",
+	"COREDUMP_UNIT":              "unit",
+	"COREDUMP_USER_UNIT":         "user_unit",
+	"COREDUMP_SLICE":             "slice",
+	"COREDUMP_CGROUP":            "cgroup",
+	"COREDUMP_OWNER_UID":         "owner_uid",
+	"COREDUMP_FILENAME":          "filename",
+	"COREDUMP_PACKAGE_NAME":      "package.name",
+	"COREDUMP_PACKAGE_VERSION":   "package.version",
+	"COREDUMP_CONTAINER_CMDLINE": "container.cmdline",
+}
+
+var coredumpIntFields = map[string]struct{}{
+	"COREDUMP_PID": {}, "COREDUMP_UID": {}, "COREDUMP_GID": {}, "COREDUMP_SIGNAL": {},
+	"COREDUMP_TIMESTAMP": {}, "COREDUMP_OWNER_UID": {},
+}
+
+// signalNames are the names of the Linux signals, indexed by their number
+var signalNames = []string{
+	"", "SIGHUP", "SIGINT", "SIGQUIT", "SIGILL", "SIGTRAP", "SIGABRT", "SIGBUS",
+	"SIGFPE", "SIGKILL", "SIGUSR1", "SIGSEGV", "SIGUSR2", "SIGPIPE", "SIGALRM", "SIGTERM",
+	"SIGSTKFLT", "SIGCHLD", "SIGCONT", "SIGSTOP", "SIGTSTP", "SIGTTIN", "SIGTTOU", "SIGURG",
+	"SIGXCPU", "SIGXFSZ", "SIGVTALRM", "SIGPROF", "SIGWINCH", "SIGIO", "SIGPWR", "SIGSYS",
+}
+
+var (
+	// stackFrame is a frame of the stack trace of systemd-coredump, e.g.
+	// "#0  0x00007f3c0e8a8e97 raise (libc.so.6 + 0x3ee97)"
+	stackFrame = regexp.MustCompile(`^\s*#[0-9]+\s+0x[0-9a-f]+\s+(.*)$`)
+	// containerScope finds the container of a cgroup of docker, podman or
+	// cri-o
+	containerScope = regexp.MustCompile(`(?:docker|libpod|crio)[-/]([0-9a-f]{64})`)
+)
+
+// parseCoredump adds the COREDUMP_* fields of an entry of systemd-coredump
+// to the event under coredump, with the frames of the crashed thread as
+// coredump.stack. The core itself is only added, base64 encoded, with
+// payload set.
+func parseCoredump(event common.MapStr, fields map[string]string, payload bool) {
+	coredump := common.MapStr{}
+	for field, key := range coredumpFields {
+		value, ok := fields[field]
+		if !ok {
+			continue
+		}
+		if _, ok := coredumpIntFields[field]; ok {
+			if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+				coredump.Put(key, i)
+				continue
+			}
+		}
+		coredump.Put(key, value)
+	}
+	if _, ok := coredump["signal_name"]; !ok {
+		if signal, ok := coredump["signal"].(int64); ok && signal > 0 && int(signal) < len(signalNames) {
+			coredump["signal_name"] = signalNames[signal]
+		}
+	}
+	if m := containerScope.FindStringSubmatch(fields["COREDUMP_CGROUP"]); m != nil {
+		coredump.Put("container.id", m[1])
+	}
+	if stack := crashedThreadStack(fields[messageField]); stack != nil {
+		coredump["stack"] = stack
+	}
+	if core, ok := fields[coredumpPayloadField]; ok && payload {
+		coredump["payload"] = base64.StdEncoding.EncodeToString([]byte(core))
+	}
+	event[coredumpEventType] = coredump
+}
+
+// crashedThreadStack returns the frames of the first stack trace of the
+// message, which is the one of the thread that crashed, without their
+// addresses so that crashes of the same code look the same
+func crashedThreadStack(message string) []string {
+	var frames []string
+	inStack := false
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, "Stack trace of thread") {
+			if inStack {
+				break
+			}
+			inStack = true
+			continue
+		}
+		if !inStack {
+			continue
+		}
+		m := stackFrame.FindStringSubmatch(line)
+		if m == nil {
+			if frames != nil {
+				break
+			}
+			continue
+		}
+		frames = append(frames, m[1])
+	}
+	return frames
+}
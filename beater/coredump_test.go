@@ -0,0 +1,145 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// testCoredumpMessage is a MESSAGE of systemd-coredump for a process that
+// aborted in its main thread while another thread polled
+const testCoredumpMessage = `Process 4242 (nginx) of user 0 dumped core.
+
+Module linux-vdso.so.1 with build-id 0b54c3e5a8dfa4f2c8e1d3b2a59e76f1c0d4e8a9
+Module libpthread.so.0 with build-id 2c3eb8d5c9e1f7a64a3b0e5d7f9c8b1a6e2d4f03
+Module libc.so.6 with build-id 8c3ab4f0cd4b0bd5e2f3d0f0a1e9a4c9d7a6b5f4
+Module nginx with build-id 5e1a7c9b3d2f4a6e8c0b1d3f5a7c9e2b4d6f8a0c
+Stack trace of thread 4242:
+#0  0x00007f3c0e8a8e97 raise (libc.so.6 + 0x3ee97)
+#1  0x00007f3c0e8aa801 abort (libc.so.6 + 0x40801)
+#2  0x000055d1c3a0b2f1 ngx_debug_point (nginx + 0x4c2f1)
+#3  0x000055d1c3a0a123 n/a (nginx + 0x4b123)
+
+Stack trace of thread 4250:
+#0  0x00007f3c0e97a9d0 __poll (libc.so.6 + 0x1109d0)
+#1  0x00007f3c0ec566db start_thread (libpthread.so.0 + 0x76db)
+#2  0x00007f3c0e98b88f __clone (libc.so.6 + 0x12188f)`
+
+func TestParseCoredump(t *testing.T) {
+	const core = "\x7fELF\x02\x01\x01\x00core"
+	fields := map[string]string{
+		"MESSAGE":           testCoredumpMessage,
+		"MESSAGE_ID":        coredumpMessageID,
+		"SYSLOG_IDENTIFIER": "systemd-coredump",
+		"PRIORITY":          "2",
+		"COREDUMP_PID":      "4242",
+		"COREDUMP_UID":      "0",
+		"COREDUMP_SIGNAL":   "6",
+		"COREDUMP_COMM":     "nginx",
+		"COREDUMP_EXE":      "/usr/sbin/nginx",
+		"COREDUMP_UNIT":     "nginx.service",
+		"COREDUMP_CGROUP":   "/system.slice/docker-4f1c2d9e0b8a7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d.scope",
+		"COREDUMP":          core,
+	}
+	wantStack := []string{
+		"raise (libc.so.6 + 0x3ee97)",
+		"abort (libc.so.6 + 0x40801)",
+		"ngx_debug_point (nginx + 0x4c2f1)",
+		"n/a (nginx + 0x4b123)",
+	}
+
+	for _, payload := range []bool{false, true} {
+		t.Run(map[bool]string{false: "without payload", true: "with payload"}[payload], func(t *testing.T) {
+			events := runEntries(t, map[string]interface{}{
+				"parse_coredumps":  true,
+				"coredump_payload": payload,
+			}, testEntries(fields))
+			if len(events) != 1 {
+				t.Fatalf("got %d events", len(events))
+			}
+			event := events[0]
+			if event["type"] != coredumpEventType {
+				t.Errorf("type = %v, want %s", event["type"], coredumpEventType)
+			}
+			coredump, ok := event["coredump"].(common.MapStr)
+			if !ok {
+				t.Fatalf("coredump = %#v", event["coredump"])
+			}
+			for key, want := range map[string]interface{}{
+				"pid":          int64(4242),
+				"uid":          int64(0),
+				"signal":       int64(6),
+				"signal_name":  "SIGABRT",
+				"comm":         "nginx",
+				"exe":          "/usr/sbin/nginx",
+				"unit":         "nginx.service",
+				"container.id": "4f1c2d9e0b8a7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d",
+			} {
+				if got, _ := coredump.GetValue(key); got != want {
+					t.Errorf("coredump.%s = %#v, want %#v", key, got, want)
+				}
+			}
+			if !reflect.DeepEqual(coredump["stack"], wantStack) {
+				t.Errorf("coredump.stack = %q, want %q", coredump["stack"], wantStack)
+			}
+
+			got, ok := coredump["payload"]
+			if !payload && ok {
+				t.Errorf("coredump.payload = %v without coredump_payload", got)
+			}
+			if want := base64.StdEncoding.EncodeToString([]byte(core)); payload && got != want {
+				t.Errorf("coredump.payload = %v, want %s", got, want)
+			}
+			if strings.Contains(fmt.Sprint(event), core) {
+				t.Error("the raw core was copied into the event")
+			}
+		})
+	}
+}
+
+func TestCrashedThreadStack(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{
+			name:    "no stack trace",
+			message: "Process 4242 (nginx) of user 0 dumped core.",
+		},
+		{
+			name:    "stack trace without frames",
+			message: "Process 4242 (nginx) of user 0 dumped core.\n\nStack trace of thread 4242:\n\nStack trace of thread 4250:\n#0  0x00007f3c0e97a9d0 __poll (libc.so.6 + 0x1109d0)",
+		},
+		{
+			name:    "single thread",
+			message: "Stack trace of thread 1:\n#0  0x0000000000401136 main (crash + 0x1136)\n#1  0x00007f0e6f4e50b3 __libc_start_main (libc.so.6 + 0x270b3)",
+			want:    []string{"main (crash + 0x1136)", "__libc_start_main (libc.so.6 + 0x270b3)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := crashedThreadStack(tt.message); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stack = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+// ecsCustomPrefix is where journal fields without an ECS counterpart go
+const ecsCustomPrefix = "journald.custom."
+
+// ecsFields maps journal fields to Elastic Common Schema fields, dots
+// separate nested objects
+var ecsFields = map[string]string{
+	"MESSAGE":           "message",
+	"PRIORITY":          "log.syslog.severity.code",
+	"SYSLOG_FACILITY":   "log.syslog.facility.code",
+	"SYSLOG_IDENTIFIER": "log.syslog.appname",
+	"SYSLOG_PID":        "log.syslog.procid",
+	"CODE_FILE":         "log.origin.file.name",
+	"CODE_LINE":         "log.origin.file.line",
+	"CODE_FUNC":         "log.origin.function",
+
+	"_HOSTNAME":   "host.hostname",
+	"_HOST_NAME":  "host.hostname",
+	"_MACHINE_ID": "host.id",
+	"_BOOT_ID":    "host.boot.id",
+
+	"_PID":            "process.pid",
+	"TID":             "process.thread.id",
+	"_COMM":           "process.name",
+	"_EXE":            "process.executable",
+	"_CMDLINE":        "process.command_line",
+	"_AUDIT_SESSION":  "process.audit.session",
+	"_AUDIT_LOGINUID": "process.audit.login_uid",
+	"_UID":            "user.id",
+	"_GID":            "group.id",
+
+	"_SYSTEMD_UNIT":          "systemd.unit",
+	"_SYSTEMD_USER_UNIT":     "systemd.user_unit",
+	"_SYSTEMD_SLICE":         "systemd.slice",
+	"_SYSTEMD_USER_SLICE":    "systemd.user_slice",
+	"_SYSTEMD_CGROUP":        "systemd.cgroup",
+	"_SYSTEMD_SESSION":       "systemd.session",
+	"_SYSTEMD_OWNER_UID":     "systemd.owner_uid",
+	"_SYSTEMD_INVOCATION_ID": "systemd.invocation_id",
+	"_TRANSPORT":             "systemd.transport",
+
+	"CONTAINER_ID":      "container.id",
+	"CONTAINER_ID_FULL": "container.id_full",
+	"CONTAINER_NAME":    "container.name",
+	"CONTAINER_TAG":     "container.tag",
+	"IMAGE_NAME":        "container.image.name",
+}
+
+// ECSKeys renames journal fields to Elastic Common Schema fields, fields
+// without a counterpart are kept with their clean name under journald.custom
+func ECSKeys(field string) string {
+	if key, ok := ecsFields[field]; ok {
+		return key
+	}
+	return ecsCustomPrefix + makeNewKey(field, true)
+}
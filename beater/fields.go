@@ -0,0 +1,96 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"path"
+	"sort"
+
+	"github.com/medallia/journalbeat/config"
+)
+
+// commonFields are copied into every event by default, classification rules
+// add more
+var commonFields = []string{hostNameField, messageField, priorityField}
+
+// fieldSelector implements config.FieldSelection
+type fieldSelector struct {
+	all     bool
+	include []string
+	exclude []string
+}
+
+func newFieldSelector(s config.FieldSelection) *fieldSelector {
+	return &fieldSelector{
+		all:     s.AllFields,
+		include: s.IncludeFields,
+		exclude: s.ExcludeFields,
+	}
+}
+
+// selectFields returns the fields of an entry that go into its event, in
+// sorted order. The MESSAGE field is always selected, the multiline
+// re-assembly depends on it.
+func (s *fieldSelector) selectFields(fields map[string]string, defaults []string) []string {
+	selected := make([]string, 0, len(defaults))
+	for field := range fields {
+		if field != messageField {
+			if !s.all && !contains(defaults, field) && !matchAny(s.include, field) {
+				continue
+			}
+			if matchAny(s.exclude, field) {
+				continue
+			}
+		}
+		selected = append(selected, field)
+	}
+	sort.Strings(selected)
+	return selected
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny tells if name matches one of the glob patterns, which were
+// validated with the config
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUnitOrType tells if the unit of an entry, _SYSTEMD_UNIT or
+// _SYSTEMD_USER_UNIT, matches one of the units patterns or its event type one
+// of the types patterns
+func matchUnitOrType(units, types []string, fields map[string]string, eventType string) bool {
+	if matchAny(types, eventType) {
+		return true
+	}
+	for _, unitField := range []string{systemdUnitField, userUnitField} {
+		if unit, ok := fields[unitField]; ok && matchAny(units, unit) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+	"github.com/medallia/journalbeat/grok"
+	"github.com/rcrowley/go-metrics"
+)
+
+// grokExtractor extracts fields from the messages of the entries its rules
+// select with grok patterns
+type grokExtractor struct {
+	rules []*grokRule
+}
+
+type grokRule struct {
+	config.GrokExtraction
+	patterns []*grok.Pattern
+	// matches and misses count the messages the rule applied to, they are
+	// no-ops until the rule is registered with the metrics
+	matches metrics.Counter
+	misses  metrics.Counter
+}
+
+// newGrokExtractor compiles the rules of the config, which were validated
+// with it
+func newGrokExtractor(c config.Config) *grokExtractor {
+	library := grok.NewLibrary(c.GrokPatterns)
+	e := &grokExtractor{}
+	for _, extraction := range c.Grok {
+		rule := &grokRule{
+			GrokExtraction: extraction,
+			matches:        metrics.NilCounter{},
+			misses:         metrics.NilCounter{},
+		}
+		for _, pattern := range extraction.Patterns {
+			if p, err := library.Compile(pattern); err == nil {
+				rule.patterns = append(rule.patterns, p)
+			}
+		}
+		e.rules = append(e.rules, rule)
+	}
+	return e
+}
+
+// register counts the matches and misses of every rule in the registry
+func (e *grokExtractor) register(registry metrics.Registry) {
+	for _, rule := range e.rules {
+		rule.matches = metrics.NewRegisteredCounter("GrokMatches."+rule.Name, registry)
+		rule.misses = metrics.NewRegisteredCounter("GrokMisses."+rule.Name, registry)
+	}
+}
+
+// extract adds the captures of the first pattern of the first rule selecting
+// an entry that matches its message to the event. It returns the fields
+// whose values failed to convert, their values are kept under
+// unconvertedKey.
+func (e *grokExtractor) extract(event common.MapStr, fields map[string]string) []string {
+	var rule *grokRule
+	for _, r := range e.rules {
+		if matchUnitOrType(r.Units, r.Identifiers, fields, fields[tagField]) {
+			rule = r
+			break
+		}
+	}
+	if rule == nil {
+		return nil
+	}
+
+	message := fields[messageField]
+	for _, pattern := range rule.patterns {
+		values, failed, ok := pattern.Match(message)
+		if !ok {
+			continue
+		}
+		rule.matches.Inc(1)
+		for field, value := range values {
+			if rule.Target != "" {
+				field = rule.Target + "." + field
+			} else if _, reserved := reservedKeys[strings.SplitN(field, ".", 2)[0]]; reserved {
+				continue
+			}
+			event.Put(field, value)
+		}
+		var names []string
+		for field, value := range failed {
+			if rule.Target != "" {
+				field = rule.Target + "." + field
+			}
+			names = append(names, field)
+			event.Put(unconvertedKey+"."+field, value)
+		}
+		return names
+	}
+	rule.misses.Inc(1)
+	return nil
+}
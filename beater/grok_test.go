@@ -0,0 +1,77 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestGrokReservedFields(t *testing.T) {
+	// Validate rejects these patterns, extract skips the reserved captures
+	// of rules that were not validated all the same
+	e := newGrokExtractor(config.Config{Grok: []config.GrokExtraction{{
+		Name:     "api",
+		Units:    []string{"api.service"},
+		Patterns: []string{`^%{INT:message:int} %{WORD:type} %{WORD:cursor.id} %{WORD:user}$`},
+	}}})
+	event := common.MapStr{"message": "200 GET abc bob", "type": "api"}
+	failed := e.extract(event, map[string]string{"_SYSTEMD_UNIT": "api.service", "MESSAGE": "200 GET abc bob"})
+	if len(failed) != 0 {
+		t.Errorf("failed = %v, want none", failed)
+	}
+	want := common.MapStr{"message": "200 GET abc bob", "type": "api", "user": "bob"}
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("event = %v, want %v", event, want)
+	}
+}
+
+func TestGrokCounters(t *testing.T) {
+	entries := testEntries(
+		map[string]string{"MESSAGE": "Accepted publickey for alice from 10.0.0.1 port 51234 ssh2", "_SYSTEMD_UNIT": "sshd.service"},
+		map[string]string{"MESSAGE": "Server listening on 0.0.0.0 port 22.", "_SYSTEMD_UNIT": "sshd.service"},
+		map[string]string{"MESSAGE": "Invalid user oracle from 198.51.100.7 port 33810", "_SYSTEMD_UNIT": "sshd.service"},
+		map[string]string{"MESSAGE": "started", "_SYSTEMD_UNIT": "cron.service"},
+	)
+	jb, client := newTestBeat(t, map[string]interface{}{
+		"grok": []map[string]interface{}{
+			{"name": "sshd-counters", "units": []string{"sshd.service"}, "patterns": []string{"%{SSHD}"}},
+		},
+	}, entries)
+	registry := metrics.NewRegistry()
+	jb.grok.register(registry)
+	events := runTestBeat(t, jb, client)
+	if len(events) != len(entries) {
+		t.Fatalf("got %d events", len(events))
+	}
+	if name, _ := events[0].GetValue("user.name"); name != "alice" {
+		t.Errorf("user.name = %v, want alice", name)
+	}
+
+	for name, want := range map[string]int64{"GrokMatches.sshd-counters": 2, "GrokMisses.sshd-counters": 1} {
+		counter, ok := registry.Get(name).(metrics.Counter)
+		if !ok {
+			t.Errorf("%s is not registered", name)
+			continue
+		}
+		if got := counter.Count(); got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+}
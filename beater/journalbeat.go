@@ -19,17 +19,22 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/coreos/go-systemd/sdjournal"
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/op"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/libbeat/processors"
 	"github.com/elastic/beats/libbeat/publisher"
 	"github.com/medallia/journalbeat/config"
+	"github.com/medallia/journalbeat/filter"
 	"github.com/medallia/journalbeat/journal"
+	"github.com/medallia/journalbeat/journalfile"
 	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/go-metrics-wavefront"
 	"hash/fnv"
@@ -47,13 +52,19 @@ func hash(s string) int {
 	return int(h.Sum32())
 }
 
-func getPartition(lb *LogBuffer, numPartitions int) int {
+// getPartition picks the logstash instance of an event. tagKey is the key of
+// the container tag in the event, it depends on the field mapping.
+func getPartition(lb *LogBuffer, numPartitions int, partitionBy string, tagKey string) int {
 	partition := 0
-	if tag, ok := lb.logEvent["container_tag"]; ok {
+	if uid, ok := valueOf(lb.logEvent, "kubernetes.pod.uid").(string); ok && partitionBy == config.PartitionPod {
+		// same pod - same instance, the containers of a pod are
+		// aggregated together
+		partition = hash(uid) % numPartitions
+	} else if tag, ok := valueOf(lb.logEvent, tagKey).(string); ok {
 		// same container - same instance
 		// Assuming equal config - if container moves, it should still
 		// end up at same logstash instance
-		partition = hash(tag.(string)) % numPartitions
+		partition = hash(tag) % numPartitions
 	} else if buftype, ok := lb.logEvent["logBufferingType"]; ok {
 		// journalbeat does re-assembly based on logBufferingType
 		partition = hash(buftype.(string)) % numPartitions
@@ -79,11 +90,42 @@ const (
 	timestampField string = "_SOURCE_REALTIME_TIMESTAMP"
 	priorityField  string = "PRIORITY"
 
+	// readerRestartedType is the type of the events reporting a journal reopen
+	readerRestartedType string = "reader_restarted"
+	// journalGapType is the type of the events reporting lost journal entries
+	journalGapType string = "journal_gap"
+
+	// completeEventKey marks the events that flushOrBufferLogs publishes
+	// right away, e.g. decoded JSON messages, it is removed before they are
+	// published
+	completeEventKey string = "completeEvent"
+	// logBufferKey is the multiline buffer of an event, its buffering key
+	// qualified by the journal root and machine it was read from. It is
+	// removed before the event is published.
+	logBufferKey string = "logBuffer"
+
+	systemdUnitField string = "_SYSTEMD_UNIT"
+	userUnitField    string = "_SYSTEMD_USER_UNIT"
+	machineIdField   string = "_MACHINE_ID"
+
 	channelSize   int   = 1000
 	microseconds  int64 = 1000000
 	microsToNanos int64 = 1000
 )
 
+// journalRoot is a journal that is read, tagged and tracked independently of
+// the others. Without journal_directories there is a single root for the
+// journal of the local machine.
+type journalRoot struct {
+	path            string
+	journal         journal.Source
+	cursorStateFile string
+	cursorChan      chan string
+	// seekedCursor is the saved cursor the journal was positioned on, its
+	// entry was published before
+	seekedCursor string
+}
+
 // Journalbeat is the main Journalbeat struct
 type Journalbeat struct {
 	done                 chan struct{}
@@ -92,18 +134,133 @@ type Journalbeat struct {
 	logstashClients      []publisher.Client
 	numLogstashAvailable int //corresponds to the number of downstream logstash aggregators available at startup.
 
-	journal *sdjournal.Journal
+	roots       []*journalRoot
+	rootsByPath map[string]*journalRoot
+	filter      filter.Node
+
+	classifier *classifier
+	// fields selects the fields of the events, fieldOverrides by event type
+	fields         *fieldSelector
+	fieldOverrides map[string]*fieldSelector
+	// logfmt parses key=value messages, it is nil without logfmt rules
+	logfmt *logfmtParser
+	// grok extracts fields with grok patterns, it is nil without grok rules
+	grok *grokExtractor
+	// audits groups the records of audit events, it is nil without
+	// correlate_audit
+	audits *auditCorrelator
+	// kernel assembles kernel reports, it is nil without parse_kernel
+	kernel *kernelAssembler
+	// json decodes JSON messages, it is nil without json_decoding rules
+	json *jsonDecoder
+	// severities drops entries below their minimum severity, it is nil if
+	// every severity passes
+	severities *severityFilter
+	// containers adds the metadata of containers, it is nil without
+	// container_metadata
+	containers *containerEnricher
+	// kubernetes adds the metadata of pods, it is nil without
+	// kubernetes_metadata
+	kubernetes *kubernetesEnricher
+	// ids resolves user and group ids to names, it is nil without
+	// resolve_ids
+	ids *idResolver
+	// redactor redacts sensitive data, it is nil without redaction rules
+	redactor *redactor
+	// keys renames the journal fields for the events
+	keys KeyMapper
+	// schema converts the field values, it is nil without convert_to_numbers
+	schema FieldSchema
 
-	cursorChan chan string
+	// since and until are the resolved since and until settings, a zero
+	// until follows the journal until journalbeat is stopped
+	since time.Time
+	until time.Time
 
 	journalTypeOutstandingLogBuffer map[string]*LogBuffer
 	incomingLogMessages             chan common.MapStr
+	processorDone                   chan struct{}
+
+	// pending counts the events of a bounded run that are not acknowledged yet
+	pending       sync.WaitGroup
+	cursorWriters sync.WaitGroup
 
 	logMessagesPublished metrics.Counter
 	logMessageDelay      metrics.Gauge
+	readerRestarts       metrics.Counter
+	journalGaps          metrics.Counter
+	journalEntriesLost   metrics.Counter
+	journalRotationGaps  metrics.Counter
+	conversionFailures   metrics.Counter
+	crashes              metrics.Counter
+}
+
+// cursorStateFileEscaper escapes the absolute paths of journal roots for the
+// names of their cursor state files. Escaping "%" and "_" keeps distinct roots
+// like "/a/b" and "/a_b" from sharing a file.
+var cursorStateFileEscaper = strings.NewReplacer("%", "%25", "_", "%5F", "/", "_")
+
+// cursorStateFileForRoot derives the cursor state file of a journal root from
+// the configured one, e.g. ".journalbeat-cursor-state.host_var_log_journal"
+// for /host/var/log/journal
+func cursorStateFileForRoot(cursorStateFile string, root string) string {
+	if root == "" {
+		return cursorStateFile
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return cursorStateFile + "." + strings.TrimPrefix(cursorStateFileEscaper.Replace(root), "_")
 }
 
-func (jb *Journalbeat) initJournal() error {
+// openJournal connects to the configured journal backend. An empty root
+// opens the journal of the local machine.
+func (jb *Journalbeat) openJournal(root string) (journal.Source, error) {
+	switch jb.config.Backend {
+	case config.BackendFile:
+		if root != "" {
+			return journalfile.OpenDirectory(root)
+		}
+		return journalfile.Open(jb.config.JournalFiles...)
+	case config.BackendFixture:
+		return journal.NewMemorySourceFromFixture(jb.config.FixtureFile)
+	default:
+		if root != "" {
+			return journal.NewSystemdDirectorySource(root)
+		}
+		return journal.NewSystemdSource()
+	}
+}
+
+// initJournals opens and positions one journal per configured directory, or
+// the local journal if there are none
+func (jb *Journalbeat) initJournals() error {
+	paths := jb.config.JournalDirectories
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	jb.rootsByPath = make(map[string]*journalRoot, len(paths))
+	for _, path := range paths {
+		root := &journalRoot{
+			path:            path,
+			cursorStateFile: cursorStateFileForRoot(jb.config.CursorStateFile, path),
+			cursorChan:      make(chan string),
+		}
+		jb.roots = append(jb.roots, root)
+		jb.rootsByPath[path] = root
+
+		if err := jb.initJournal(root); err != nil {
+			if path != "" {
+				return fmt.Errorf("Journal directory %s: %v", path, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (jb *Journalbeat) initJournal(root *journalRoot) error {
 	var err error
 
 	seekToHelper := func(position string, err error) error {
@@ -115,15 +272,34 @@ func (jb *Journalbeat) initJournal() error {
 		return err
 	}
 
-	// connect to the Systemd Journal
-	if jb.journal, err = sdjournal.NewJournal(); err != nil {
+	// connect to the configured journal backend
+	if root.journal, err = jb.openJournal(root.path); err != nil {
 		return err
 	}
 
 	// add specific units to monitor if any
-	for _, unit := range jb.config.Units {
-		if err = jb.journal.AddMatch(sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT + "=" + unit); err != nil {
-			return fmt.Errorf("Filtering unit %s failed: %v", unit, err)
+	if len(jb.config.Units) > 0 {
+		matcher, ok := root.journal.(journal.Matcher)
+		if !ok {
+			return fmt.Errorf("The %s backend does not support filtering units", jb.config.Backend)
+		}
+		for _, unit := range jb.config.Units {
+			if err = matcher.AddMatch(systemdUnitField + "=" + unit); err != nil {
+				return fmt.Errorf("Filtering unit %s failed: %v", unit, err)
+			}
+		}
+	}
+
+	// let the journal do as much of the filtering as it can, the rest is
+	// evaluated on every entry read
+	if jb.filter != nil {
+		if matcher, ok := root.journal.(journal.Matcher); ok {
+			if err = matcher.AddConjunction(); err == nil {
+				err = filter.Apply(jb.filter, matcher)
+			}
+			if err != nil {
+				return fmt.Errorf("Adding the filter matches failed: %v", err)
+			}
 		}
 	}
 
@@ -131,11 +307,12 @@ func (jb *Journalbeat) initJournal() error {
 	position := jb.config.SeekPosition
 	// try seekToCursor first, if that is requested
 	if position == config.SeekPositionCursor {
-		if cursor, err := ioutil.ReadFile(jb.config.CursorStateFile); err != nil {
+		if cursor, err := ioutil.ReadFile(root.cursorStateFile); err != nil {
 			logp.Warn("Could not seek to cursor: reading cursor state file failed: %v", err)
 		} else {
 			// try to seek to cursor and if successful return
-			if err = seekToHelper(config.SeekPositionCursor, jb.journal.SeekCursor(string(cursor))); err == nil {
+			if err = seekToHelper(config.SeekPositionCursor, root.journal.SeekCursor(string(cursor))); err == nil {
+				root.seekedCursor = string(cursor)
 				return nil
 			}
 		}
@@ -149,9 +326,11 @@ func (jb *Journalbeat) initJournal() error {
 
 	switch position {
 	case config.SeekPositionHead:
-		err = seekToHelper(config.SeekPositionHead, jb.journal.SeekHead())
+		err = seekToHelper(config.SeekPositionHead, root.journal.SeekHead())
 	case config.SeekPositionTail:
-		err = seekToHelper(config.SeekPositionTail, jb.journal.SeekTail())
+		err = seekToHelper(config.SeekPositionTail, root.journal.SeekTail())
+	case config.SeekPositionSince:
+		err = seekToHelper(jb.since.Format(time.RFC3339), root.journal.SeekRealtimeUsec(uint64(jb.since.UnixNano()/1000)))
 	}
 
 	if err != nil {
@@ -161,25 +340,100 @@ func (jb *Journalbeat) initJournal() error {
 	return nil
 }
 
-// WriteCursorLoop runs the loop which flushes the current cursor position to a file
-func (jb *Journalbeat) writeCursorLoop() {
+// reopenJournal replaces the journal of root after a read error. It is set
+// up like at start up, the follower seeks behind the last delivered entry.
+func (jb *Journalbeat) reopenJournal(root *journalRoot) (journal.Source, error) {
+	if root.journal != nil {
+		root.journal.Close()
+		root.journal = nil
+	}
+	if err := jb.initJournal(root); err != nil {
+		return nil, err
+	}
+	return root.journal, nil
+}
+
+// readerRestarted reports a recovered journal reader with an event and a metric
+func (jb *Journalbeat) readerRestarted(root *journalRoot, restart journal.Restart) {
+	if jb.config.MetricsEnabled {
+		jb.readerRestarts.Inc(1)
+	}
+
+	event := common.MapStr{
+		"type":         readerRestartedType,
+		"input_type":   jb.config.DefaultType,
+		"message":      fmt.Sprintf("Journal reader restarted after error: %v", restart.Err),
+		"error":        restart.Err.Error(),
+		"attempts":     restart.Attempts,
+		"downtime_ms":  int64(restart.Downtime / time.Millisecond),
+		"seek":         restart.Seek,
+		"utcTimestamp": time.Now().UnixNano() / microsToNanos,
+	}
+	if restart.Cursor != "" {
+		event["resumed_after_cursor"] = restart.Cursor
+	}
+	if root.path != "" {
+		event["source_root"] = root.path
+	}
+	jb.publish(&LogBuffer{time: time.Now(), logEvent: event, logType: readerRestartedType})
+}
+
+// gapDetected reports entries missing from a journal with an event and
+// metrics. The gaps of file rotations are counted on their own, their
+// entries are most likely not lost.
+func (jb *Journalbeat) gapDetected(root *journalRoot, gap journal.Gap) {
+	message := fmt.Sprintf("Detected a gap of %d journal entries", gap.Lost)
+	if gap.Rotation {
+		message += " after a file rotation"
+	}
+	if jb.config.MetricsEnabled {
+		if gap.Rotation {
+			jb.journalRotationGaps.Inc(1)
+		} else {
+			jb.journalGaps.Inc(1)
+			jb.journalEntriesLost.Inc(int64(gap.Lost))
+		}
+	}
+
+	event := common.MapStr{
+		"type":          journalGapType,
+		"input_type":    jb.config.DefaultType,
+		"message":       message,
+		"lost_entries":  gap.Lost,
+		"rotation":      gap.Rotation,
+		"seqnum_id":     gap.SeqnumID,
+		"after_cursor":  gap.After,
+		"before_cursor": gap.Before,
+		"after_boot_id": gap.AfterBootID,
+		"boot_id":       gap.BeforeBootID,
+		"utcTimestamp":  time.Now().UnixNano() / microsToNanos,
+	}
+	if root.path != "" {
+		event["source_root"] = root.path
+	}
+	jb.publish(&LogBuffer{time: time.Now(), logEvent: event, logType: journalGapType})
+}
+
+// WriteCursorLoop runs the loop which flushes the current cursor position of a journal root to a file
+func (jb *Journalbeat) writeCursorLoop(root *journalRoot) {
+	defer jb.cursorWriters.Done()
 	var cursor string
 	saveCursorState := func(cursor string) {
 		if cursor != "" {
-			if err := ioutil.WriteFile(jb.config.CursorStateFile, []byte(cursor), 0644); err != nil {
+			if err := ioutil.WriteFile(root.cursorStateFile, []byte(cursor), 0644); err != nil {
 				logp.Err("Could not write to cursor state file: %v", err)
 			}
 		}
 	}
 
 	// save cursor for the last time when stop signal caught
-	// Saving the cursor through defer guarantees that the jb.cursorChan has been fully consumed
+	// Saving the cursor through defer guarantees that the root.cursorChan has been fully consumed
 	// and we are writing the cursor of the last message published.
 	defer func() { saveCursorState(cursor) }()
 
 	tick := time.Tick(jb.config.CursorFlushPeriod)
 
-	for cursor = range jb.cursorChan {
+	for cursor = range root.cursorChan {
 		select {
 		case <-tick:
 			saveCursorState(cursor)
@@ -188,6 +442,35 @@ func (jb *Journalbeat) writeCursorLoop() {
 	}
 }
 
+// saveCursor hands the cursor of a published event over to the cursor writer
+// of the journal root the event was read from
+func (jb *Journalbeat) saveCursor(event common.MapStr) {
+	if !jb.config.WriteCursorState {
+		return
+	}
+	path, _ := event["source_root"].(string)
+	if root, ok := jb.rootsByPath[path]; ok {
+		root.cursorChan <- event["cursor"].(string)
+	}
+}
+
+// resolveTimeRange turns the since and until settings into timestamps, relative
+// ones are relative to now
+func (jb *Journalbeat) resolveTimeRange(now time.Time) error {
+	var err error
+	if jb.config.Since != "" {
+		if jb.since, err = config.ParseTime(jb.config.Since, now); err != nil {
+			return fmt.Errorf("Invalid since: %v", err)
+		}
+	}
+	if jb.config.Until != "" {
+		if jb.until, err = config.ParseTime(jb.config.Until, now); err != nil {
+			return fmt.Errorf("Invalid until: %v", err)
+		}
+	}
+	return nil
+}
+
 // New creates beater
 func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 	config := config.DefaultConfig
@@ -199,12 +482,83 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 	jb := &Journalbeat{
 		done:                            make(chan struct{}),
 		config:                          config,
-		cursorChan:                      make(chan string),
 		incomingLogMessages:             make(chan common.MapStr, channelSize),
 		journalTypeOutstandingLogBuffer: make(map[string]*LogBuffer),
+		processorDone:                   make(chan struct{}),
+		fields:                          newFieldSelector(config.FieldSelection),
+		fieldOverrides:                  make(map[string]*fieldSelector),
+		keys:                            fieldKeys(config.FieldMapping, config.CleanFieldNames),
+		severities:                      newSeverityFilter(config),
+		// Run registers the metrics if they can be sent, until then and
+		// without a wavefront collector they count nothing
+		logMessagesPublished: metrics.NilCounter{},
+		logMessageDelay:      metrics.NilGauge{},
+		readerRestarts:       metrics.NilCounter{},
+		journalGaps:          metrics.NilCounter{},
+		journalEntriesLost:   metrics.NilCounter{},
+		journalRotationGaps:  metrics.NilCounter{},
+		conversionFailures:   metrics.NilCounter{},
+		crashes:              metrics.NilCounter{},
+	}
+	if len(config.Logfmt) > 0 {
+		jb.logfmt = &logfmtParser{rules: config.Logfmt, convertToNumbers: config.ConvertToNumbers}
+	}
+	rules := config.ClassificationRules
+	if config.ParseAudit {
+		rules = append(rules[:len(rules):len(rules)], auditClassificationRule)
+	}
+	if config.ParseKernel {
+		rules = append(rules[:len(rules):len(rules)], kernelClassificationRule)
+		jb.kernel = newKernelAssembler(config.KernelReportTimeout, config.KernelReportMaxLines, config.KernelReportMaxAge)
+	}
+	if config.ParseCoredumps {
+		rules = append(rules[:len(rules):len(rules)], coredumpClassificationRule)
+	}
+	jb.classifier = newClassifier(rules)
+	if config.CorrelateAudit {
+		jb.audits = newAuditCorrelator(config.AuditTimeout)
+	}
+	if len(config.Grok) > 0 {
+		jb.grok = newGrokExtractor(config)
+	}
+	if config.ContainerMetadata {
+		jb.containers = newContainerEnricher(config)
+	}
+	if config.KubernetesMetadata {
+		if jb.kubernetes, err = newKubernetesEnricher(config); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.ResolveIDs) > 0 {
+		jb.ids = newIDResolver(config.ResolveIDs, config.IDRoot)
+	}
+	if len(config.Redaction) > 0 {
+		jb.redactor = newRedactor(config.Redaction)
+	}
+	if len(config.JSONDecoding) > 0 {
+		jb.json = &jsonDecoder{rules: config.JSONDecoding}
+	}
+	for eventType, selection := range config.FieldOverrides {
+		jb.fieldOverrides[eventType] = newFieldSelector(selection)
+	}
+	if config.ConvertToNumbers {
+		jb.schema = NewFieldSchema(config.FieldTypes)
+	}
+
+	if err = jb.resolveTimeRange(time.Now()); err != nil {
+		return nil, err
 	}
 
-	if err = jb.initJournal(); err != nil {
+	if config.Filter != "" {
+		if jb.filter, err = filter.Parse(config.Filter); err != nil {
+			return nil, fmt.Errorf("Invalid filter: %v", err)
+		}
+	}
+	if config.DetectGaps && (len(config.Units) > 0 || jb.filter != nil) {
+		logp.Info("Gaps are not detected, the entries of journals filtered by units or filter are not consecutive")
+	}
+
+	if err = jb.initJournals(); err != nil {
 		logp.Err("Failed to connect to the Systemd Journal: %v", err)
 		return nil, err
 	}
@@ -212,22 +566,95 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 	return jb, nil
 }
 
+// eventKey returns the key of a journal field in the events
+func (jb *Journalbeat) eventKey(field string) string {
+	key := jb.keys(field)
+	if jb.config.MoveMetadataLocation != "" && key != "message" {
+		key = jb.config.MoveMetadataLocation + "." + key
+	}
+	return key
+}
+
+// publish sends a buffered event to its logstash instance, redacted and, if
+// it has no @timestamp, stamped with the publish time. In a bounded run the event is
+// tracked until it is acknowledged.
+func (jb *Journalbeat) publish(logBuffer *LogBuffer) {
+	delete(logBuffer.logEvent, logBufferKey)
+	if jb.redactor != nil {
+		jb.redactor.redact(logBuffer.logEvent)
+	}
+	if _, ok := logBuffer.logEvent["@timestamp"]; !ok {
+		logBuffer.logEvent["@timestamp"] = common.Time(time.Now().UTC())
+	}
+	partition := getPartition(logBuffer, jb.numLogstashAvailable, jb.config.PartitionBy, jb.eventKey(containerTagField))
+	if jb.until.IsZero() {
+		jb.logstashClients[partition].PublishEvent(logBuffer.logEvent, publisher.Guaranteed)
+		return
+	}
+
+	jb.pending.Add(1)
+	acked := op.SignalCallback(func(op.SignalResponse) { jb.pending.Done() })
+	jb.logstashClients[partition].PublishEvent(logBuffer.logEvent, publisher.Guaranteed, publisher.Signal(acked))
+}
+
+// flushAllLogMessages publishes all buffered events regardless of their age
+func (jb *Journalbeat) flushAllLogMessages() {
+	for logType, logBuffer := range jb.journalTypeOutstandingLogBuffer {
+		jb.publish(logBuffer)
+		delete(jb.journalTypeOutstandingLogBuffer, logType)
+		jb.saveCursor(logBuffer.logEvent)
+	}
+	if jb.audits != nil {
+		for _, event := range jb.audits.flush() {
+			jb.publishComplete(event)
+		}
+	}
+	if jb.kernel != nil {
+		for _, event := range jb.kernel.flush() {
+			jb.publishComplete(event)
+		}
+	}
+}
+
 func (jb *Journalbeat) flushStaleLogMessages() {
 	for logType, logBuffer := range jb.journalTypeOutstandingLogBuffer {
 		if time.Now().Sub(logBuffer.time).Seconds() >= jb.config.FlushLogInterval.Seconds() {
 			//this message has been sitting in our buffer for more than 30 seconds time to flush it.
-			partition := getPartition(logBuffer, jb.numLogstashAvailable)
-			jb.logstashClients[partition].PublishEvent(logBuffer.logEvent, publisher.Guaranteed)
+			jb.publish(logBuffer)
 			delete(jb.journalTypeOutstandingLogBuffer, logType)
-			jb.cursorChan <- logBuffer.logEvent["cursor"].(string)
+			jb.saveCursor(logBuffer.logEvent)
 		}
 	}
 }
 
 func (jb *Journalbeat) flushOrBufferLogs(event common.MapStr) {
+	if _, complete := event[completeEventKey]; complete {
+		jb.publishComplete(event)
+		return
+	}
+	if _, ok := event[auditRecordKey]; ok {
+		complete, late := jb.audits.add(event)
+		if complete != nil {
+			jb.publishComplete(complete)
+		} else if late {
+			jb.saveCursor(event)
+		}
+		return
+	}
+	if _, ok := event[kernelLineKey]; ok {
+		delete(event, kernelLineKey)
+		complete, taken := jb.kernel.add(event)
+		for _, report := range complete {
+			jb.publishComplete(report)
+		}
+		if taken {
+			return
+		}
+	}
+
 	//check if it starts with space or tab
 	newLogMessage := event["message"].(string)
-	logType := event["logBufferingType"].(string)
+	logType := event[logBufferKey].(string)
 
 	if newLogMessage != "" && (newLogMessage[0] == ' ' || newLogMessage[0] == '\t') {
 		//this is a continuation of previous line
@@ -251,8 +678,8 @@ func (jb *Journalbeat) flushOrBufferLogs(event common.MapStr) {
 		}
 		if found {
 			//flush the older logs to async.
-			partition := getPartition(oldLogBuffer, jb.numLogstashAvailable)
-			jb.logstashClients[partition].PublishEvent(oldLogBuffer.logEvent, publisher.Guaranteed)
+			jb.publish(oldLogBuffer)
+			jb.saveCursor(oldLogBuffer.logEvent)
 			//update stats if enabled
 			if jb.config.MetricsEnabled {
 				jb.logMessagesPublished.Inc(1)
@@ -262,10 +689,44 @@ func (jb *Journalbeat) flushOrBufferLogs(event common.MapStr) {
 	}
 }
 
+// publishComplete publishes an event with a decoded JSON message, a coredump
+// or a correlated audit event right away. It is complete, so it is neither joined
+// with the buffered lines of its type nor buffered itself.
+func (jb *Journalbeat) publishComplete(event common.MapStr) {
+	delete(event, completeEventKey)
+	logType := event[logBufferKey].(string)
+	if oldLogBuffer, found := jb.journalTypeOutstandingLogBuffer[logType]; found {
+		delete(jb.journalTypeOutstandingLogBuffer, logType)
+		jb.publish(oldLogBuffer)
+		jb.saveCursor(oldLogBuffer.logEvent)
+	}
+
+	jb.publish(&LogBuffer{time: time.Now(), logType: logType, logEvent: event})
+	jb.saveCursor(event)
+	if jb.config.MetricsEnabled {
+		jb.logMessagesPublished.Inc(1)
+		jb.logMessageDelay.Update(time.Now().Unix() - (event["utcTimestamp"].(int64) / microseconds))
+	}
+}
+
 //TODO optimize this later but for now walkthru all the different types. Use priority queue/multiple threads if needed.
 func (jb *Journalbeat) logProcessor() {
 	logp.Info("Started the thread which consumes log messages and publishes it")
 	tickChan := time.NewTicker(jb.config.FlushLogInterval)
+	// audit events wait for their EOE record for about the audit timeout
+	var auditTick <-chan time.Time
+	if jb.audits != nil {
+		auditTicker := time.NewTicker(jb.config.AuditTimeout)
+		defer auditTicker.Stop()
+		auditTick = auditTicker.C
+	}
+	// kernel reports without an end line wait for about the report timeout
+	var kernelTick <-chan time.Time
+	if jb.kernel != nil {
+		kernelTicker := time.NewTicker(jb.config.KernelReportTimeout)
+		defer kernelTicker.Stop()
+		kernelTick = kernelTicker.C
+	}
 	for {
 		select {
 		case <-tickChan.C:
@@ -273,7 +734,27 @@ func (jb *Journalbeat) logProcessor() {
 			//which have been sitting there for some time.
 			jb.flushStaleLogMessages()
 
-		case channelEvent := <-jb.incomingLogMessages:
+		case now := <-auditTick:
+			for _, event := range jb.audits.expire(now) {
+				jb.publishComplete(event)
+			}
+
+		case now := <-kernelTick:
+			for _, event := range jb.kernel.expire(now) {
+				jb.publishComplete(event)
+			}
+
+		case channelEvent, ok := <-jb.incomingLogMessages:
+			if !ok {
+				// all journals were read up to until, nothing will be
+				// buffered anymore
+				if !jb.until.IsZero() {
+					jb.flushAllLogMessages()
+				}
+				tickChan.Stop()
+				close(jb.processorDone)
+				return
+			}
 			jb.flushOrBufferLogs(channelEvent)
 		}
 	}
@@ -305,12 +786,148 @@ func shiftlist(cfg *common.Config, target *common.Config, key string, shift int)
 	return nil
 }
 
+// convertEntry turns a journal entry read from root into an event. It returns
+// nil for entries that are less severe than the minimum severity.
+func (jb *Journalbeat) convertEntry(root *journalRoot, rawEvent *journal.Entry) common.MapStr {
+	class := jb.classifier.classify(rawEvent.Fields)
+	if jb.severities != nil && jb.severities.drop(rawEvent.Fields, class.eventType) {
+		return nil
+	}
+
+	selector := jb.fields
+	if override, ok := jb.fieldOverrides[class.eventType]; ok {
+		selector = override
+	}
+	selected := selector.selectFields(rawEvent.Fields, class.defaultFields)
+	coredump := jb.config.ParseCoredumps && rawEvent.Fields[messageIdField] == coredumpMessageID
+	if coredump {
+		// the binary core is never copied as is
+		for i, field := range selected {
+			if field == coredumpPayloadField {
+				selected = append(selected[:i], selected[i+1:]...)
+				break
+			}
+		}
+	}
+	event, failed := MapStrFromJournalEntry(
+		rawEvent,
+		jb.keys,
+		jb.schema,
+		jb.config.MoveMetadataLocation,
+		selected)
+	if jb.logfmt != nil {
+		failed = append(failed, jb.logfmt.parse(event, rawEvent.Fields)...)
+	}
+	if jb.grok != nil {
+		failed = append(failed, jb.grok.extract(event, rawEvent.Fields)...)
+	}
+	if jb.config.ParseAudit && rawEvent.Fields[transportField] == auditTransport {
+		rec := parseAuditRecord(rawEvent.Fields)
+		event[auditEventType] = rec.values
+		if jb.audits != nil && rec.serial != 0 {
+			event[auditRecordKey] = rec
+		}
+	}
+	if jb.kernel != nil && rawEvent.Fields[transportField] == kernelTransport {
+		parseKernelLine(event, rawEvent.Fields)
+		event[kernelLineKey] = true
+	}
+	if coredump {
+		parseCoredump(event, rawEvent.Fields, jb.config.CoredumpPayload)
+		event[completeEventKey] = true
+		if jb.config.MetricsEnabled {
+			jb.crashes.Inc(1)
+		}
+	}
+	if jb.containers != nil {
+		jb.containers.enrich(event, rawEvent.Fields)
+	}
+	if jb.kubernetes != nil {
+		jb.kubernetes.enrich(event, rawEvent.Fields)
+	}
+	if jb.ids != nil {
+		jb.ids.resolve(event, rawEvent.Fields)
+	}
+	if len(failed) > 0 {
+		logp.Debug("journalbeat", "Kept fields %v of %s that do not match their type under %s", failed, rawEvent.Cursor, unconvertedKey)
+		if jb.config.MetricsEnabled {
+			jb.conversionFailures.Inc(int64(len(failed)))
+		}
+	}
+	if jb.config.DecodePriority {
+		prefix := ""
+		if jb.config.MoveMetadataLocation != "" {
+			prefix = jb.config.MoveMetadataLocation + "."
+		}
+		decodePriority(event, prefix, rawEvent.Fields)
+	}
+	if jb.json != nil {
+		jb.json.decode(event, rawEvent.Fields, class.eventType)
+	}
+	event["type"] = class.eventType
+	event["logBufferingType"] = class.bufferingKey
+	// the lines of different roots and machines are never joined
+	event[logBufferKey] = root.path + "\x00" + rawEvent.Fields[machineIdField] + "\x00" + class.bufferingKey
+	if err := common.AddTags(event, class.tags); err != nil {
+		logp.Warn("Could not tag the event of type %s: %v", class.eventType, err)
+	}
+
+	event["input_type"] = jb.config.DefaultType
+	event["cursor"] = rawEvent.Cursor
+	// utcTimestamp keeps the microseconds @timestamp is rounded off from
+	utcTimestamp := int64(rawEvent.RealtimeTimestamp)
+	if tmStr, ok := rawEvent.Fields[timestampField]; ok && jb.config.TimestampSource != config.TimestampRealtime {
+		if tm, err := strconv.ParseInt(tmStr, 10, 64); err == nil {
+			utcTimestamp = tm
+		}
+	}
+	event["utcTimestamp"] = utcTimestamp
+	if jb.config.TimestampSource != config.TimestampPublish {
+		event["@timestamp"] = common.Time(time.Unix(0, utcTimestamp*microsToNanos).UTC())
+	}
+
+	// tag the events of journal directories with where they came from
+	if root.path != "" {
+		event["source_root"] = root.path
+		event["machine_id"] = rawEvent.Fields[machineIdField]
+	}
+
+	return event
+}
+
+// followRoot converts the entries of a journal root and hands them to the log
+// processor until the follower stops
+func (jb *Journalbeat) followRoot(root *journalRoot) {
+	follower := &journal.Follower{
+		Reopen:      func() (journal.Source, error) { return jb.reopenJournal(root) },
+		OnRestart:   func(restart journal.Restart) { jb.readerRestarted(root, restart) },
+		MinBackoff:  jb.config.ReopenBackoff,
+		MaxBackoff:  jb.config.ReopenMaxBackoff,
+		Until:       jb.until,
+		After:       root.seekedCursor,
+		Filtered:    len(jb.config.Units) > 0 || jb.filter != nil,
+		BufferSize:  jb.config.FollowBufferSize,
+		WaitTimeout: jb.config.WaitTimeout,
+	}
+	if jb.config.DetectGaps {
+		follower.OnGap = func(gap journal.Gap) { jb.gapDetected(root, gap) }
+	}
+	for rawEvent := range follower.Follow(root.journal, jb.done) {
+		if jb.filter != nil && !jb.filter.Match(rawEvent.Fields) {
+			continue
+		}
+		if event := jb.convertEntry(root, rawEvent); event != nil {
+			jb.incomingLogMessages <- event
+		}
+	}
+}
+
 // Run is the main event loop: read from journald and pass it to Publish
 func (jb *Journalbeat) Run(b *beat.Beat) error {
 	logp.Info("Journalbeat is running!")
 
 	if jb.config.MetricsEnabled {
-		logp.Info("Metrics are enabled. Sending to " + jb.config.WavefrontCollector)
+		logp.Info("Metrics are enabled. Sending to %s", jb.config.WavefrontCollector)
 		addr, err := net.ResolveTCPAddr("tcp", jb.config.WavefrontCollector)
 		if jb.config.WavefrontCollector != "" && err == nil {
 			logp.Info("Metrics address parsed")
@@ -319,6 +936,18 @@ func (jb *Journalbeat) Run(b *beat.Beat) error {
 			registry := metrics.DefaultRegistry
 			jb.logMessageDelay = metrics.NewRegisteredGauge("MessageConsumptionDelay", registry)
 			jb.logMessagesPublished = metrics.NewRegisteredCounter("MessagesPublished", registry)
+			jb.readerRestarts = metrics.NewRegisteredCounter("ReaderRestarts", registry)
+			jb.journalGaps = metrics.NewRegisteredCounter("JournalGaps", registry)
+			jb.journalEntriesLost = metrics.NewRegisteredCounter("JournalEntriesLost", registry)
+			jb.journalRotationGaps = metrics.NewRegisteredCounter("JournalRotationGaps", registry)
+			jb.conversionFailures = metrics.NewRegisteredCounter("FieldConversionFailures", registry)
+			jb.crashes = metrics.NewRegisteredCounter("Crashes", registry)
+			if jb.grok != nil {
+				jb.grok.register(registry)
+			}
+			if jb.redactor != nil {
+				jb.redactor.register(registry)
+			}
 
 			hostname, err := os.Hostname()
 			if err == nil {
@@ -342,18 +971,26 @@ func (jb *Journalbeat) Run(b *beat.Beat) error {
 
 			go wavefront.WavefrontWithConfig(wfConfig)
 		} else {
-			logp.Err("Cannot parse the IP address of wavefront address " + jb.config.WavefrontCollector)
+			logp.Err("Cannot parse the IP address of wavefront address %s", jb.config.WavefrontCollector)
 		}
 	}
 
 	defer func() {
-		close(jb.cursorChan)
 		jb.client.Close()
-		jb.journal.Close()
+		for _, root := range jb.roots {
+			close(root.cursorChan)
+			if root.journal != nil {
+				root.journal.Close()
+			}
+		}
+		jb.cursorWriters.Wait()
 	}()
 
 	if jb.config.WriteCursorState {
-		go jb.writeCursorLoop()
+		for _, root := range jb.roots {
+			jb.cursorWriters.Add(1)
+			go jb.writeCursorLoop(root)
+		}
 	}
 
 	go jb.logProcessor()
@@ -367,6 +1004,18 @@ func (jb *Journalbeat) Run(b *beat.Beat) error {
 		os.Exit(101)
 	}
 
+	// the output can have its own field mapping
+	if output := b.Config.Output["logstash"]; output.HasField("field_mapping") {
+		mapping, err := output.String("field_mapping", -1)
+		if err == nil {
+			err = config.ValidateFieldMapping(mapping)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid field_mapping in the logstash output: %v", err)
+		}
+		jb.keys = fieldKeys(mapping, jb.config.CleanFieldNames)
+	}
+
 	for i := 0; i < jb.numLogstashAvailable; i++ {
 		processors, err := processors.New(b.Config.Processors)
 		if err != nil {
@@ -391,47 +1040,30 @@ func (jb *Journalbeat) Run(b *beat.Beat) error {
 		jb.logstashClients = append(jb.logstashClients, publisher.Connect())
 	}
 
-	commonFields := []string{hostNameField, messageField, priorityField}
-
-	for rawEvent := range journal.Follow(jb.journal, jb.done) {
-		event := common.MapStr{}
-		if _, ok := rawEvent.Fields[containerIdField]; ok {
-			selectedFields := append(commonFields, []string{containerTagField, containerIdField}...)
-			event = MapStrFromJournalEntry(
-				rawEvent,
-				jb.config.CleanFieldNames,
-				jb.config.ConvertToNumbers,
-				jb.config.MoveMetadataLocation,
-				selectedFields)
-			event["type"] = "container"
-			event["logBufferingType"] = rawEvent.Fields[containerIdField]
-		} else {
-			selectedFields := append(commonFields, []string{tagField, processField}...)
-			event = MapStrFromJournalEntry(
-				rawEvent,
-				jb.config.CleanFieldNames,
-				jb.config.ConvertToNumbers,
-				jb.config.MoveMetadataLocation,
-				selectedFields)
-			event["type"] = rawEvent.Fields[tagField]
-			event["logBufferingType"] = rawEvent.Fields[processField]
-		}
-
-		event["input_type"] = jb.config.DefaultType
-		event["cursor"] = rawEvent.Cursor
-		if tmStr, ok := rawEvent.Fields[timestampField]; ok {
-			tm, err := strconv.ParseInt(tmStr, 10, 64)
-			if err == nil {
-				event["utcTimestamp"] = tm
-			} else {
-				event["utcTimestamp"] = int64(rawEvent.RealtimeTimestamp)
-			}
-		} else {
-			event["utcTimestamp"] = int64(rawEvent.RealtimeTimestamp)
-		}
+	// follow all journal roots and funnel their events into the log processor
+	var wg sync.WaitGroup
+	for _, root := range jb.roots {
+		wg.Add(1)
+		go func(root *journalRoot) {
+			defer wg.Done()
+			jb.followRoot(root)
+		}(root)
+	}
+	wg.Wait()
 
-		jb.incomingLogMessages <- event
+	close(jb.incomingLogMessages)
+	<-jb.processorDone
+
+	if !jb.until.IsZero() {
+		select {
+		case <-jb.done:
+		default:
+			logp.Info("Read all journal entries up to %s, waiting for the outstanding events to be acknowledged", jb.until.Format(time.RFC3339))
+			jb.pending.Wait()
+			logp.Info("All events were acknowledged, stopping")
+		}
 	}
+
 	return nil
 }
 
@@ -0,0 +1,1052 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/publisher"
+	"github.com/medallia/journalbeat/journal"
+	"github.com/rcrowley/go-metrics"
+)
+
+// testTime is when the first test entry was written, testUntil is after the
+// last one
+var (
+	testTime  = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	testUntil = "2017-01-03T00:00:00Z"
+)
+
+// testClient is a publisher client that keeps the published events and
+// counts the ones whose acknowledgement was asked for
+type testClient struct {
+	mu      sync.Mutex
+	events  []common.MapStr
+	signals int
+}
+
+func (c *testClient) Close() error { return nil }
+
+func (c *testClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	c.mu.Lock()
+	c.events = append(c.events, event)
+	ctx := publisher.MakeContext(opts)
+	if ctx.Signal != nil {
+		c.signals++
+	}
+	c.mu.Unlock()
+	if ctx.Signal != nil {
+		ctx.Signal.Completed()
+	}
+	return true
+}
+
+func (c *testClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	for _, event := range events {
+		c.PublishEvent(event, opts...)
+	}
+	return true
+}
+
+// testEntry is the i-th entry of a fixture, written a second after the one
+// before it
+func testEntry(i int, fields map[string]string) *journal.Entry {
+	return &journal.Entry{
+		Fields:            fields,
+		Cursor:            fmt.Sprintf("s=test;i=%x", i+1),
+		RealtimeTimestamp: uint64(testTime.Add(time.Duration(i)*time.Second).UnixNano() / 1000),
+	}
+}
+
+// testEntries numbers entries of the fields with testEntry
+func testEntries(fields ...map[string]string) []*journal.Entry {
+	entries := make([]*journal.Entry, len(fields))
+	for i, f := range fields {
+		entries[i] = testEntry(i, f)
+	}
+	return entries
+}
+
+// writeFixture writes the entries to a fixture file like `journalctl -o json`
+func writeFixture(t *testing.T, dir string, entries []*journal.Entry) string {
+	path := filepath.Join(dir, "fixture.json")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		raw := map[string]string{
+			"__CURSOR":             e.Cursor,
+			"__REALTIME_TIMESTAMP": strconv.FormatUint(e.RealtimeTimestamp, 10),
+		}
+		for k, v := range e.Fields {
+			raw[k] = v
+		}
+		if err := enc.Encode(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// newTestBeat creates a Journalbeat that reads the entries from a fixture
+// from head to testUntil and publishes to a testClient. The settings go on
+// top of that.
+func newTestBeat(t *testing.T, settings map[string]interface{}, entries []*journal.Entry) (*Journalbeat, *testClient) {
+	dir, err := ioutil.TempDir("", "journalbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	c := map[string]interface{}{
+		"backend":            "fixture",
+		"fixture_file":       writeFixture(t, dir, entries),
+		"seek_position":      "head",
+		"until":              testUntil,
+		"write_cursor_state": false,
+		"cursor_state_file":  filepath.Join(dir, "cursor-state"),
+		"wait_timeout":       "10ms",
+		"clean_field_names":  true,
+	}
+	for k, v := range settings {
+		c[k] = v
+	}
+	cfg, err := common.NewConfigFrom(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jb := b.(*Journalbeat)
+	client := &testClient{}
+	jb.logstashClients = []publisher.Client{client}
+	jb.numLogstashAvailable = 1
+	return jb, client
+}
+
+// runTestBeat follows the journals of jb up to until like Run does and
+// returns the published events
+func runTestBeat(t *testing.T, jb *Journalbeat, client *testClient) []common.MapStr {
+	if jb.config.WriteCursorState {
+		for _, root := range jb.roots {
+			jb.cursorWriters.Add(1)
+			go jb.writeCursorLoop(root)
+		}
+	}
+	go jb.logProcessor()
+
+	var wg sync.WaitGroup
+	for _, root := range jb.roots {
+		wg.Add(1)
+		go func(root *journalRoot) {
+			defer wg.Done()
+			jb.followRoot(root)
+		}(root)
+	}
+	wg.Wait()
+	close(jb.incomingLogMessages)
+	select {
+	case <-jb.processorDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the log processor did not stop")
+	}
+	jb.pending.Wait()
+	for _, root := range jb.roots {
+		close(root.cursorChan)
+	}
+	jb.cursorWriters.Wait()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.events
+}
+
+// runEntries runs a test beat with the settings over the entries
+func runEntries(t *testing.T, settings map[string]interface{}, entries []*journal.Entry) []common.MapStr {
+	jb, client := newTestBeat(t, settings, entries)
+	return runTestBeat(t, jb, client)
+}
+
+// messages returns the messages of the events
+func messages(events []common.MapStr) []string {
+	var msgs []string
+	for _, e := range events {
+		msg, _ := e["message"].(string)
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestConvertEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		fields   map[string]string
+		want     common.MapStr
+	}{
+		{
+			name: "host process",
+			fields: map[string]string{
+				"MESSAGE":           "started",
+				"SYSLOG_IDENTIFIER": "sshd",
+				"_PID":              "42",
+				"_HOST_NAME":        "web1",
+				"PRIORITY":          "6",
+				"_COMM":             "sshd",
+			},
+			want: common.MapStr{
+				"message":           "started",
+				"syslog_identifier": "sshd",
+				"pid":               "42",
+				"host_name":         "web1",
+				"priority":          "6",
+				"type":              "sshd",
+				"logBufferingType":  "42",
+			},
+		},
+		{
+			name: "container",
+			fields: map[string]string{
+				"MESSAGE":       "GET /",
+				"CONTAINER_ID":  "abc",
+				"CONTAINER_TAG": "nginx",
+				"PRIORITY":      "6",
+			},
+			want: common.MapStr{
+				"message":          "GET /",
+				"container_id":     "abc",
+				"container_tag":    "nginx",
+				"priority":         "6",
+				"type":             "container",
+				"logBufferingType": "abc",
+			},
+		},
+		{
+			name:     "numbers and metadata location",
+			settings: map[string]interface{}{"convert_to_numbers": true, "move_metadata_to_field": "journal"},
+			fields: map[string]string{
+				"MESSAGE":           "x",
+				"SYSLOG_IDENTIFIER": "app",
+				"_PID":              "7",
+				"PRIORITY":          "3",
+			},
+			want: common.MapStr{
+				"message": "x",
+				"journal": common.MapStr{
+					"syslog_identifier": "app",
+					"pid":               int64(7),
+					"priority":          int64(3),
+				},
+				"type":             "app",
+				"logBufferingType": "7",
+			},
+		},
+		{
+			name:     "ecs",
+			settings: map[string]interface{}{"field_mapping": "ecs"},
+			fields: map[string]string{
+				"MESSAGE":           "x",
+				"SYSLOG_IDENTIFIER": "app",
+				"_PID":              "7",
+				"PRIORITY":          "3",
+			},
+			want: common.MapStr{
+				"message": "x",
+				"log": common.MapStr{"syslog": common.MapStr{
+					"appname":  "app",
+					"severity": common.MapStr{"code": "3"},
+				}},
+				"process":          common.MapStr{"pid": "7"},
+				"type":             "app",
+				"logBufferingType": "7",
+			},
+		},
+		{
+			name:     "unconverted",
+			settings: map[string]interface{}{"convert_to_numbers": true, "enable_metrics": true},
+			fields: map[string]string{
+				"MESSAGE":           "x",
+				"SYSLOG_IDENTIFIER": "app",
+				"_PID":              "seven",
+				"PRIORITY":          "3",
+			},
+			want: common.MapStr{
+				"message":           "x",
+				"syslog_identifier": "app",
+				"priority":          int64(3),
+				"unconverted":       common.MapStr{"pid": "seven"},
+				"type":              "app",
+				"logBufferingType":  "seven",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := testEntry(0, tt.fields)
+			jb, _ := newTestBeat(t, tt.settings, nil)
+			event := jb.convertEntry(jb.roots[0], entry)
+
+			if event["cursor"] != entry.Cursor {
+				t.Errorf("cursor = %v, want %v", event["cursor"], entry.Cursor)
+			}
+			if event["utcTimestamp"] != int64(entry.RealtimeTimestamp) {
+				t.Errorf("utcTimestamp = %v, want %v", event["utcTimestamp"], entry.RealtimeTimestamp)
+			}
+			if ts, _ := event["@timestamp"].(common.Time); !time.Time(ts).Equal(testTime) {
+				t.Errorf("@timestamp = %v, want %v", event["@timestamp"], testTime)
+			}
+			for _, k := range []string{"cursor", "utcTimestamp", "@timestamp", "input_type", logBufferKey} {
+				delete(event, k)
+			}
+			if !reflect.DeepEqual(event, tt.want) {
+				t.Errorf("event = %v, want %v", event, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimestampSource(t *testing.T) {
+	// logged a minute and a half before journald received it
+	source := testTime.Add(-90*time.Second + 123456*time.Microsecond)
+	sourceMicros := source.UnixNano() / 1000
+	realtimeMicros := testTime.UnixNano() / 1000
+	tests := []struct {
+		name         string
+		source       string
+		fields       map[string]string
+		utcTimestamp int64
+		timestamp    time.Time
+	}{
+		{
+			name:         "source",
+			fields:       map[string]string{"_SOURCE_REALTIME_TIMESTAMP": strconv.FormatInt(sourceMicros, 10)},
+			utcTimestamp: sourceMicros,
+			timestamp:    source,
+		},
+		{
+			name:         "source of an entry without one",
+			utcTimestamp: realtimeMicros,
+			timestamp:    testTime,
+		},
+		{
+			name:         "invalid source",
+			fields:       map[string]string{"_SOURCE_REALTIME_TIMESTAMP": "yesterday"},
+			utcTimestamp: realtimeMicros,
+			timestamp:    testTime,
+		},
+		{
+			name:         "realtime",
+			source:       "realtime",
+			fields:       map[string]string{"_SOURCE_REALTIME_TIMESTAMP": strconv.FormatInt(sourceMicros, 10)},
+			utcTimestamp: realtimeMicros,
+			timestamp:    testTime,
+		},
+		{
+			name:         "publish",
+			source:       "publish",
+			fields:       map[string]string{"_SOURCE_REALTIME_TIMESTAMP": strconv.FormatInt(sourceMicros, 10)},
+			utcTimestamp: sourceMicros,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var settings map[string]interface{}
+			if tt.source != "" {
+				settings = map[string]interface{}{"timestamp_source": tt.source}
+			}
+			fields := map[string]string{"MESSAGE": "x", "SYSLOG_IDENTIFIER": "app", "_PID": "1"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			start := time.Now()
+			events := runEntries(t, settings, testEntries(fields))
+			if len(events) != 1 {
+				t.Fatalf("got %d events", len(events))
+			}
+			event := events[0]
+			if event["utcTimestamp"] != tt.utcTimestamp {
+				t.Errorf("utcTimestamp = %v, want %d", event["utcTimestamp"], tt.utcTimestamp)
+			}
+			ts, ok := event["@timestamp"].(common.Time)
+			if !ok {
+				t.Fatalf("@timestamp = %#v", event["@timestamp"])
+			}
+			if tt.timestamp.IsZero() {
+				// stamped when it was published
+				if time.Time(ts).Before(start.Add(-time.Second)) || time.Time(ts).After(time.Now()) {
+					t.Errorf("@timestamp = %v, want the publish time", ts)
+				}
+			} else if !time.Time(ts).Equal(tt.timestamp) {
+				t.Errorf("@timestamp = %v, want %v", ts, tt.timestamp)
+			}
+		})
+	}
+}
+
+func TestClassification(t *testing.T) {
+	rules := []map[string]interface{}{
+		{
+			"match":         map[string]string{"CONTAINER_NAME": "*", "_COMM": "conmon"},
+			"type":          "podman",
+			"buffering_key": "%{CONTAINER_ID}",
+			"fields":        []string{"CONTAINER_NAME", "CONTAINER_ID"},
+			"tags":          []string{"podman", "%{CONTAINER_NAME}"},
+		},
+		{
+			"match":         map[string]string{"_HOSTNAME": "nspawn-*"},
+			"type":          "machine-%{_HOSTNAME}",
+			"buffering_key": "%{_HOSTNAME}/%{_PID}",
+		},
+		{
+			"match":         map[string]string{"_SYSTEMD_USER_UNIT": "*"},
+			"type":          "%{_SYSTEMD_USER_UNIT}",
+			"buffering_key": "%{_UID}/%{_PID}",
+			"fields":        []string{"_SYSTEMD_USER_UNIT", "_UID"},
+			"tags":          []string{"user-service", "%{UNIT_TAG}"},
+		},
+	}
+	tests := []struct {
+		name         string
+		fields       map[string]string
+		eventType    string
+		bufferingKey string
+		tags         []string
+		present      []string
+		absent       []string
+	}{
+		{
+			name:         "podman",
+			fields:       map[string]string{"MESSAGE": "x", "CONTAINER_NAME": "web", "CONTAINER_ID": "abc", "CONTAINER_TAG": "web", "_COMM": "conmon"},
+			eventType:    "podman",
+			bufferingKey: "abc",
+			tags:         []string{"podman", "web"},
+			present:      []string{"container_name", "container_id"},
+			absent:       []string{"container_tag", "comm"},
+		},
+		{
+			name:         "all match patterns have to match",
+			fields:       map[string]string{"MESSAGE": "x", "CONTAINER_NAME": "web", "CONTAINER_ID": "abc", "CONTAINER_TAG": "web", "_COMM": "dockerd"},
+			eventType:    "container",
+			bufferingKey: "abc",
+			present:      []string{"container_tag", "container_id"},
+			absent:       []string{"container_name"},
+		},
+		{
+			name:         "nspawn machine",
+			fields:       map[string]string{"MESSAGE": "x", "_HOSTNAME": "nspawn-db", "_PID": "7", "SYSLOG_IDENTIFIER": "postgres"},
+			eventType:    "machine-nspawn-db",
+			bufferingKey: "nspawn-db/7",
+			absent:       []string{"syslog_identifier", "pid"},
+		},
+		{
+			name:         "the first matching rule wins",
+			fields:       map[string]string{"MESSAGE": "x", "_HOSTNAME": "nspawn-db", "_SYSTEMD_USER_UNIT": "app.service", "_UID": "1000", "_PID": "9"},
+			eventType:    "machine-nspawn-db",
+			bufferingKey: "nspawn-db/9",
+		},
+		{
+			name:         "user service without a tag field",
+			fields:       map[string]string{"MESSAGE": "x", "_SYSTEMD_USER_UNIT": "app.service", "_UID": "1000", "_PID": "9"},
+			eventType:    "app.service",
+			bufferingKey: "1000/9",
+			tags:         []string{"user-service"},
+			present:      []string{"systemd_user_unit", "uid"},
+		},
+		{
+			name:         "built-in host process rule",
+			fields:       map[string]string{"MESSAGE": "x", "SYSLOG_IDENTIFIER": "sshd", "_PID": "42"},
+			eventType:    "sshd",
+			bufferingKey: "42",
+			present:      []string{"syslog_identifier", "pid"},
+		},
+		{
+			name:   "missing template fields expand to nothing",
+			fields: map[string]string{"MESSAGE": "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jb, _ := newTestBeat(t, map[string]interface{}{"classification_rules": rules}, nil)
+			event := jb.convertEntry(jb.roots[0], testEntry(0, tt.fields))
+			if event["type"] != tt.eventType {
+				t.Errorf("type = %v, want %s", event["type"], tt.eventType)
+			}
+			if event["logBufferingType"] != tt.bufferingKey {
+				t.Errorf("logBufferingType = %v, want %s", event["logBufferingType"], tt.bufferingKey)
+			}
+			tags, _ := event["tags"].([]string)
+			if len(tags) != 0 || len(tt.tags) != 0 {
+				if !reflect.DeepEqual(tags, tt.tags) {
+					t.Errorf("tags = %q, want %q", tags, tt.tags)
+				}
+			}
+			for _, k := range tt.present {
+				if _, ok := event[k]; !ok {
+					t.Errorf("%s is missing", k)
+				}
+			}
+			for _, k := range tt.absent {
+				if _, ok := event[k]; ok {
+					t.Errorf("%s was selected", k)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	fields := map[string]string{
+		"MESSAGE":           "GET /",
+		"SYSLOG_IDENTIFIER": "nginx",
+		"_PID":              "42",
+		"_HOST_NAME":        "web1",
+		"PRIORITY":          "6",
+		"_COMM":             "nginx",
+		"_CAP_EFFECTIVE":    "0",
+		"REQUEST_ID":        "r1",
+		"TENANT":            "acme",
+		"UPSTREAM_ADDR":     "10.0.0.1:8080",
+	}
+	defaults := []string{"host_name", "message", "pid", "priority", "syslog_identifier"}
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     []string
+	}{
+		{
+			name: "defaults",
+			want: defaults,
+		},
+		{
+			name:     "include",
+			settings: map[string]interface{}{"include_fields": []string{"REQUEST_ID", "UPSTREAM_*"}},
+			want:     append(defaults, "request_id", "upstream_addr"),
+		},
+		{
+			name:     "exclude keeps MESSAGE",
+			settings: map[string]interface{}{"exclude_fields": []string{"_PID", "MESSAGE"}},
+			want:     []string{"host_name", "message", "priority", "syslog_identifier"},
+		},
+		{
+			name:     "exclude wins over include",
+			settings: map[string]interface{}{"include_fields": []string{"*_ID", "TENANT"}, "exclude_fields": []string{"REQUEST_*"}},
+			want:     append(defaults, "tenant"),
+		},
+		{
+			name:     "all fields",
+			settings: map[string]interface{}{"all_fields": true},
+			want:     append(defaults, "cap_effective", "comm", "request_id", "tenant", "upstream_addr"),
+		},
+		{
+			name:     "all fields but trusted ones",
+			settings: map[string]interface{}{"all_fields": true, "exclude_fields": []string{"_*"}},
+			want:     []string{"message", "priority", "request_id", "syslog_identifier", "tenant", "upstream_addr"},
+		},
+		{
+			name: "override of the event type",
+			settings: map[string]interface{}{
+				"include_fields": []string{"REQUEST_ID"},
+				"field_overrides": map[string]interface{}{
+					"nginx": map[string]interface{}{"include_fields": []string{"TENANT"}},
+				},
+			},
+			want: append(defaults, "tenant"),
+		},
+		{
+			name: "override of another event type",
+			settings: map[string]interface{}{
+				"include_fields": []string{"REQUEST_ID"},
+				"field_overrides": map[string]interface{}{
+					"container": map[string]interface{}{"all_fields": true},
+				},
+			},
+			want: append(defaults, "request_id"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jb, _ := newTestBeat(t, tt.settings, nil)
+			event := jb.convertEntry(jb.roots[0], testEntry(0, fields))
+			var keys []string
+			for k := range event {
+				switch k {
+				case "cursor", "utcTimestamp", "@timestamp", "input_type", "type", "logBufferingType", logBufferKey:
+				default:
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(keys, want) {
+				t.Errorf("keys = %q, want %q", keys, want)
+			}
+		})
+	}
+}
+
+func TestSinceUntil(t *testing.T) {
+	var fields []map[string]string
+	for i := 0; i < 6; i++ {
+		fields = append(fields, map[string]string{"MESSAGE": strconv.Itoa(i), "SYSLOG_IDENTIFIER": "app", "_PID": strconv.Itoa(i)})
+	}
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     []string
+	}{
+		{
+			name: "since and until",
+			settings: map[string]interface{}{
+				"seek_position": "since",
+				"since":         "2017-01-02T03:04:06Z",
+				"until":         "2017-01-02T03:04:08Z",
+			},
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name: "since as cursor fallback",
+			settings: map[string]interface{}{
+				"seek_position":        "cursor",
+				"cursor_seek_fallback": "since",
+				"since":                "2017-01-02T03:04:09Z",
+			},
+			want: []string{"4", "5"},
+		},
+		{
+			name:     "until only",
+			settings: map[string]interface{}{"until": "2017-01-02T03:04:06Z"},
+			want:     []string{"0", "1"},
+		},
+		{
+			name:     "until before the journal",
+			settings: map[string]interface{}{"until": "2017-01-01T00:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jb, client := newTestBeat(t, tt.settings, testEntries(fields...))
+			// runTestBeat returns once the follower stopped at until
+			events := runTestBeat(t, jb, client)
+			// the buffers of the processes are flushed in no particular order
+			got := messages(events)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			// a bounded run waits for every event to be acknowledged
+			if client.signals != len(events) {
+				t.Errorf("%d of %d events asked for an acknowledgement", client.signals, len(events))
+			}
+		})
+	}
+}
+
+func TestUnboundedRun(t *testing.T) {
+	jb, client := newTestBeat(t, nil, nil)
+	jb.until = time.Time{}
+	event := common.MapStr{"message": "x", logBufferKey: "x"}
+	jb.publish(&LogBuffer{logEvent: event})
+	if len(client.events) != 1 || client.signals != 0 {
+		t.Errorf("published %d events with %d acknowledgements, want 1 without", len(client.events), client.signals)
+	}
+}
+
+func TestMultilineBuffering(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*journal.Entry
+		want    []string
+	}{
+		{
+			name: "continuation lines are joined",
+			entries: testEntries(
+				map[string]string{"MESSAGE": "panic: boom", "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+				map[string]string{"MESSAGE": "\tat main.go:10", "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+				map[string]string{"MESSAGE": "  at main.go:20", "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+				map[string]string{"MESSAGE": "recovered", "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+			),
+			want: []string{"panic: boom\n\tat main.go:10\n  at main.go:20", "recovered"},
+		},
+		{
+			name: "buffering keys are joined separately",
+			entries: testEntries(
+				map[string]string{"MESSAGE": "a1", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+				map[string]string{"MESSAGE": "b1", "SYSLOG_IDENTIFIER": "b", "_PID": "2"},
+				map[string]string{"MESSAGE": " a2", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+				map[string]string{"MESSAGE": " b2", "SYSLOG_IDENTIFIER": "b", "_PID": "2"},
+			),
+			want: []string{"a1\n a2", "b1\n b2"},
+		},
+		{
+			name: "a leading continuation line starts an event",
+			entries: testEntries(
+				map[string]string{"MESSAGE": " orphan", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+				map[string]string{"MESSAGE": "next", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+			),
+			want: []string{" orphan", "next"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := runEntries(t, nil, tt.entries)
+			got := messages(events)
+			// the events still buffered at the end are flushed in map order
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONDecoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []map[string]interface{}
+		fields map[string]string
+		want   map[string]interface{}
+		absent []string
+	}{
+		{
+			name:   "merged",
+			rules:  []map[string]interface{}{{}},
+			fields: map[string]string{"MESSAGE": `{"level":"info","msg":"hi","count":3,"ratio":0.5,"tags":["a"]}`},
+			want:   map[string]interface{}{"level": "info", "msg": "hi", "count": int64(3), "ratio": 0.5},
+			absent: []string{"message", jsonErrorKey},
+		},
+		{
+			name:   "nested under a target",
+			rules:  []map[string]interface{}{{"target": "json"}},
+			fields: map[string]string{"MESSAGE": ` {"level":"info","http":{"status":200}} `},
+			want:   map[string]interface{}{"json.level": "info", "json.http.status": int64(200)},
+			absent: []string{"message", "level"},
+		},
+		{
+			name:   "raw message kept",
+			rules:  []map[string]interface{}{{"keep_message": true}},
+			fields: map[string]string{"MESSAGE": `{"level":"info"}`},
+			want:   map[string]interface{}{"level": "info", "message": `{"level":"info"}`},
+		},
+		{
+			name:   "existing keys are kept",
+			rules:  []map[string]interface{}{{}},
+			fields: map[string]string{"MESSAGE": `{"priority":"0","message":"inner","cursor":"forged"}`},
+			want:   map[string]interface{}{"priority": "6", "message": "inner", "cursor": "s=test;i=1"},
+		},
+		{
+			name:   "existing keys are overwritten",
+			rules:  []map[string]interface{}{{"overwrite_keys": true}},
+			fields: map[string]string{"MESSAGE": `{"priority":"0","cursor":"forged"}`},
+			want:   map[string]interface{}{"priority": "0", "cursor": "s=test;i=1"},
+		},
+		{
+			name:   "invalid JSON",
+			rules:  []map[string]interface{}{{}},
+			fields: map[string]string{"MESSAGE": `{"level":`},
+			want:   map[string]interface{}{"message": `{"level":`, jsonErrorKey: true},
+		},
+		{
+			name:   "trailing data",
+			rules:  []map[string]interface{}{{}},
+			fields: map[string]string{"MESSAGE": `{"level":"info"} and more`},
+			want:   map[string]interface{}{"message": `{"level":"info"} and more`, jsonErrorKey: true},
+			absent: []string{"level"},
+		},
+		{
+			name:   "not an object",
+			rules:  []map[string]interface{}{{}},
+			fields: map[string]string{"MESSAGE": `["level","info"]`},
+			want:   map[string]interface{}{"message": `["level","info"]`},
+			absent: []string{jsonErrorKey},
+		},
+		{
+			name:   "rule of the unit",
+			rules:  []map[string]interface{}{{"units": []string{"api*.service"}}},
+			fields: map[string]string{"MESSAGE": `{"level":"info"}`, "_SYSTEMD_UNIT": "api-v2.service"},
+			want:   map[string]interface{}{"level": "info"},
+		},
+		{
+			name:   "rule of another unit",
+			rules:  []map[string]interface{}{{"units": []string{"api*.service"}}},
+			fields: map[string]string{"MESSAGE": `{"level":"info"}`, "_SYSTEMD_UNIT": "cron.service"},
+			want:   map[string]interface{}{"message": `{"level":"info"}`},
+			absent: []string{"level"},
+		},
+		{
+			name:   "first rule of the type",
+			rules:  []map[string]interface{}{{"types": []string{"other"}, "target": "other"}, {"types": []string{"app"}, "target": "app"}},
+			fields: map[string]string{"MESSAGE": `{"level":"info"}`},
+			want:   map[string]interface{}{"app.level": "info"},
+			absent: []string{"other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"SYSLOG_IDENTIFIER": "app", "_PID": "1", "PRIORITY": "6"}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			jb, _ := newTestBeat(t, map[string]interface{}{"json_decoding": tt.rules}, nil)
+			event := jb.convertEntry(jb.roots[0], testEntry(0, fields))
+			for k, want := range tt.want {
+				if got, err := event.GetValue(k); err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %#v, want %#v", k, got, want)
+				}
+			}
+			for _, k := range tt.absent {
+				if got, err := event.GetValue(k); err == nil {
+					t.Errorf("%s = %#v, want none", k, got)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONBeforeMultiline(t *testing.T) {
+	// the JSON lines start with a space, undecoded they would be joined
+	// with the line before them
+	entries := testEntries(
+		map[string]string{"MESSAGE": "starting", "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+		map[string]string{"MESSAGE": ` {"msg":"one"}`, "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+		map[string]string{"MESSAGE": ` {"msg":"two"}`, "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+		map[string]string{"MESSAGE": "stopping", "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+		map[string]string{"MESSAGE": "  at main.go:10", "SYSLOG_IDENTIFIER": "app", "_PID": "1"},
+	)
+	events := runEntries(t, map[string]interface{}{
+		"json_decoding": []map[string]interface{}{{"keep_message": true}},
+	}, entries)
+	want := []string{"starting", ` {"msg":"one"}`, ` {"msg":"two"}`, "stopping\n  at main.go:10"}
+	if got := messages(events); !reflect.DeepEqual(got, want) {
+		t.Fatalf("messages = %q, want %q", got, want)
+	}
+	if events[1]["msg"] != "one" || events[2]["msg"] != "two" {
+		t.Errorf("decoded %v and %v", events[1]["msg"], events[2]["msg"])
+	}
+}
+
+func TestMultilineBufferingPerMachine(t *testing.T) {
+	// the same process id on two machines of a collector
+	entries := testEntries(
+		map[string]string{"MESSAGE": "a1", "SYSLOG_IDENTIFIER": "app", "_PID": "1", "_MACHINE_ID": "m1"},
+		map[string]string{"MESSAGE": "b1", "SYSLOG_IDENTIFIER": "app", "_PID": "1", "_MACHINE_ID": "m2"},
+		map[string]string{"MESSAGE": " a2", "SYSLOG_IDENTIFIER": "app", "_PID": "1", "_MACHINE_ID": "m1"},
+		map[string]string{"MESSAGE": " b2", "SYSLOG_IDENTIFIER": "app", "_PID": "1", "_MACHINE_ID": "m2"},
+	)
+	events := runEntries(t, nil, entries)
+	got := map[string]string{}
+	for _, e := range events {
+		got[e["message"].(string)] = e["cursor"].(string)
+		if _, ok := e[logBufferKey]; ok {
+			t.Errorf("the buffer key was published: %v", e)
+		}
+	}
+	want := map[string]string{"a1\n a2": entries[0].Cursor, "b1\n b2": entries[1].Cursor}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cursors by message = %q, want %q", got, want)
+	}
+}
+
+func TestCursorStateFileForRoot(t *testing.T) {
+	base := ".journalbeat-cursor-state"
+	tests := []struct {
+		root string
+		want string
+	}{
+		{root: "", want: base},
+		{root: "/var/log/journal/remote", want: base + ".var_log_journal_remote"},
+		{root: "/var/log/journal/remote/", want: base + ".var_log_journal_remote"},
+		{root: "/a/b", want: base + ".a_b"},
+		{root: "/a_b", want: base + ".a%5Fb"},
+		{root: "/a%5Fb", want: base + ".a%255Fb"},
+	}
+	for _, tt := range tests {
+		if got := cursorStateFileForRoot(base, tt.root); got != tt.want {
+			t.Errorf("cursorStateFileForRoot(%q) = %q, want %q", tt.root, got, tt.want)
+		}
+	}
+}
+
+func TestFollowFilters(t *testing.T) {
+	entries := testEntries(
+		map[string]string{"MESSAGE": "one", "SYSLOG_IDENTIFIER": "sshd", "_PID": "1", "_SYSTEMD_UNIT": "sshd.service"},
+		map[string]string{"MESSAGE": "two", "SYSLOG_IDENTIFIER": "cron", "_PID": "2", "_SYSTEMD_UNIT": "cron.service"},
+		map[string]string{"MESSAGE": "three", "SYSLOG_IDENTIFIER": "sshd", "_PID": "1", "_SYSTEMD_UNIT": "sshd.service", "PRIORITY": "3"},
+	)
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     []string
+	}{
+		{name: "all", want: []string{"one", "two", "three"}},
+		{name: "units", settings: map[string]interface{}{"units": []string{"cron.service"}}, want: []string{"two"}},
+		{name: "filter", settings: map[string]interface{}{"filter": "SYSLOG_IDENTIFIER=sshd AND NOT PRIORITY=3"}, want: []string{"one"}},
+		{name: "until", settings: map[string]interface{}{"until": testTime.Add(time.Second).Format(time.RFC3339)}, want: []string{"one", "two"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := messages(runEntries(t, tt.settings, entries))
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorState(t *testing.T) {
+	entries := testEntries(
+		map[string]string{"MESSAGE": "one", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+		map[string]string{"MESSAGE": " continued", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+		map[string]string{"MESSAGE": "two", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+	)
+	jb, client := newTestBeat(t, map[string]interface{}{"write_cursor_state": true}, entries)
+	if events := runTestBeat(t, jb, client); len(events) != 2 {
+		t.Fatalf("published %d events, want 2", len(events))
+	}
+	cursor, err := ioutil.ReadFile(jb.config.CursorStateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cursor) != entries[2].Cursor {
+		t.Errorf("cursor state = %q, want %q", cursor, entries[2].Cursor)
+	}
+
+	// the next run resumes behind the saved cursor
+	entries = append(entries, testEntry(3, map[string]string{"MESSAGE": "three", "SYSLOG_IDENTIFIER": "a", "_PID": "1"}))
+	jb, client = newTestBeat(t, map[string]interface{}{
+		"seek_position":     "cursor",
+		"cursor_state_file": jb.config.CursorStateFile,
+	}, entries)
+	if got := messages(runTestBeat(t, jb, client)); !reflect.DeepEqual(got, []string{"three"}) {
+		t.Errorf("messages after the cursor = %q, want [three]", got)
+	}
+}
+
+// The metrics are only registered if the wavefront collector resolves, what
+// counts them must work without it
+func TestMetricsWithoutCollector(t *testing.T) {
+	entries := testEntries(
+		map[string]string{"MESSAGE": "one", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+		map[string]string{"MESSAGE": "two", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+		map[string]string{"MESSAGE": "three", "SYSLOG_IDENTIFIER": "a", "_PID": "1"},
+		map[string]string{"MESSAGE": "Process 7 (a) dumped core.", "MESSAGE_ID": coredumpMessageID, "SYSLOG_IDENTIFIER": "systemd-coredump", "_PID": "2"},
+	)
+	// two entries went missing before the third one
+	entries[2].Cursor = "s=test;i=5"
+	entries[3].Cursor = "s=test;i=6"
+	jb, client := newTestBeat(t, map[string]interface{}{"enable_metrics": true, "parse_coredumps": true}, entries)
+	jb.readerRestarted(jb.roots[0], journal.Restart{Err: errors.New("read failed"), Attempts: 2})
+	types := map[string]int{}
+	for _, e := range runTestBeat(t, jb, client) {
+		types[e["type"].(string)]++
+		if e["type"] == journalGapType && e["lost_entries"] != uint64(2) {
+			t.Errorf("gap event = %v, want 2 lost entries", e)
+		}
+	}
+	want := map[string]int{readerRestartedType: 1, journalGapType: 1, "a": 3, coredumpEventType: 1}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("event types = %v, want %v", types, want)
+	}
+}
+
+func TestGapDetected(t *testing.T) {
+	jb, client := newTestBeat(t, nil, nil)
+	jb.config.MetricsEnabled = true
+	jb.journalGaps, jb.journalEntriesLost, jb.journalRotationGaps = metrics.NewCounter(), metrics.NewCounter(), metrics.NewCounter()
+
+	jb.gapDetected(jb.roots[0], journal.Gap{Lost: 5, After: "s=a;i=1", Before: "s=a;i=7"})
+	jb.gapDetected(jb.roots[0], journal.Gap{Lost: 1, After: "s=a;i=7", Before: "s=a;i=9", Rotation: true})
+	if len(client.events) != 2 {
+		t.Fatalf("got %d events", len(client.events))
+	}
+	for i, rotation := range []bool{false, true} {
+		if e := client.events[i]; e["type"] != journalGapType || e["rotation"] != rotation {
+			t.Errorf("gap event = %v, want rotation %v", e, rotation)
+		}
+	}
+	if n := jb.journalGaps.Count(); n != 1 {
+		t.Errorf("JournalGaps = %d, want 1", n)
+	}
+	if n := jb.journalEntriesLost.Count(); n != 5 {
+		t.Errorf("JournalEntriesLost = %d, want 5", n)
+	}
+	if n := jb.journalRotationGaps.Count(); n != 1 {
+		t.Errorf("JournalRotationGaps = %d, want 1", n)
+	}
+}
+
+func TestGetPartition(t *testing.T) {
+	const partitions = 1000
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		fields   map[string]string
+		key      string
+	}{
+		{
+			name:   "journal keys",
+			fields: map[string]string{"MESSAGE": "x", "CONTAINER_ID": "abc", "CONTAINER_TAG": "nginx"},
+			key:    "nginx",
+		},
+		{
+			name:     "ecs keys",
+			settings: map[string]interface{}{"field_mapping": "ecs"},
+			fields:   map[string]string{"MESSAGE": "x", "CONTAINER_ID": "abc", "CONTAINER_TAG": "nginx"},
+			key:      "nginx",
+		},
+		{
+			name:     "metadata location",
+			settings: map[string]interface{}{"move_metadata_to_field": "journal"},
+			fields:   map[string]string{"MESSAGE": "x", "CONTAINER_ID": "abc", "CONTAINER_TAG": "nginx"},
+			key:      "nginx",
+		},
+		{
+			name:   "buffering key without a tag",
+			fields: map[string]string{"MESSAGE": "x", "SYSLOG_IDENTIFIER": "app", "_PID": "42"},
+			key:    "42",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jb, _ := newTestBeat(t, tt.settings, nil)
+			event := jb.convertEntry(jb.roots[0], testEntry(0, tt.fields))
+			lb := &LogBuffer{logEvent: event}
+			got := getPartition(lb, partitions, jb.config.PartitionBy, jb.eventKey(containerTagField))
+			if want := hash(tt.key) % partitions; got != want {
+				t.Errorf("partition = %d, want %d, the one of %q", got, want, tt.key)
+			}
+		})
+	}
+}
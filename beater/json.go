@@ -0,0 +1,141 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/medallia/journalbeat/config"
+)
+
+// jsonErrorKey flags the events whose message looked like JSON but could not
+// be decoded
+const jsonErrorKey string = "json_error"
+
+// reservedKeys are the event keys journalbeat relies on, the reserved keys
+// of the config and the markers of the log processor. Decoded objects and
+// extracted fields never replace them.
+var reservedKeys = map[string]struct{}{
+	completeEventKey: {},
+	auditRecordKey:   {},
+	kernelLineKey:    {},
+	logBufferKey:     {},
+}
+
+func init() {
+	for k := range config.ReservedKeys {
+		reservedKeys[k] = struct{}{}
+	}
+}
+
+// jsonDecoder decodes the JSON messages of the entries its rules select
+type jsonDecoder struct {
+	rules []config.JSONDecoding
+}
+
+// rule returns the first rule that applies to an entry
+func (d *jsonDecoder) rule(fields map[string]string, eventType string) *config.JSONDecoding {
+	for i, rule := range d.rules {
+		if (len(rule.Units) == 0 && len(rule.Types) == 0) || matchUnitOrType(rule.Units, rule.Types, fields, eventType) {
+			return &d.rules[i]
+		}
+	}
+	return nil
+}
+
+// decode decodes the message of an entry into the event if it is a JSON
+// object. Messages that look like an object but fail to decode are kept
+// and the event is flagged with json_error.
+func (d *jsonDecoder) decode(event common.MapStr, fields map[string]string, eventType string) {
+	rule := d.rule(fields, eventType)
+	if rule == nil {
+		return
+	}
+	message := strings.TrimSpace(fields[messageField])
+	if !strings.HasPrefix(message, "{") {
+		return
+	}
+
+	obj, err := decodeJSONObject(message)
+	if err != nil {
+		logp.Debug("journalbeat", "Could not decode the JSON message of a %s event: %v", eventType, err)
+		event[jsonErrorKey] = true
+		return
+	}
+
+	if !rule.KeepMessage {
+		delete(event, "message")
+	}
+	if rule.Target != "" {
+		event.Put(rule.Target, obj)
+	} else {
+		for k, v := range obj {
+			// a decoded message replaces the one of the entry as long as
+			// it is a string
+			if _, reserved := reservedKeys[k]; reserved {
+				if _, isString := v.(string); k != "message" || !isString {
+					continue
+				}
+			}
+			if _, exists := event[k]; exists && !rule.OverwriteKeys {
+				continue
+			}
+			event[k] = v
+		}
+	}
+	event[completeEventKey] = true
+}
+
+// decodeJSONObject decodes a message that holds exactly one JSON object.
+// Integers are decoded to int64 so that they keep their precision.
+func decodeJSONObject(message string) (common.MapStr, error) {
+	dec := json.NewDecoder(strings.NewReader(message))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after the JSON object")
+	}
+	return common.MapStr(convertNumbers(obj).(map[string]interface{})), nil
+}
+
+// convertNumbers replaces the json.Numbers of a decoded value, libbeat would
+// publish them as strings
+func convertNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = convertNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = convertNumbers(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+func TestJSONReservedKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    common.MapStr
+	}{
+		{
+			name:    "string message",
+			message: `{"message": "decoded", "type": "other", "cursor": "x", "level": "info"}`,
+			want:    common.MapStr{"message": "decoded", "type": "api", "level": "info"},
+		},
+		{
+			name:    "object message",
+			message: `{"message": {"text": "decoded"}, "logBufferingType": 1, "level": "info"}`,
+			want:    common.MapStr{"type": "api", "level": "info"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &jsonDecoder{rules: []config.JSONDecoding{{Units: []string{"api.service"}}}}
+			event := common.MapStr{"message": tt.message, "type": "api"}
+			d.decode(event, map[string]string{"_SYSTEMD_UNIT": "api.service", "MESSAGE": tt.message}, "api")
+			if _, ok := event[completeEventKey]; !ok {
+				t.Error("the decoded event is not complete")
+			}
+			delete(event, completeEventKey)
+			if !reflect.DeepEqual(event, tt.want) {
+				t.Errorf("event = %v, want %v", event, tt.want)
+			}
+		})
+	}
+}
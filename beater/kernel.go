@@ -0,0 +1,322 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+	"github.com/medallia/journalbeat/grok"
+)
+
+const (
+	kernelSubsystemField string = "_KERNEL_SUBSYSTEM"
+	kernelDeviceField    string = "_KERNEL_DEVICE"
+	udevSysnameField     string = "_UDEV_SYSNAME"
+
+	// kernelLineKey marks the events of kernel messages for the report
+	// assembly in flushOrBufferLogs, it is removed before they are published
+	kernelLineKey string = "kernelLine"
+
+	kernelTransport string = "kernel"
+	kernelEventType string = "kernel"
+)
+
+// kernelClassificationRule types the entries of the kernel transport, which
+// have no _PID to buffer them by
+var kernelClassificationRule = config.ClassificationRule{
+	Match:        map[string]string{transportField: kernelTransport},
+	Type:         kernelEventType,
+	BufferingKey: kernelEventType,
+	Fields:       []string{kernelSubsystemField, kernelDeviceField},
+}
+
+var (
+	// devicePrefix is the prefix of dev_printk, "<driver> <device>: ", e.g.
+	// "usb 1-1: " or "e1000e 0000:00:1f.6 eno1: "
+	devicePrefix = regexp.MustCompile(`^([\w-]+) ([\w.:-]*[0-9][\w.:-]*)(?: [\w.-]+)?: `)
+	// fsPrefix is the prefix of file systems, e.g. "EXT4-fs (sda1): "
+	fsPrefix = regexp.MustCompile(`^([\w-]+) \(([^)]+)\): `)
+	// subsystemPrefix is the prefix of pr_fmt, e.g. "IPv6: "
+	subsystemPrefix = regexp.MustCompile(`^([\w.-]+): `)
+)
+
+// reportPrefixes start lines of kernel reports, they are not subsystems
+var reportPrefixes = map[string]struct{}{
+	"BUG": {}, "CPU": {}, "Code": {}, "Hardware": {}, "INFO": {}, "Oops": {},
+	"RIP": {}, "RSP": {}, "WARNING": {}, "Workqueue": {}, "task": {}, "watchdog": {},
+}
+
+// parseKernelLine adds the subsystem and device of a kernel message to the
+// event, from the fields journald attaches or else the message prefix
+func parseKernelLine(event common.MapStr, fields map[string]string) {
+	subsystem, device := fields[kernelSubsystemField], fields[udevSysnameField]
+	if device == "" {
+		device = fields[kernelDeviceField]
+	}
+	if subsystem == "" {
+		message := fields[messageField]
+		if m := devicePrefix.FindStringSubmatch(message); m != nil {
+			subsystem, device = m[1], m[2]
+		} else if m := fsPrefix.FindStringSubmatch(message); m != nil {
+			subsystem, device = m[1], m[2]
+		} else if m := subsystemPrefix.FindStringSubmatch(message); m != nil {
+			if _, ok := reportPrefixes[m[1]]; !ok {
+				subsystem = m[1]
+			}
+		}
+	}
+	if subsystem != "" {
+		event.Put(kernelEventType+".subsystem", subsystem)
+	}
+	if device != "" {
+		event.Put(kernelEventType+".device", device)
+	}
+}
+
+// kernelReportStart starts a report of the kernel that spans several lines,
+// unless a report of the kind it continues is pending
+type kernelReportStart struct {
+	kind      string
+	pattern   *grok.Pattern
+	continues string
+}
+
+var kernelLibrary = grok.NewLibrary(map[string]string{
+	"KFRAME": `[\w.]+\+0x[0-9a-f]+/0x[0-9a-f]+(?: \[[\w]+\])?`,
+})
+
+// kernelReportStarts are checked in order, the first one that matches the
+// line starts a report
+var kernelReportStarts = []kernelReportStart{
+	{kind: "oom", pattern: mustCompile(`^%{NOTSPACE:kernel.oom.invoker} invoked oom-killer: gfp_mask=%{BASE16NUM:kernel.oom.gfp_mask}.*? order=%{INT:kernel.oom.order:int}(?:, oom_score_adj=%{INT:kernel.oom.score_adj:int})?`)},
+	{kind: "hung_task", pattern: mustCompile(`^INFO: task %{DATA:kernel.report.comm}:%{INT:kernel.report.pid:int} blocked for more than %{INT:kernel.hung_task.blocked_seconds:int} seconds`)},
+	{kind: "soft_lockup", pattern: mustCompile(`^(?:NMI )?watchdog: BUG: soft lockup - CPU#%{INT:kernel.report.cpu:int} stuck for %{INT:kernel.soft_lockup.stuck_seconds:int}s! \[%{DATA:kernel.report.comm}:%{INT:kernel.report.pid:int}\]`)},
+	{kind: "rcu_stall", pattern: mustCompile(`^INFO: rcu_\w+ (?:self-)?detected stall`)},
+	{kind: "panic", pattern: mustCompile(`^Kernel panic - not syncing: %{GREEDYDATA:kernel.panic.reason}`)},
+	{kind: "warning", pattern: mustCompile(`^WARNING: CPU: %{INT:kernel.report.cpu:int} PID: %{INT:kernel.report.pid:int} at %{NOTSPACE:kernel.report.location}`)},
+	{kind: "bug", pattern: mustCompile(`^kernel BUG at %{NOTSPACE:kernel.report.location}`)},
+	{kind: "bug", pattern: mustCompile(`^BUG: %{GREEDYDATA:kernel.bug.reason}`)},
+	// "BUG: unable to handle page fault" and "kernel BUG at" are followed by
+	// the oops line of the same report
+	{kind: "oops", pattern: mustCompile(`^(?:Oops|general protection fault|Unable to handle kernel|invalid opcode)`), continues: "bug"},
+}
+
+// kernelReportFields are extracted from every line of a report, the first
+// line with a value wins
+var kernelReportFields = []*grok.Pattern{
+	mustCompile(`^CPU: %{INT:kernel.report.cpu:int} (?:UID: \d+ )?PID: %{INT:kernel.report.pid:int} Comm: %{NOTSPACE:kernel.report.comm}`),
+	mustCompile(`^\s*RIP: (?:[0-9a-f]{4}:)?(?:\[<[0-9a-f]+>\]\s+)?%{KFRAME:kernel.report.rip}`),
+	mustCompile(`Killed process %{INT:kernel.oom.killed.pid:int} \(%{DATA:kernel.oom.killed.process}\)(?:,? total-vm:%{INT:kernel.oom.killed.total_vm_kb:int}kB, anon-rss:%{INT:kernel.oom.killed.anon_rss_kb:int}kB, file-rss:%{INT:kernel.oom.killed.file_rss_kb:int}kB(?:, shmem-rss:%{INT:kernel.oom.killed.shmem_rss_kb:int}kB)?)?(?:, UID:%{INT:kernel.oom.killed.uid:int})?`),
+	mustCompile(`^memory: usage %{INT:kernel.oom.memcg.usage_kb:int}kB, limit %{INT:kernel.oom.memcg.limit_kb:int}kB, failcnt %{INT:kernel.oom.memcg.failcnt:int}`),
+}
+
+var (
+	// callTraceFrame is a reliable frame of a call trace, frames that are
+	// only guessed start with "? "
+	callTraceFrame = mustCompile(`^\s*(?:\[<[0-9a-f]+>\] )?%{KFRAME:frame}\s*$`)
+	modulesLinked  = regexp.MustCompile(`^Modules linked in: (.*)`)
+	// reportEnd ends oopses, BUG reports, warnings and panics
+	reportEnd = regexp.MustCompile(`^-+\[ end (?:trace|Kernel panic)`)
+	// oomEnd ends the reports of the OOM killer
+	oomEnd = regexp.MustCompile(`Killed process [0-9]+`)
+	// reportLine matches the lines that continue a report: indented lines,
+	// the frames of call traces, register dumps and the sections of oopses
+	// and of hung task, RCU stall and OOM killer reports. Other kernel lines
+	// are left out of pending reports.
+	reportLine = regexp.MustCompile(`^(?:\s|\? |<|\[|-+\[|` +
+		`(?:Call [Tt]race|CPU|Code|Hardware name|Modules linked in|Tainted|Workqueue|Stack|Kernel Offset|` +
+		`R[A-Z0-9]{1,2}|E[A-Z]{2}|[CDEFGS]S|CR[0-9]|DR[0-9]|PKRU|pc|lr|sp|x[0-9]+)\s*:|` +
+		`Mem-Info:|active_anon:|Node [0-9]|lowmem_reserve|[0-9]+ (?:total pagecache|pages)|Free swap|Total swap|Swap cache|` +
+		`Tasks state|oom-kill:|Out of memory|Killed process|Memory cgroup|memory(?:\+swap)?:|kmem:|[a-z_]+ [0-9]+$|` +
+		`task:|"echo 0|Sending NMI|NMI backtrace|rcu:|\(detected by)`)
+)
+
+func mustCompile(pattern string) *grok.Pattern {
+	p, err := kernelLibrary.Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// kernelAssembler assembles the lines of kernel reports, e.g. oopses, BUG
+// reports, hung task traces and OOM killer reports, into single events. The
+// lines of different roots and machines go to reports of their own. It is
+// only used by the log processor.
+type kernelAssembler struct {
+	timeout  time.Duration
+	maxLines int
+	maxAge   time.Duration
+	reports  map[string]*kernelReport
+}
+
+// kernelReport is a report whose lines are being collected
+type kernelReport struct {
+	kind    string
+	started time.Time
+	time    time.Time
+	event   common.MapStr
+	lines   []string
+}
+
+func newKernelAssembler(timeout time.Duration, maxLines int, maxAge time.Duration) *kernelAssembler {
+	return &kernelAssembler{
+		timeout:  timeout,
+		maxLines: maxLines,
+		maxAge:   maxAge,
+		reports:  make(map[string]*kernelReport),
+	}
+}
+
+// add adds a kernel line to the report it starts or continues. It returns
+// the reports the line completes and whether it was taken, lines outside
+// of reports are left to the multiline buffering. Reports are completed at
+// their end line, with maxLines lines or once they are older than maxAge.
+func (a *kernelAssembler) add(event common.MapStr) (complete []common.MapStr, taken bool) {
+	message := event["message"].(string)
+	source, _ := event[logBufferKey].(string)
+	now := time.Now()
+	report := a.reports[source]
+	if report != nil && now.Sub(report.started) >= a.maxAge {
+		complete = append(complete, report.complete())
+		delete(a.reports, source)
+		report = nil
+	}
+
+	kind := ""
+	for _, start := range kernelReportStarts {
+		if _, _, ok := start.pattern.Match(message); ok {
+			if report == nil || report.kind != start.continues {
+				kind = start.kind
+			}
+			break
+		}
+	}
+
+	switch {
+	case kind != "":
+		if report != nil {
+			complete = append(complete, report.complete())
+		}
+		report = &kernelReport{kind: kind, started: now, event: event}
+		a.reports[source] = report
+	case report != nil && reportLine.MatchString(message):
+		// the cursor of the last line is the one to save
+		report.event["cursor"] = event["cursor"]
+	default:
+		return complete, false
+	}
+	report.time = now
+	report.lines = append(report.lines, message)
+
+	if reportEnd.MatchString(message) || (report.kind == "oom" && oomEnd.MatchString(message)) || len(report.lines) >= a.maxLines {
+		complete = append(complete, report.complete())
+		delete(a.reports, source)
+	}
+	return complete, true
+}
+
+// expire returns the pending reports whose last line is older than the
+// timeout, hung task and RCU stall reports have no end line, or that are
+// older than the maximum age, and forgets them
+func (a *kernelAssembler) expire(now time.Time) []common.MapStr {
+	var sources []string
+	for source, report := range a.reports {
+		if now.Sub(report.time) >= a.timeout || now.Sub(report.started) >= a.maxAge {
+			sources = append(sources, source)
+		}
+	}
+	return a.remove(sources)
+}
+
+// flush returns the pending reports and forgets them
+func (a *kernelAssembler) flush() []common.MapStr {
+	sources := make([]string, 0, len(a.reports))
+	for source := range a.reports {
+		sources = append(sources, source)
+	}
+	return a.remove(sources)
+}
+
+// remove completes the reports of the sources in the order they started
+func (a *kernelAssembler) remove(sources []string) []common.MapStr {
+	sort.Slice(sources, func(i, j int) bool {
+		ri, rj := a.reports[sources[i]], a.reports[sources[j]]
+		if !ri.started.Equal(rj.started) {
+			return ri.started.Before(rj.started)
+		}
+		return sources[i] < sources[j]
+	})
+	reports := make([]common.MapStr, 0, len(sources))
+	for _, source := range sources {
+		reports = append(reports, a.reports[source].complete())
+		delete(a.reports, source)
+	}
+	return reports
+}
+
+// complete returns the event of the first line with the lines of the report
+// and the fields extracted from them
+func (r *kernelReport) complete() common.MapStr {
+	event := r.event
+	event["message"] = strings.Join(r.lines, "\n")
+	event.Put(kernelEventType+".report.type", r.kind)
+	event.Put(kernelEventType+".report.title", r.lines[0])
+
+	values := map[string]interface{}{}
+	var frames, modules []string
+	for i, line := range r.lines {
+		patterns := kernelReportFields
+		if i == 0 {
+			for _, start := range kernelReportStarts {
+				if start.kind == r.kind {
+					patterns = append([]*grok.Pattern{start.pattern}, patterns...)
+				}
+			}
+		}
+		for _, p := range patterns {
+			captures, _, ok := p.Match(line)
+			if !ok {
+				continue
+			}
+			for field, v := range captures {
+				if _, ok := values[field]; !ok {
+					values[field] = v
+				}
+			}
+		}
+		if captures, _, ok := callTraceFrame.Match(line); ok {
+			frames = append(frames, captures["frame"].(string))
+		}
+		if m := modulesLinked.FindStringSubmatch(line); m != nil && modules == nil {
+			modules = strings.Fields(m[1])
+		}
+	}
+	for field, v := range values {
+		event.Put(field, v)
+	}
+	if frames != nil {
+		event.Put(kernelEventType+".report.call_trace", frames)
+	}
+	if modules != nil {
+		event.Put(kernelEventType+".report.modules", modules)
+	}
+	return event
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// warningReport is a warning as the kernel logs it, line by line
+var warningReport = []string{
+	"WARNING: CPU: 2 PID: 1234 at net/core/dev.c:4567 dev_watchdog+0x1f0/0x200",
+	"Modules linked in: e1000e nf_tables",
+	"CPU: 2 PID: 1234 Comm: kworker/2:1 Tainted: G        W         5.15.0 #1",
+	"Hardware name: QEMU Standard PC (i440FX + PIIX, 1996)",
+	"Workqueue: events_power_efficient dev_watchdog",
+	"RIP: 0010:dev_watchdog+0x1f0/0x200",
+	"Code: 00 00 48 89 df c6 05 5e 1d 0b 01 01 e8 46 48 fa ff",
+	"RSP: 0018:ffffb3a0c0103e58 EFLAGS: 00010286",
+	"RAX: 0000000000000000 RBX: ffff9d0a81a1c000 RCX: 0000000000000027",
+	"R10: 0000000000000001 R11: 0000000000000001 R12: ffff9d0a81a1c4c8",
+	"FS:  0000000000000000(0000) GS:ffff9d0bfbd00000(0000) knlGS:0000000000000000",
+	"CS:  0010 DS: 0000 ES: 0000 CR0: 0000000080050033",
+	"CR2: 00007f1c2c0a1000 CR3: 000000010a80a000 CR4: 00000000000006e0",
+	"Call Trace:",
+	" <TASK>",
+	" ? pfifo_fast_reset+0x150/0x150",
+	" call_timer_fn+0x29/0x120",
+	" run_timer_softirq+0x1c4/0x3e0",
+	" </TASK>",
+	"---[ end trace 1b75b31a2ce5fe2e ]---",
+}
+
+// oomReport is a report of the OOM killer
+var oomReport = []string{
+	"stress invoked oom-killer: gfp_mask=0x100cca(GFP_HIGHUSER_MOVABLE), order=0, oom_score_adj=0",
+	"CPU: 0 PID: 4242 Comm: stress Not tainted 5.15.0 #1",
+	"Call Trace:",
+	" dump_stack_lvl+0x34/0x44",
+	" out_of_memory+0x106/0x4e0",
+	"Mem-Info:",
+	"active_anon:2 inactive_anon:240113 isolated_anon:0",
+	"Node 0 DMA free:14848kB min:256kB low:320kB high:384kB",
+	"lowmem_reserve[]: 0 2976 3911 3911",
+	"13424 total pagecache pages",
+	"1048432 pages RAM",
+	"Tasks state (memory values in pages):",
+	"[  pid  ]   uid  tgid total_vm      rss pgtables_bytes swapents oom_score_adj name",
+	"[   4242]     0  4242   262959   240000  1978368        0             0 stress",
+	"oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/,task=stress,pid=4242,uid=0",
+	"Out of memory: Killed process 4242 (stress) total-vm:1051836kB, anon-rss:960000kB, file-rss:4kB, shmem-rss:0kB, UID:0",
+}
+
+// hungTaskReport is a hung task report, which has no end line
+var hungTaskReport = []string{
+	"INFO: task jbd2/sda1-8:245 blocked for more than 120 seconds.",
+	"      Not tainted 5.15.0 #1",
+	`"echo 0 > /proc/sys/kernel/hung_task_timeout_secs" disables this message.`,
+	"task:jbd2/sda1-8     state:D stack:    0 pid:  245 ppid:     2 flags:0x00004000",
+	"Call Trace:",
+	" __schedule+0x2f0/0x950",
+	" schedule+0x4e/0xb0",
+}
+
+// kernelTestEvent is the event of a kernel line of a source as the log
+// processor gets it
+func kernelTestEvent(source string, i int, message string) common.MapStr {
+	return common.MapStr{
+		"message":    message,
+		"cursor":     source + ";" + strings.Repeat("i", i+1),
+		logBufferKey: source,
+	}
+}
+
+// addLines adds the lines of a source to the assembler and returns the
+// completed reports and the lines that were not taken
+func addLines(a *kernelAssembler, source string, lines []string) (reports []common.MapStr, left []string) {
+	for i, line := range lines {
+		complete, taken := a.add(kernelTestEvent(source, i, line))
+		reports = append(reports, complete...)
+		if !taken {
+			left = append(left, line)
+		}
+	}
+	return reports, left
+}
+
+func newTestKernelAssembler() *kernelAssembler {
+	return newKernelAssembler(time.Minute, 500, time.Hour)
+}
+
+func TestKernelReports(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		kind   string
+		fields map[string]interface{}
+	}{
+		{
+			name:  "warning",
+			lines: warningReport,
+			kind:  "warning",
+			fields: map[string]interface{}{
+				"kernel.report.cpu":        int64(2),
+				"kernel.report.pid":        int64(1234),
+				"kernel.report.comm":       "kworker/2:1",
+				"kernel.report.location":   "net/core/dev.c:4567",
+				"kernel.report.rip":        "dev_watchdog+0x1f0/0x200",
+				"kernel.report.modules":    []string{"e1000e", "nf_tables"},
+				"kernel.report.call_trace": []string{"call_timer_fn+0x29/0x120", "run_timer_softirq+0x1c4/0x3e0"},
+			},
+		},
+		{
+			name:  "oom",
+			lines: oomReport,
+			kind:  "oom",
+			fields: map[string]interface{}{
+				"kernel.oom.invoker":            "stress",
+				"kernel.oom.killed.pid":         int64(4242),
+				"kernel.oom.killed.process":     "stress",
+				"kernel.oom.killed.anon_rss_kb": int64(960000),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestKernelAssembler()
+			reports, left := addLines(a, "a", tt.lines)
+			if len(left) != 0 {
+				t.Errorf("lines left out of the report: %q", left)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("completed %d reports, want 1", len(reports))
+			}
+			report := reports[0]
+			if report["message"] != strings.Join(tt.lines, "\n") {
+				t.Errorf("message = %q", report["message"])
+			}
+			if want := kernelTestEvent("a", len(tt.lines)-1, "")["cursor"]; report["cursor"] != want {
+				t.Errorf("cursor = %v, want the one of the last line %v", report["cursor"], want)
+			}
+			if kind, _ := report.GetValue("kernel.report.type"); kind != tt.kind {
+				t.Errorf("report type = %v, want %s", kind, tt.kind)
+			}
+			for field, want := range tt.fields {
+				if got, _ := report.GetValue(field); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %#v, want %#v", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestKernelReportLeavesOtherLines(t *testing.T) {
+	a := newTestKernelAssembler()
+	// lines of drivers that log while the report is printed are not part of
+	// it, the hung task report ends at the timeout
+	lines := append(append([]string{}, hungTaskReport[:3]...), "usb 1-1: new high-speed USB device number 3 using xhci_hcd")
+	lines = append(lines, hungTaskReport[3:]...)
+	lines = append(lines, "IPv6: ADDRCONF(NETDEV_CHANGE): eth0: link becomes ready")
+	reports, left := addLines(a, "a", lines)
+	if len(reports) != 0 {
+		t.Fatalf("completed %v before the timeout", reports)
+	}
+	if want := []string{lines[3], lines[len(lines)-1]}; !reflect.DeepEqual(left, want) {
+		t.Errorf("lines left out = %q, want %q", left, want)
+	}
+	reports = a.expire(time.Now().Add(time.Minute))
+	if len(reports) != 1 || reports[0]["message"] != strings.Join(hungTaskReport, "\n") {
+		t.Fatalf("expired reports = %v, want the hung task report", reports)
+	}
+	if len(a.reports) != 0 {
+		t.Errorf("reports still pending: %v", a.reports)
+	}
+}
+
+func TestKernelReportsPerSource(t *testing.T) {
+	a := newTestKernelAssembler()
+	// the lines of two machines interleave
+	var reports []common.MapStr
+	for i := range warningReport {
+		for _, source := range []string{"a", "b"} {
+			complete, taken := a.add(kernelTestEvent(source, i, warningReport[i]))
+			if !taken {
+				t.Errorf("line %q of %s was not taken", warningReport[i], source)
+			}
+			reports = append(reports, complete...)
+		}
+	}
+	if len(reports) != 2 {
+		t.Fatalf("completed %d reports, want 2", len(reports))
+	}
+	for i, source := range []string{"a", "b"} {
+		if reports[i][logBufferKey] != source || reports[i]["message"] != strings.Join(warningReport, "\n") {
+			t.Errorf("report of %s = %v", source, reports[i])
+		}
+	}
+}
+
+func TestKernelReportLimits(t *testing.T) {
+	// the report is cut at the line cap, the lines after it are left out
+	a := newKernelAssembler(time.Minute, 5, time.Hour)
+	reports, left := addLines(a, "a", warningReport)
+	if len(reports) != 1 || reports[0]["message"] != strings.Join(warningReport[:5], "\n") {
+		t.Errorf("reports at the line cap = %v, want the first 5 lines", reports)
+	}
+	if !reflect.DeepEqual(left, warningReport[5:]) {
+		t.Errorf("lines left out = %q, want %q", left, warningReport[5:])
+	}
+
+	// a report that keeps getting lines is completed at the maximum age
+	a = newKernelAssembler(time.Minute, 500, time.Hour)
+	addLines(a, "a", hungTaskReport)
+	a.reports["a"].started = time.Now().Add(-2 * time.Hour)
+	complete, taken := a.add(kernelTestEvent("a", 10, " io_schedule+0x12/0x40"))
+	if taken || len(complete) != 1 || complete[0]["message"] != strings.Join(hungTaskReport, "\n") {
+		t.Errorf("add to an old report = %v, %t, want the report and the line left out", complete, taken)
+	}
+	addLines(a, "a", hungTaskReport)
+	if reports := a.expire(time.Now().Add(2 * time.Hour)); len(reports) != 1 {
+		t.Errorf("expired %d reports past the maximum age, want 1", len(reports))
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/medallia/journalbeat/config"
+)
+
+const (
+	containerNameField string = "CONTAINER_NAME"
+	cgroupField        string = "_SYSTEMD_CGROUP"
+
+	// kubeletTimeout bounds a request of the pod list
+	kubeletTimeout = 5 * time.Second
+	// kubeletRetry is how long an unknown pod or a failed request waits
+	// before the pod list is requested again
+	kubeletRetry = 10 * time.Second
+)
+
+var (
+	// kubernetesContainerName is the name docker containers of the kubelet
+	// get, k8s_<container>_<pod>_<namespace>_<pod uid>_<restart count>
+	kubernetesContainerName = regexp.MustCompile(`^/?k8s_([^_]+)_([^_]+)_([^_]+)_([^_]+)_[0-9]+$`)
+	// kubernetesPodCgroup finds the pod UID in the cgroups of the cgroupfs
+	// driver, ".../pod<uid>/...", and of the systemd driver,
+	// "...-pod<uid with underscores>.slice/..."
+	kubernetesPodCgroup = regexp.MustCompile(`kubepods.*?[/-]pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// kubernetesPod is the metadata of a pod in the pod list of the kubelet
+type kubernetesPod struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		UID         string            `json:"uid"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// kubernetesEnricher adds the pod and container of the kubelet to the
+// events of containers. They come from the names the kubelet gives docker
+// containers and the cgroups of the pods, and with a pod list URL the labels
+// and annotations of the pods from the kubelet.
+type kubernetesEnricher struct {
+	podsURL   string
+	tokenFile string
+	refresh   time.Duration
+	client    *http.Client
+
+	mu   sync.Mutex
+	pods map[string]*kubernetesPod
+	// fetched is when the pod list was requested last
+	fetched time.Time
+	failing bool
+}
+
+// newKubernetesEnricher returns an enricher that verifies the certificate
+// of the kubelet with the CA of the config, or the system CAs
+func newKubernetesEnricher(c config.Config) (*kubernetesEnricher, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.KubeletInsecure}
+	if c.KubeletCAFile != "" {
+		pem, err := ioutil.ReadFile(c.KubeletCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read kubelet_ca_file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates in kubelet_ca_file %s", c.KubeletCAFile)
+		}
+	}
+	return &kubernetesEnricher{
+		podsURL:   c.KubeletPodsURL,
+		tokenFile: c.KubeletTokenFile,
+		refresh:   c.KubeletRefresh,
+		client: &http.Client{
+			Timeout:   kubeletTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		pods: map[string]*kubernetesPod{},
+	}, nil
+}
+
+// enrich adds the kubernetes metadata of the container of an entry under
+// kubernetes
+func (k *kubernetesEnricher) enrich(event common.MapStr, fields map[string]string) {
+	var namespace, pod, uid, container string
+	name := fields[containerNameField]
+	if name == "" {
+		// the name looked up from the container runtime, if any
+		name, _ = valueOf(event, "container.name").(string)
+	}
+	if m := kubernetesContainerName.FindStringSubmatch(name); m != nil {
+		container, pod, namespace, uid = m[1], m[2], m[3], m[4]
+	}
+	if m := kubernetesPodCgroup.FindStringSubmatch(fields[cgroupField]); m != nil && uid == "" {
+		uid = strings.Replace(m[1], "_", "-", -1)
+	}
+	if uid == "" {
+		return
+	}
+
+	event.Put("kubernetes.pod.uid", uid)
+	if container != "" {
+		event.Put("kubernetes.container.name", container)
+	}
+	if k.podsURL == "" {
+		if pod != "" {
+			event.Put("kubernetes.pod.name", pod)
+			event.Put("kubernetes.namespace", namespace)
+		}
+		return
+	}
+
+	if meta := k.lookup(uid, time.Now()); meta != nil {
+		pod, namespace = meta.Metadata.Name, meta.Metadata.Namespace
+		if len(meta.Metadata.Labels) > 0 {
+			event.Put("kubernetes.labels", labelMap(meta.Metadata.Labels))
+		}
+		if len(meta.Metadata.Annotations) > 0 {
+			event.Put("kubernetes.annotations", labelMap(meta.Metadata.Annotations))
+		}
+	}
+	if pod != "" {
+		event.Put("kubernetes.pod.name", pod)
+		event.Put("kubernetes.namespace", namespace)
+	}
+}
+
+// lookup returns the pod with the UID, the pod list is requested again if it
+// is older than the refresh interval or the pod is missing from it. The
+// request is made without the lock, other lookups meanwhile use the last
+// pod list.
+func (k *kubernetesEnricher) lookup(uid string, now time.Time) *kubernetesPod {
+	k.mu.Lock()
+	pod, ok := k.pods[uid]
+	age := now.Sub(k.fetched)
+	if (ok && age < k.refresh) || (!ok && age < kubeletRetry) {
+		k.mu.Unlock()
+		return pod
+	}
+	k.fetched = now
+	k.mu.Unlock()
+
+	pods, err := k.fetchPods()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err != nil {
+		if !k.failing {
+			logp.Warn("Requesting the pods from the kubelet failed, using the last pod list: %v", err)
+			k.failing = true
+		}
+		return pod
+	}
+	if k.failing {
+		logp.Info("The kubelet is reachable again")
+		k.failing = false
+	}
+	k.pods = pods
+	return pods[uid]
+}
+
+// fetchPods requests the pod list of the kubelet
+func (k *kubernetesEnricher) fetchPods() (map[string]*kubernetesPod, error) {
+	req, err := http.NewRequest("GET", k.podsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.tokenFile != "" {
+		token, err := ioutil.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", k.podsURL, resp.Status)
+	}
+
+	var list struct {
+		Items []*kubernetesPod `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	pods := make(map[string]*kubernetesPod, len(list.Items))
+	for _, pod := range list.Items {
+		pods[pod.Metadata.UID] = pod
+	}
+	return pods, nil
+}
+
+// valueOf returns the value of a dotted key of the event, or nil
+func valueOf(event common.MapStr, key string) interface{} {
+	v, err := event.GetValue(key)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// labelMap copies labels or annotations for an event, the cached maps are
+// shared by all events. The dots of their keys, e.g. app.kubernetes.io/name,
+// become underscores so that Elasticsearch does not turn them into objects
+// that conflict with the labels they prefix.
+func labelMap(m map[string]string) common.MapStr {
+	copied := make(common.MapStr, len(m))
+	for k, v := range m {
+		copied[strings.Replace(k, ".", "_", -1)] = v
+	}
+	return copied
+}
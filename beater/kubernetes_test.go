@@ -0,0 +1,160 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+const testPodUID = "0c9a6a4e-1b2c-4d5e-8f90-a1b2c3d4e5f6"
+
+// testPodList is the pod list of a kubelet with one pod
+const testPodList = `{"items": [{"metadata": {
+	"name": "web-0", "namespace": "shop", "uid": "` + testPodUID + `",
+	"labels": {"app.kubernetes.io/name": "web", "tier": "front"}}}]}`
+
+// newTestKubelet serves the pod list over TLS, every request waits for a
+// value of release if it is not nil. It returns the kubelet and a CA file
+// with its certificate.
+func newTestKubelet(t *testing.T, release chan struct{}) (*httptest.Server, string) {
+	kubelet := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if release != nil {
+			<-release
+		}
+		fmt.Fprint(w, testPodList)
+	}))
+	t.Cleanup(kubelet.Close)
+
+	dir, err := ioutil.TempDir("", "journalbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	caFile := filepath.Join(dir, "ca.crt")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: kubelet.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return kubelet, caFile
+}
+
+func newTestKubernetesEnricher(t *testing.T, c config.Config) *kubernetesEnricher {
+	c.KubeletRefresh = time.Minute
+	k, err := newKubernetesEnricher(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKubeletTLS(t *testing.T) {
+	kubelet, caFile := newTestKubelet(t, nil)
+	tests := []struct {
+		name   string
+		config config.Config
+		found  bool
+	}{
+		{name: "ca file", config: config.Config{KubeletCAFile: caFile}, found: true},
+		{name: "insecure", config: config.Config{KubeletInsecure: true}, found: true},
+		{name: "system cas", config: config.Config{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.KubeletPodsURL = kubelet.URL + "/pods"
+			k := newTestKubernetesEnricher(t, tt.config)
+			event := common.MapStr{}
+			k.enrich(event, map[string]string{cgroupField: "/kubepods/besteffort/pod" + testPodUID + "/abc"})
+			want := common.MapStr{"kubernetes": common.MapStr{"pod": common.MapStr{"uid": testPodUID}}}
+			if tt.found {
+				want = common.MapStr{"kubernetes": common.MapStr{
+					"pod":       common.MapStr{"uid": testPodUID, "name": "web-0"},
+					"namespace": "shop",
+					"labels":    common.MapStr{"app_kubernetes_io/name": "web", "tier": "front"},
+				}}
+			}
+			if !reflect.DeepEqual(event, want) {
+				t.Errorf("event = %v, want %v", event, want)
+			}
+		})
+	}
+
+	if _, err := newKubernetesEnricher(config.Config{KubeletCAFile: filepath.Join(filepath.Dir(caFile), "missing")}); err == nil {
+		t.Error("a missing kubelet_ca_file was accepted")
+	}
+	if err := ioutil.WriteFile(caFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newKubernetesEnricher(config.Config{KubeletCAFile: caFile}); err == nil {
+		t.Error("a kubelet_ca_file without certificates was accepted")
+	}
+}
+
+func TestKubeletFetchWithoutLock(t *testing.T) {
+	release := make(chan struct{}, 1)
+	kubelet, caFile := newTestKubelet(t, release)
+	k := newTestKubernetesEnricher(t, config.Config{KubeletPodsURL: kubelet.URL + "/pods", KubeletCAFile: caFile})
+	now := time.Now()
+
+	release <- struct{}{}
+	if pod := k.lookup(testPodUID, now); pod == nil {
+		t.Fatal("the pod was not found")
+	}
+
+	// the refresh waits for the kubelet, lookups meanwhile use the last
+	// pod list
+	refreshed := make(chan *kubernetesPod)
+	go func() { refreshed <- k.lookup(testPodUID, now.Add(time.Minute)) }()
+	deadline := time.After(5 * time.Second)
+	for {
+		k.mu.Lock()
+		fetching := k.fetched.Equal(now.Add(time.Minute))
+		k.mu.Unlock()
+		if fetching {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("the pod list was not refreshed")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	looked := make(chan *kubernetesPod)
+	go func() { looked <- k.lookup(testPodUID, now.Add(time.Minute)) }()
+	select {
+	case pod := <-looked:
+		if pod == nil {
+			t.Error("the pod was not found in the last pod list")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lookup waited for the kubelet")
+	}
+
+	release <- struct{}{}
+	if pod := <-refreshed; pod == nil {
+		t.Error("the pod was not found in the refreshed pod list")
+	}
+}
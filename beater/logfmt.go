@@ -0,0 +1,173 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"strconv"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+// defaultLogfmtTarget is where the pairs go if the rule has no target
+const defaultLogfmtTarget = "logfmt"
+
+type logfmtPair struct {
+	key   string
+	value string
+}
+
+// logfmtParser extracts key=value pairs from the messages of the entries its
+// rules select
+type logfmtParser struct {
+	rules            []config.LogfmtParsing
+	convertToNumbers bool
+}
+
+// parse adds the pairs of the message of an entry to the event. Values are
+// converted to the types of the rule, or guessed with guessValue. It returns
+// the keys whose values failed to convert, their values are kept under
+// unconvertedKey. Keys that occur more than once get a list of all their
+// values.
+func (p *logfmtParser) parse(event common.MapStr, fields map[string]string) []string {
+	var rule *config.LogfmtParsing
+	for i := range p.rules {
+		if matchUnitOrType(p.rules[i].Units, p.rules[i].Identifiers, fields, fields[tagField]) {
+			rule = &p.rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return nil
+	}
+
+	pairs := parseLogfmt(fields[messageField])
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	target := rule.Target
+	if target == "" {
+		target = defaultLogfmtTarget
+	}
+
+	var failed []string
+	values := common.MapStr{}
+	types := FieldSchema(rule.Types)
+	for _, pair := range pairs {
+		if _, typed := types[pair.key]; !typed {
+			addLogfmtValue(values, pair.key, guessValue(pair.value, p.convertToNumbers))
+			continue
+		}
+		v, err := types.convert(pair.key, pair.value)
+		if err != nil {
+			failed = append(failed, pair.key)
+			event.Put(unconvertedKey+"."+target+"."+pair.key, pair.value)
+			continue
+		}
+		addLogfmtValue(values, pair.key, v)
+	}
+	event.Put(target, values)
+	return failed
+}
+
+// addLogfmtValue adds the value of a key, the values of a repeated key are
+// collected in a list
+func addLogfmtValue(values common.MapStr, key string, v interface{}) {
+	switch prev := values[key].(type) {
+	case nil:
+		values[key] = v
+	case []interface{}:
+		values[key] = append(prev, v)
+	default:
+		values[key] = []interface{}{prev, v}
+	}
+}
+
+// parseLogfmt returns the key=value pairs of a message in their order.
+// Values are either bare up to the next space or double quoted with Go
+// escapes, as written by logrus and zap. Words that are not pairs are
+// skipped, so pairs embedded in prose are found as well.
+func parseLogfmt(s string) []logfmtPair {
+	var pairs []logfmtPair
+	i := 0
+	for i < len(s) {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		start := i
+		for i < len(s) && isKeyChar(s[i]) {
+			i++
+		}
+		key := s[start:i]
+		if key == "" || i == len(s) || s[i] != '=' || !isKeyStart(key[0]) {
+			// not a pair, skip the word
+			for i < len(s) && !isSpace(s[i]) {
+				i++
+			}
+			continue
+		}
+		i++
+
+		var value string
+		if i < len(s) && s[i] == '"' {
+			end := closingQuote(s, i)
+			if end < 0 {
+				// unterminated, the rest of the message is the value
+				value, i = s[i+1:], len(s)
+			} else {
+				var err error
+				if value, err = strconv.Unquote(s[i : end+1]); err != nil {
+					value = s[i+1 : end]
+				}
+				i = end + 1
+			}
+		} else {
+			start = i
+			for i < len(s) && !isSpace(s[i]) {
+				i++
+			}
+			value = s[start:i]
+		}
+		pairs = append(pairs, logfmtPair{key: key, value: value})
+	}
+	return pairs
+}
+
+// closingQuote returns the index of the quote that ends the quoted string
+// starting at s[open], or -1
+func closingQuote(s string, open int) int {
+	for i := open + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isKeyStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isKeyChar(c byte) bool {
+	return isKeyStart(c) || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '/'
+}
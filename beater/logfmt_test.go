@@ -0,0 +1,94 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+func TestLogfmtParse(t *testing.T) {
+	tests := []struct {
+		name             string
+		rule             config.LogfmtParsing
+		convertToNumbers bool
+		message          string
+		want             common.MapStr
+		failed           []string
+	}{
+		{
+			name:    "strings",
+			message: `level=info msg="request done" status=200 took=1.5`,
+			want: common.MapStr{"logfmt": common.MapStr{
+				"level": "info", "msg": "request done", "status": "200", "took": "1.5",
+			}},
+		},
+		{
+			name:             "guessed",
+			convertToNumbers: true,
+			message:          `ok=true cached=False status=200 delta=-3 took=1.5 id=0x1f`,
+			want: common.MapStr{"logfmt": common.MapStr{
+				"ok": true, "cached": false, "status": uint64(200), "delta": int64(-3), "took": 1.5, "id": "0x1f",
+			}},
+		},
+		{
+			name:    "booleans without convert_to_numbers",
+			message: `ok=true status=200`,
+			want:    common.MapStr{"logfmt": common.MapStr{"ok": true, "status": "200"}},
+		},
+		{
+			name: "typed",
+			rule: config.LogfmtParsing{Target: "api", Types: map[string]string{
+				"status": config.FieldTypeInteger, "code": config.FieldTypeString,
+			}},
+			convertToNumbers: true,
+			message:          `status=200 code=404 user=bob`,
+			want:             common.MapStr{"api": common.MapStr{"status": int64(200), "code": "404", "user": "bob"}},
+		},
+		{
+			name:    "unconverted",
+			rule:    config.LogfmtParsing{Types: map[string]string{"status": config.FieldTypeInteger}},
+			message: `status=teapot user=bob`,
+			want: common.MapStr{
+				"logfmt":      common.MapStr{"user": "bob"},
+				"unconverted": common.MapStr{"logfmt": common.MapStr{"status": "teapot"}},
+			},
+			failed: []string{"status"},
+		},
+		{
+			name:    "repeated keys",
+			message: `tag=a tag=b tag=c`,
+			want:    common.MapStr{"logfmt": common.MapStr{"tag": []interface{}{"a", "b", "c"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			rule.Units = []string{"api.service"}
+			p := &logfmtParser{rules: []config.LogfmtParsing{rule}, convertToNumbers: tt.convertToNumbers}
+			event := common.MapStr{}
+			failed := p.parse(event, map[string]string{"_SYSTEMD_UNIT": "api.service", "MESSAGE": tt.message})
+			if !reflect.DeepEqual(event, tt.want) {
+				t.Errorf("event = %v, want %v", event, tt.want)
+			}
+			if !reflect.DeepEqual(failed, tt.failed) {
+				t.Errorf("failed = %v, want %v", failed, tt.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+func TestRedactPAN(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{message: "card 4111111111111111 charged", want: "card [REDACTED] charged"},
+		{message: "card 4111 1111 1111 1111 charged", want: "card [REDACTED] charged"},
+		{message: "card 4111-1111-1111-1111", want: "card [REDACTED]"},
+		// the card number runs into the expiry date
+		{message: "card 4111 1111 1111 1111 12/25", want: "card [REDACTED] 12/25"},
+		{message: "card 4111 1111 1111 1111 123 cvv", want: "card [REDACTED] 123 cvv"},
+		{message: "paid 12 4111 1111 1111 1111", want: "paid 12 [REDACTED]"},
+		{message: "cards 4111111111111111 5500000000000004", want: "cards [REDACTED] [REDACTED]"},
+		// numbers that fail the Luhn check are kept, their parts are only
+		// checked at separators
+		{message: "order 4111111111111112", want: "order 4111111111111112"},
+		{message: "order 1234567890123456789", want: "order 1234567890123456789"},
+		{message: "order 1234 5678 9012 3456", want: "order 1234 5678 9012 3456"},
+	}
+	r := newRedactor([]config.RedactionRule{{Name: "cards", Detector: config.DetectorPAN}})
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			event := common.MapStr{"message": tt.message}
+			r.redact(event)
+			if event["message"] != tt.want {
+				t.Errorf("message = %q, want %q", event["message"], tt.want)
+			}
+		})
+	}
+}
+
+// redactTestEvent is an event with secrets in the fields decoded from JSON,
+// logfmt, grok and audit records
+func redactTestEvent() common.MapStr {
+	return common.MapStr{
+		"message": "user bob paid",
+		"cursor":  "s=4111111111111111",
+		"json": common.MapStr{
+			"card":  "4111111111111111",
+			"order": common.MapStr{"card": "4111 1111 1111 1111", "items": []interface{}{"book", common.MapStr{"card": "5500000000000004"}}},
+		},
+		"logfmt": common.MapStr{"card": []interface{}{"4111111111111111", "none"}},
+		"api":    common.MapStr{"card": "5500000000000004", "status": int64(200)},
+		"audit": common.MapStr{
+			"execve": common.MapStr{"args": []interface{}{"pay", "--card", "4111111111111111"}},
+			"path":   []common.MapStr{{"name": "/tmp/4111111111111111"}, {"name": "/tmp/x"}},
+		},
+	}
+}
+
+func TestRedactFieldPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		action string
+		want   common.MapStr
+	}{
+		{
+			name:   "exact",
+			fields: []string{"json.card", "api.card"},
+			want: common.MapStr{
+				"message": "user bob paid",
+				"cursor":  "s=4111111111111111",
+				"json": common.MapStr{
+					"card":  "[REDACTED]",
+					"order": common.MapStr{"card": "4111 1111 1111 1111", "items": []interface{}{"book", common.MapStr{"card": "5500000000000004"}}},
+				},
+				"logfmt": common.MapStr{"card": []interface{}{"4111111111111111", "none"}},
+				"api":    common.MapStr{"card": "[REDACTED]", "status": int64(200)},
+				"audit": common.MapStr{
+					"execve": common.MapStr{"args": []interface{}{"pay", "--card", "4111111111111111"}},
+					"path":   []common.MapStr{{"name": "/tmp/4111111111111111"}, {"name": "/tmp/x"}},
+				},
+			},
+		},
+		{
+			name:   "subtrees and wildcards",
+			fields: []string{"json", "logfmt", "*.card", "audit.*.args"},
+			want: common.MapStr{
+				"message": "user bob paid",
+				"cursor":  "s=4111111111111111",
+				"json": common.MapStr{
+					"card":  "[REDACTED]",
+					"order": common.MapStr{"card": "[REDACTED]", "items": []interface{}{"book", common.MapStr{"card": "[REDACTED]"}}},
+				},
+				"logfmt": common.MapStr{"card": []interface{}{"[REDACTED]", "none"}},
+				"api":    common.MapStr{"card": "[REDACTED]", "status": int64(200)},
+				"audit": common.MapStr{
+					"execve": common.MapStr{"args": []interface{}{"pay", "--card", "[REDACTED]"}},
+					"path":   []common.MapStr{{"name": "/tmp/4111111111111111"}, {"name": "/tmp/x"}},
+				},
+			},
+		},
+		{
+			name:   "all fields",
+			fields: []string{"*"},
+			action: config.RedactDrop,
+			want: common.MapStr{
+				"message": "user bob paid",
+				// the keys journalbeat relies on are left alone
+				"cursor": "s=4111111111111111",
+				// lists are dropped as a whole
+				"json":   common.MapStr{"order": common.MapStr{}},
+				"logfmt": common.MapStr{},
+				"api":    common.MapStr{"status": int64(200)},
+				"audit":  common.MapStr{"execve": common.MapStr{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRedactor([]config.RedactionRule{{Name: "cards", Pattern: `[0-9]{4}(?: ?[0-9]{4}){3}`, Fields: tt.fields, Action: tt.action}})
+			event := redactTestEvent()
+			r.redact(event)
+			if !reflect.DeepEqual(event, tt.want) {
+				t.Errorf("event = %v, want %v", event, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"strconv"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+const (
+	facilityField    string = "SYSLOG_FACILITY"
+	lowestSeverity   int    = 7
+	numberFacilities int    = 24
+)
+
+// facilities are the syslog facility names, indexed by their code
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// parseCode parses a syslog severity or facility code below limit
+func parseCode(value string, limit int) (int, bool) {
+	code, err := strconv.Atoi(value)
+	if err != nil || code < 0 || code >= limit {
+		return 0, false
+	}
+	return code, true
+}
+
+// decodePriority adds the names of the syslog severity and facility of an
+// entry to the event, below prefix
+func decodePriority(event common.MapStr, prefix string, fields map[string]string) {
+	if severity, ok := parseCode(fields[priorityField], len(config.Severities)); ok {
+		event.Put(prefix+"log.level", config.Severities[severity])
+		event.Put(prefix+"log.syslog.severity.code", severity)
+		event.Put(prefix+"log.syslog.severity.name", config.Severities[severity])
+	}
+	if facility, ok := parseCode(fields[facilityField], numberFacilities); ok {
+		event.Put(prefix+"log.syslog.facility.code", facility)
+		event.Put(prefix+"log.syslog.facility.name", facilities[facility])
+	}
+}
+
+// severityFilter drops the entries below the minimum severity of their unit
+// or event type
+type severityFilter struct {
+	min       int
+	overrides []severityOverride
+}
+
+type severityOverride struct {
+	units []string
+	types []string
+	min   int
+}
+
+// newSeverityFilter returns nil if the config lets all entries pass
+func newSeverityFilter(c config.Config) *severityFilter {
+	f := &severityFilter{}
+	f.min, _ = config.ParseSeverity(c.MinSeverity)
+	for _, o := range c.MinSeverityOverrides {
+		min, _ := config.ParseSeverity(o.MinSeverity)
+		f.overrides = append(f.overrides, severityOverride{units: o.Units, types: o.Types, min: min})
+	}
+	if f.min == lowestSeverity && len(f.overrides) == 0 {
+		return nil
+	}
+	return f
+}
+
+// minSeverity returns the minimum severity of the first override that
+// matches the unit or type of an entry, or the global one
+func (f *severityFilter) minSeverity(fields map[string]string, eventType string) int {
+	for _, o := range f.overrides {
+		if matchUnitOrType(o.units, o.types, fields, eventType) {
+			return o.min
+		}
+	}
+	return f.min
+}
+
+// drop tells if an entry is less severe than its minimum severity. Entries
+// without a valid PRIORITY are kept.
+func (f *severityFilter) drop(fields map[string]string, eventType string) bool {
+	severity, ok := parseCode(fields[priorityField], len(config.Severities))
+	return ok && severity > f.minSeverity(fields, eventType)
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/medallia/journalbeat/config"
+)
+
+func TestDecodePriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   common.MapStr
+	}{
+		{
+			name:   "severity and facility",
+			fields: map[string]string{"PRIORITY": "3", "SYSLOG_FACILITY": "3"},
+			want: common.MapStr{"log": common.MapStr{
+				"level": "err",
+				"syslog": common.MapStr{
+					"severity": common.MapStr{"code": 3, "name": "err"},
+					"facility": common.MapStr{"code": 3, "name": "daemon"},
+				},
+			}},
+		},
+		{
+			name:   "emerg",
+			fields: map[string]string{"PRIORITY": "0"},
+			want: common.MapStr{"log": common.MapStr{
+				"level":  "emerg",
+				"syslog": common.MapStr{"severity": common.MapStr{"code": 0, "name": "emerg"}},
+			}},
+		},
+		{
+			name:   "debug without a facility",
+			fields: map[string]string{"PRIORITY": "7", "SYSLOG_FACILITY": "24"},
+			want: common.MapStr{"log": common.MapStr{
+				"level":  "debug",
+				"syslog": common.MapStr{"severity": common.MapStr{"code": 7, "name": "debug"}},
+			}},
+		},
+		{
+			name:   "facility without a severity",
+			fields: map[string]string{"PRIORITY": "8", "SYSLOG_FACILITY": "23"},
+			want: common.MapStr{"log": common.MapStr{
+				"syslog": common.MapStr{"facility": common.MapStr{"code": 23, "name": "local7"}},
+			}},
+		},
+		{name: "no priority", fields: map[string]string{"MESSAGE": "x"}, want: common.MapStr{}},
+		{name: "empty priority", fields: map[string]string{"PRIORITY": ""}, want: common.MapStr{}},
+		{name: "negative priority", fields: map[string]string{"PRIORITY": "-1"}, want: common.MapStr{}},
+		{name: "priority name", fields: map[string]string{"PRIORITY": "err"}, want: common.MapStr{}},
+		{name: "padded priority", fields: map[string]string{"PRIORITY": " 3"}, want: common.MapStr{}},
+		{name: "priority with facility bits", fields: map[string]string{"PRIORITY": "27"}, want: common.MapStr{}},
+		{name: "fractional priority", fields: map[string]string{"PRIORITY": "3.0"}, want: common.MapStr{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := common.MapStr{}
+			decodePriority(event, "", tt.fields)
+			if !reflect.DeepEqual(event, tt.want) {
+				t.Errorf("event = %v, want %v", event, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeFacilities(t *testing.T) {
+	names := []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	for code, name := range names {
+		event := common.MapStr{}
+		decodePriority(event, "journal.", map[string]string{"SYSLOG_FACILITY": strconv.Itoa(code)})
+		if got, _ := event.GetValue("journal.log.syslog.facility.name"); got != name {
+			t.Errorf("facility %d = %v, want %s", code, got, name)
+		}
+		if got, _ := event.GetValue("journal.log.syslog.facility.code"); got != code {
+			t.Errorf("facility code %d = %v", code, got)
+		}
+	}
+}
+
+func TestSeverityFilter(t *testing.T) {
+	c := config.DefaultConfig
+	if newSeverityFilter(c) != nil {
+		t.Fatal("the default config drops entries")
+	}
+
+	c.MinSeverity = "warning"
+	c.MinSeverityOverrides = []config.SeverityOverride{
+		{Units: []string{"debug-*.service"}, MinSeverity: "debug"},
+		{Types: []string{"audit"}, Units: []string{"noisy.service"}, MinSeverity: "err"},
+	}
+	f := newSeverityFilter(c)
+	tests := []struct {
+		name      string
+		fields    map[string]string
+		eventType string
+		drop      bool
+	}{
+		{"warning", map[string]string{"PRIORITY": "4"}, "journal", false},
+		{"notice", map[string]string{"PRIORITY": "5"}, "journal", true},
+		{"no priority", map[string]string{}, "journal", false},
+		{"bad priority", map[string]string{"PRIORITY": "info"}, "journal", false},
+		{"debug of a debug unit", map[string]string{"PRIORITY": "7", "_SYSTEMD_UNIT": "debug-api.service"}, "journal", false},
+		{"debug of a user debug unit", map[string]string{"PRIORITY": "7", "_SYSTEMD_USER_UNIT": "debug-api.service"}, "journal", false},
+		{"debug of another unit", map[string]string{"PRIORITY": "7", "_SYSTEMD_UNIT": "api.service"}, "journal", true},
+		{"warning of the audit type", map[string]string{"PRIORITY": "4"}, "audit", true},
+		{"err of the audit type", map[string]string{"PRIORITY": "3"}, "audit", false},
+		{"warning of a noisy unit", map[string]string{"PRIORITY": "4", "_SYSTEMD_UNIT": "noisy.service"}, "journal", true},
+		{"the first override wins", map[string]string{"PRIORITY": "7", "_SYSTEMD_UNIT": "debug-api.service"}, "audit", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.drop(tt.fields, tt.eventType); got != tt.drop {
+				t.Errorf("drop = %v, want %v", got, tt.drop)
+			}
+		})
+	}
+}
+
+func TestMinSeverity(t *testing.T) {
+	entries := testEntries(
+		map[string]string{"MESSAGE": "crit", "PRIORITY": "2", "_SYSTEMD_UNIT": "api.service"},
+		map[string]string{"MESSAGE": "info", "PRIORITY": "6", "_SYSTEMD_UNIT": "api.service"},
+		map[string]string{"MESSAGE": "debug", "PRIORITY": "7", "_SYSTEMD_UNIT": "debug-api.service"},
+		map[string]string{"MESSAGE": "no priority", "_SYSTEMD_UNIT": "api.service"},
+	)
+	events := runEntries(t, map[string]interface{}{
+		"decode_priority": true,
+		"min_severity":    "warn",
+		"min_severity_overrides": []map[string]interface{}{
+			{"units": []string{"debug-*"}, "min_severity": "7"},
+		},
+	}, entries)
+	if got, want := messages(events), []string{"crit", "debug", "no priority"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("messages = %q, want %q", got, want)
+	}
+	if level, _ := events[0].GetValue("log.level"); level != "crit" {
+		t.Errorf("log.level = %v, want crit", level)
+	}
+	if _, err := events[2].GetValue("log.level"); err == nil {
+		t.Error("log.level of an entry without PRIORITY")
+	}
+}
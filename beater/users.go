@@ -0,0 +1,182 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// idFileCheck is how often the files are checked for changes
+const idFileCheck = 10 * time.Second
+
+// idName is where the name of an id field goes and whether it is a group
+type idName struct {
+	key   string
+	group bool
+}
+
+// idNames are the id fields that can be resolved to names
+var idNames = map[string]idName{
+	"_UID":            {key: "user.name"},
+	"_GID":            {key: "group.name", group: true},
+	"_AUDIT_LOGINUID": {key: "user.audit.name"},
+	"OBJECT_UID":      {key: "user.target.name"},
+	"OBJECT_GID":      {key: "user.target.group.name", group: true},
+}
+
+// idFile is a passwd or group file, whose names are reread when it changes
+type idFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	names   map[string]string
+}
+
+// idResolver resolves the user and group ids of entries to the names of
+// the passwd and group files below its root
+type idResolver struct {
+	fields []string
+	// the files are only touched by the goroutine refreshing them
+	users  *idFile
+	groups *idFile
+
+	mu         sync.Mutex
+	checked    time.Time
+	refreshing bool
+	userNames  map[string]string
+	groupNames map[string]string
+}
+
+func newIDResolver(fields []string, root string) *idResolver {
+	r := &idResolver{
+		fields: fields,
+		users:  &idFile{path: filepath.Join(root, "etc", "passwd")},
+		groups: &idFile{path: filepath.Join(root, "etc", "group")},
+	}
+	r.refresh(time.Now())
+	return r
+}
+
+// resolve adds the names of the ids of an entry to the event, unknown ids
+// are left out
+func (r *idResolver) resolve(event common.MapStr, fields map[string]string) {
+	users, groups := r.names(time.Now())
+	for _, field := range r.fields {
+		id, ok := fields[field]
+		if !ok {
+			continue
+		}
+		n := idNames[field]
+		names := users
+		if n.group {
+			names = groups
+		}
+		if name, ok := names[id]; ok {
+			event.Put(n.key, name)
+		}
+	}
+}
+
+// names returns the names of the user and group ids. The files are checked
+// for changes every idFileCheck, outside of the lock, so that the other
+// entries are resolved with the previous names meanwhile.
+func (r *idResolver) names(now time.Time) (map[string]string, map[string]string) {
+	r.mu.Lock()
+	check := !r.refreshing && now.Sub(r.checked) >= idFileCheck
+	if check {
+		r.refreshing = true
+	}
+	users, groups := r.userNames, r.groupNames
+	r.mu.Unlock()
+	if !check {
+		return users, groups
+	}
+	return r.refresh(now)
+}
+
+// refresh rereads the files that changed since they were read and swaps in
+// their names
+func (r *idResolver) refresh(now time.Time) (map[string]string, map[string]string) {
+	r.users.refresh()
+	r.groups.refresh()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checked, r.refreshing = now, false
+	r.userNames, r.groupNames = r.users.names, r.groups.names
+	return r.userNames, r.groupNames
+}
+
+// refresh rereads the names of the file if its modification time or size
+// changed. The names read last are kept if it can not be read.
+func (f *idFile) refresh() {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if f.names == nil {
+			logp.Warn("Can not resolve the ids of %s: %v", f.path, err)
+			f.names = map[string]string{}
+		}
+		return
+	}
+	if f.names != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return
+	}
+	names, err := readIDFile(f.path)
+	if err != nil {
+		logp.Warn("Reading %s failed: %v", f.path, err)
+		if f.names == nil {
+			f.names = map[string]string{}
+		}
+		return
+	}
+	logp.Debug("journalbeat", "Read %d ids from %s", len(names), f.path)
+	f.names, f.modTime, f.size = names, info.ModTime(), info.Size()
+}
+
+// readIDFile maps the ids of a passwd or group file to their names. Both
+// have the name in the first and the id in the third field, the first entry
+// of an id wins.
+func readIDFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	names := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 3 || parts[0] == "" {
+			continue
+		}
+		if _, ok := names[parts[2]]; !ok {
+			names[parts[2]] = parts[0]
+		}
+	}
+	return names, scanner.Err()
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	testPasswd = "root:x:0:0:root:/root:/bin/bash\nalice:x:100:100::/home/alice:/bin/sh\n"
+	testGroup  = "root:x:0:\nstaff:x:100:alice\n"
+)
+
+// writeIDFiles writes the passwd and group files below root and sets their
+// modification time
+func writeIDFiles(t *testing.T, root, passwd, group string, modTime time.Time) {
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"passwd": passwd, "group": group} {
+		path := filepath.Join(root, "etc", name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tempRoot returns a temporary id_root
+func tempRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "journalbeat-ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	return root
+}
+
+func TestReadIDFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{
+			name:    "passwd",
+			content: testPasswd,
+			want:    map[string]string{"0": "root", "100": "alice"},
+		},
+		{
+			name:    "group",
+			content: testGroup,
+			want:    map[string]string{"0": "root", "100": "staff"},
+		},
+		{
+			name:    "comments and empty lines",
+			content: "# users\n\nroot:x:0:0::/root:/bin/sh\n  \n",
+			want:    map[string]string{"0": "root"},
+		},
+		{
+			name:    "short lines and empty names",
+			content: "nobody\nbin:x\n:x:2:2::/:/bin/false\nroot:x:0:0::/root:/bin/sh\n",
+			want:    map[string]string{"0": "root"},
+		},
+		{
+			name:    "first of an id wins",
+			content: "root:x:0:0::/root:/bin/sh\ntoor:x:0:0::/root:/bin/csh\n",
+			want:    map[string]string{"0": "root"},
+		},
+		{
+			name:    "only the three fields of a group",
+			content: "wheel:x:10\n",
+			want:    map[string]string{"10": "wheel"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempRoot(t), "passwd")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readIDFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDResolver(t *testing.T) {
+	root := tempRoot(t)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeIDFiles(t, root, testPasswd, testGroup, modTime)
+
+	r := newIDResolver([]string{"_UID", "_GID", "_AUDIT_LOGINUID", "OBJECT_UID", "OBJECT_GID"}, root)
+	event := common.MapStr{}
+	r.resolve(event, map[string]string{
+		"_UID":            "100",
+		"_GID":            "100",
+		"_AUDIT_LOGINUID": "4294967295",
+		"OBJECT_GID":      "0",
+	})
+	// users and groups are looked up in their own files, unknown ids and
+	// missing fields are left out
+	want := common.MapStr{
+		"user":  common.MapStr{"name": "alice", "target": common.MapStr{"group": common.MapStr{"name": "root"}}},
+		"group": common.MapStr{"name": "staff"},
+	}
+	if !reflect.DeepEqual(event, want) {
+		t.Fatalf("event = %v, want %v", event, want)
+	}
+
+	checked := r.checked
+	lookup := func(now time.Time) (string, string) {
+		users, groups := r.names(now)
+		return users["100"], groups["100"]
+	}
+
+	// same size, new modification time
+	writeIDFiles(t, root,
+		"root:x:0:0:root:/root:/bin/bash\ncarol:x:100:100::/home/alice:/bin/sh\n",
+		"root:x:0:\nusers:x:100:alice\n",
+		modTime.Add(time.Second))
+	if user, group := lookup(checked.Add(idFileCheck / 2)); user != "alice" || group != "staff" {
+		t.Errorf("the files were reread before idFileCheck passed: %s, %s", user, group)
+	}
+	checked = checked.Add(idFileCheck)
+	if user, group := lookup(checked); user != "carol" || group != "users" {
+		t.Errorf("the new modification time was not noticed: %s, %s", user, group)
+	}
+
+	// new size, same modification time
+	writeIDFiles(t, root,
+		"root:x:0:0:root:/root:/bin/bash\ndave:x:100:100::/home/alice:/bin/sh\n",
+		"root:x:0:\nstaff2:x:100:alice\n",
+		modTime.Add(time.Second))
+	checked = checked.Add(idFileCheck)
+	if user, group := lookup(checked); user != "dave" || group != "staff2" {
+		t.Errorf("the new size was not noticed: %s, %s", user, group)
+	}
+
+	// unreadable files keep the names read last
+	if err := os.RemoveAll(filepath.Join(root, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	checked = checked.Add(idFileCheck)
+	if user, group := lookup(checked); user != "dave" || group != "staff2" {
+		t.Errorf("the names were dropped with the files: %s, %s", user, group)
+	}
+}
+
+func TestIDResolverMissingFiles(t *testing.T) {
+	root := tempRoot(t)
+	r := newIDResolver([]string{"_UID"}, root)
+	event := common.MapStr{}
+	r.resolve(event, map[string]string{"_UID": "0"})
+	if len(event) != 0 {
+		t.Errorf("event = %v without passwd file", event)
+	}
+
+	writeIDFiles(t, root, testPasswd, testGroup, time.Now())
+	users, _ := r.names(r.checked.Add(idFileCheck))
+	if users["0"] != "root" {
+		t.Errorf("the passwd file was not read once it appeared: %v", users)
+	}
+}
+
+func TestResolveIDs(t *testing.T) {
+	root := tempRoot(t)
+	writeIDFiles(t, root, testPasswd, testGroup, time.Now())
+	fields := map[string]string{"MESSAGE": "hello", "_UID": "100", "_GID": "0", "OBJECT_UID": "0"}
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "user",
+			fields: []string{"_UID"},
+			want:   map[string]interface{}{"user.name": "alice"},
+		},
+		{
+			name:   "group and target user",
+			fields: []string{"_GID", "OBJECT_UID"},
+			want:   map[string]interface{}{"group.name": "root", "user.target.name": "root"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := runEntries(t, map[string]interface{}{
+				"resolve_ids": tt.fields,
+				"id_root":     root,
+			}, testEntries(fields))
+			if len(events) != 1 {
+				t.Fatalf("got %d events", len(events))
+			}
+			for _, key := range []string{"user.name", "group.name", "user.target.name"} {
+				got, err := events[0].GetValue(key)
+				want, ok := tt.want[key]
+				if ok && got != want {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+				if !ok && err == nil {
+					t.Errorf("%s = %v was not selected", key, got)
+				}
+			}
+		})
+	}
+}
@@ -19,8 +19,14 @@ package config
 
 import (
 	"fmt"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/medallia/journalbeat/filter"
+	"github.com/medallia/journalbeat/grok"
 )
 
 // Config provides the config settings for the journald reader
@@ -40,6 +46,139 @@ type Config struct {
 	MetricsEnabled       bool          	`config:"enable_metrics"`
 	WavefrontCollector   string        	`config:"wavefront_collector"`
 	HostTags             map[string]string  `config:"wavefront_tags"`
+	Backend              string        	`config:"backend"`
+	FixtureFile          string        	`config:"fixture_file"`
+	JournalFiles         []string      	`config:"journal_files"`
+	JournalDirectories   []string      	`config:"journal_directories"`
+	Filter               string        	`config:"filter"`
+	Since                string        	`config:"since"`
+	Until                string        	`config:"until"`
+	ReopenBackoff        time.Duration 	`config:"reopen_backoff"`
+	ReopenMaxBackoff     time.Duration 	`config:"reopen_max_backoff"`
+	DetectGaps           bool          	`config:"detect_gaps"`
+	FollowBufferSize     int           	`config:"follow_buffer_size"`
+	WaitTimeout          time.Duration 	`config:"wait_timeout"`
+	FieldSelection                     	`config:",inline"`
+	FieldOverrides       map[string]FieldSelection 	`config:"field_overrides"`
+	ClassificationRules  []ClassificationRule 	`config:"classification_rules"`
+	FieldTypes           map[string]string 	`config:"field_types"`
+	FieldMapping         string        	`config:"field_mapping"`
+	TimestampSource      string        	`config:"timestamp_source"`
+	DecodePriority       bool          	`config:"decode_priority"`
+	MinSeverity          string        	`config:"min_severity"`
+	MinSeverityOverrides []SeverityOverride 	`config:"min_severity_overrides"`
+	JSONDecoding         []JSONDecoding 	`config:"json_decoding"`
+	Logfmt               []LogfmtParsing 	`config:"logfmt"`
+	GrokPatterns         map[string]string 	`config:"grok_patterns"`
+	Grok                 []GrokExtraction 	`config:"grok"`
+	ParseAudit           bool          	`config:"parse_audit"`
+	CorrelateAudit       bool          	`config:"correlate_audit"`
+	AuditTimeout         time.Duration 	`config:"audit_timeout"`
+	ParseKernel          bool          	`config:"parse_kernel"`
+	KernelReportTimeout  time.Duration 	`config:"kernel_report_timeout"`
+	KernelReportMaxLines int           	`config:"kernel_report_max_lines"`
+	KernelReportMaxAge   time.Duration 	`config:"kernel_report_max_age"`
+	ParseCoredumps       bool          	`config:"parse_coredumps"`
+	CoredumpPayload      bool          	`config:"coredump_payload"`
+	Redaction            []RedactionRule 	`config:"redaction"`
+	ContainerMetadata    bool          	`config:"container_metadata"`
+	ContainerSocket      string        	`config:"container_socket"`
+	ContainerTimeout     time.Duration 	`config:"container_timeout"`
+	ContainerCacheSize   int           	`config:"container_cache_size"`
+	ContainerCacheTTL    time.Duration 	`config:"container_cache_ttl"`
+	ContainerNegativeTTL time.Duration 	`config:"container_negative_ttl"`
+	KubernetesMetadata   bool          	`config:"kubernetes_metadata"`
+	KubeletPodsURL       string        	`config:"kubelet_pods_url"`
+	KubeletTokenFile     string        	`config:"kubelet_token_file"`
+	KubeletCAFile        string        	`config:"kubelet_ca_file"`
+	KubeletInsecure      bool          	`config:"kubelet_insecure"`
+	KubeletRefresh       time.Duration 	`config:"kubelet_refresh"`
+	PartitionBy          string        	`config:"partition_by"`
+	ResolveIDs           []string      	`config:"resolve_ids"`
+	IDRoot               string        	`config:"id_root"`
+}
+
+// JSONDecoding decodes the messages of the units or types matching one of its
+// glob patterns that are JSON objects. Without units and types it applies to
+// all entries. The object is nested under Target, or merged into the event if
+// Target is empty.
+type JSONDecoding struct {
+	Units                []string      	`config:"units"`
+	Types                []string      	`config:"types"`
+	Target               string        	`config:"target"`
+	OverwriteKeys        bool          	`config:"overwrite_keys"`
+	KeepMessage          bool          	`config:"keep_message"`
+}
+
+// LogfmtParsing extracts the key=value pairs of the messages of the units or
+// syslog identifiers matching one of its glob patterns into Target. Types
+// converts the values of keys to one of the field types, the other values
+// are typed like the journal fields before field_types.
+type LogfmtParsing struct {
+	Units                []string      	`config:"units"`
+	Identifiers          []string      	`config:"identifiers"`
+	Target               string        	`config:"target"`
+	Types                map[string]string 	`config:"types"`
+}
+
+// GrokExtraction extracts fields from the messages of the units or syslog
+// identifiers matching one of its glob patterns with the first of its grok
+// patterns that matches. The captures go into Target, or into the event if
+// Target is empty. Name identifies the match and miss counters of the rule.
+type GrokExtraction struct {
+	Name                 string        	`config:"name"`
+	Units                []string      	`config:"units"`
+	Identifiers          []string      	`config:"identifiers"`
+	Patterns             []string      	`config:"patterns"`
+	Target               string        	`config:"target"`
+}
+
+// RedactionRule redacts what its Pattern or built-in Detector finds in the
+// string values of Fields, the message by default. Fields are paths where *
+// matches every key, objects are redacted down to their leaves. If the
+// pattern has a group named secret, only the group is redacted. Action masks the match with Mask,
+// replaces it by its hash keyed with HashKey, which card numbers require, or
+// drops the field. Name identifies the counter of the rule.
+type RedactionRule struct {
+	Name                 string        	`config:"name"`
+	Pattern              string        	`config:"pattern"`
+	Detector             string        	`config:"detector"`
+	Action               string        	`config:"action"`
+	Fields               []string      	`config:"fields"`
+	Mask                 string        	`config:"mask"`
+	HashKey              string        	`config:"hash_key"`
+}
+
+// SeverityOverride sets the minimum severity of the events of the units or
+// types matching one of its glob patterns
+type SeverityOverride struct {
+	Units                []string      	`config:"units"`
+	Types                []string      	`config:"types"`
+	MinSeverity          string        	`config:"min_severity"`
+}
+
+// ClassificationRule sets the type, the multiline buffering key and tags of
+// the events whose journal fields match all the glob patterns of Match. Type,
+// BufferingKey and Tags are templates where %{FIELD} is replaced by the value
+// of the journal field. Fields are the default fields of the events in
+// addition to _HOST_NAME, MESSAGE and PRIORITY.
+type ClassificationRule struct {
+	Match                map[string]string 	`config:"match"`
+	Type                 string        	`config:"type"`
+	BufferingKey         string        	`config:"buffering_key"`
+	Fields               []string      	`config:"fields"`
+	Tags                 []string      	`config:"tags"`
+}
+
+// FieldSelection selects the journal fields that are copied into an event.
+// Fields are selected if they are one of the default fields of the event
+// type, match an include pattern or AllFields is set, and they don't match an
+// exclude pattern. Patterns are globs as in path.Match on the journal field
+// names, e.g. "_SYSTEMD_*".
+type FieldSelection struct {
+	AllFields            bool          	`config:"all_fields"`
+	IncludeFields        []string      	`config:"include_fields"`
+	ExcludeFields        []string      	`config:"exclude_fields"`
 }
 
 // Named constants for the journal cursor placement positions
@@ -47,34 +186,180 @@ const (
 	SeekPositionCursor  = "cursor"
 	SeekPositionHead    = "head"
 	SeekPositionTail    = "tail"
+	SeekPositionSince   = "since"
 	SeekPositionDefault = "none"
 )
 
+// Named constants for the field types of the schema convert_to_numbers applies
+const (
+	FieldTypeString  = "string"
+	FieldTypeInteger = "integer"
+	FieldTypeFloat   = "float"
+	FieldTypeBoolean = "boolean"
+)
+
+// Named constants for the built-in detectors of redaction rules
+const (
+	DetectorPAN          = "pan"
+	DetectorJWT          = "jwt"
+	DetectorAWSKey       = "aws_key"
+	DetectorBasicAuthURL = "basic_auth_url"
+)
+
+// Named constants for the actions of redaction rules
+const (
+	RedactMask = "mask"
+	RedactHash = "hash"
+	RedactDrop = "drop"
+)
+
+// Named constants for what the events are spread over the logstash hosts by
+const (
+	PartitionContainerTag = "container_tag"
+	PartitionPod          = "pod"
+)
+
+// Named constants for the field mappings
+const (
+	FieldMappingJournal = "journal"
+	FieldMappingECS     = "ecs"
+)
+
+// Named constants for the sources of the event @timestamp
+const (
+	TimestampSource   = "source"
+	TimestampRealtime = "realtime"
+	TimestampPublish  = "publish"
+)
+
+// ReservedKeys are the event keys journalbeat relies on, decoded and extracted
+// fields never go there
+var ReservedKeys = map[string]struct{}{
+	"@timestamp":       {},
+	"container_tag":    {},
+	"cursor":           {},
+	"input_type":       {},
+	"logBufferingType": {},
+	"message":          {},
+	"type":             {},
+	"utcTimestamp":     {},
+}
+
+// reservedTarget tells if fields put under target would replace a reserved key
+func reservedTarget(target string) bool {
+	_, reserved := ReservedKeys[strings.SplitN(target, ".", 2)[0]]
+	return reserved
+}
+
+// Severities are the syslog severity names, indexed by their code
+var Severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Named constants for the journal backends
+const (
+	BackendSystemd = "sdjournal"
+	BackendFile    = "file"
+	BackendFixture = "fixture"
+)
+
 var (
+	backends = map[string]struct{}{
+		BackendSystemd: {},
+		BackendFile:    {},
+		BackendFixture: {},
+	}
+
+	timestampSources = map[string]struct{}{
+		TimestampSource:   {},
+		TimestampRealtime: {},
+		TimestampPublish:  {},
+	}
+
+	detectors = map[string]struct{}{
+		DetectorPAN:          {},
+		DetectorJWT:          {},
+		DetectorAWSKey:       {},
+		DetectorBasicAuthURL: {},
+	}
+
+	redactActions = map[string]struct{}{
+		RedactMask: {},
+		RedactHash: {},
+		RedactDrop: {},
+	}
+
+	partitionKeys = map[string]struct{}{
+		PartitionContainerTag: {},
+		PartitionPod:          {},
+	}
+
+	// resolvableIDs are the journal fields resolve_ids can resolve
+	resolvableIDs = map[string]struct{}{
+		"_UID":            {},
+		"_GID":            {},
+		"_AUDIT_LOGINUID": {},
+		"OBJECT_UID":      {},
+		"OBJECT_GID":      {},
+	}
+
+	fieldMappings = map[string]struct{}{
+		FieldMappingJournal: {},
+		FieldMappingECS:     {},
+	}
+
+	fieldTypes = map[string]struct{}{
+		FieldTypeString:  {},
+		FieldTypeInteger: {},
+		FieldTypeFloat:   {},
+		FieldTypeBoolean: {},
+	}
+
 	seekPositions = map[string]struct{}{
 		SeekPositionCursor: {},
 		SeekPositionHead:   {},
 		SeekPositionTail:   {},
+		SeekPositionSince:  {},
 	}
 
 	seekFallbackPositions = map[string]struct{}{
 		SeekPositionDefault: {},
 		SeekPositionHead:    {},
 		SeekPositionTail:    {},
+		SeekPositionSince:   {},
 	}
 
 	// DefaultConfig is an instance of Config with default settings
 	DefaultConfig = Config{
-		SeekPosition:       SeekPositionTail,
-		CursorStateFile:    ".journalbeat-cursor-state",
-		CursorFlushPeriod:  5 * time.Second,
-		CursorSeekFallback: SeekPositionTail,
-		DefaultType:        "journal",
-		FlushLogInterval:   30 * time.Second,
-		MetricsInterval:    30 * time.Second,
-		MetricsEnabled:     false,
-		WavefrontCollector: "",
-		HostTags:           map[string]string{},
+		SeekPosition:         SeekPositionTail,
+		CursorStateFile:      ".journalbeat-cursor-state",
+		CursorFlushPeriod:    5 * time.Second,
+		CursorSeekFallback:   SeekPositionTail,
+		DefaultType:          "journal",
+		FlushLogInterval:     30 * time.Second,
+		MetricsInterval:      30 * time.Second,
+		MetricsEnabled:       false,
+		WavefrontCollector:   "",
+		HostTags:             map[string]string{},
+		Backend:              BackendSystemd,
+		ReopenBackoff:        time.Second,
+		ReopenMaxBackoff:     time.Minute,
+		DetectGaps:           true,
+		FollowBufferSize:     100,
+		WaitTimeout:          time.Second,
+		FieldMapping:         FieldMappingJournal,
+		TimestampSource:      TimestampSource,
+		MinSeverity:          "debug",
+		AuditTimeout:         2 * time.Second,
+		KernelReportTimeout:  2 * time.Second,
+		KernelReportMaxLines: 500,
+		KernelReportMaxAge:   30 * time.Second,
+		ContainerSocket:      "/var/run/docker.sock",
+		ContainerTimeout:     time.Second,
+		ContainerCacheSize:   1000,
+		ContainerCacheTTL:    10 * time.Minute,
+		ContainerNegativeTTL: time.Minute,
+		KubeletRefresh:       time.Minute,
+		PartitionBy:          PartitionContainerTag,
+		IDRoot:               "/",
 	}
 )
 
@@ -87,11 +372,378 @@ func (config *Config) Validate() error {
 	}
 
 	if _, ok := seekPositions[config.SeekPosition]; !ok {
-		return fmt.Errorf("Invalid Seek Position: %v. Should be %s, %s or %s", config.SeekPosition, SeekPositionCursor, SeekPositionHead, SeekPositionTail)
+		return fmt.Errorf("Invalid Seek Position: %v. Should be %s, %s, %s or %s", config.SeekPosition, SeekPositionCursor, SeekPositionHead, SeekPositionTail, SeekPositionSince)
 	}
 
 	if _, ok := seekFallbackPositions[config.CursorSeekFallback]; !ok {
-		return fmt.Errorf("Invalid Cursor Seek Fallback Position: %v. Should be %s, %s or %s", config.SeekPosition, SeekPositionTail, SeekPositionHead, SeekPositionDefault)
+		return fmt.Errorf("Invalid Cursor Seek Fallback Position: %v. Should be %s, %s, %s or %s", config.CursorSeekFallback, SeekPositionTail, SeekPositionHead, SeekPositionSince, SeekPositionDefault)
+	}
+
+	now := time.Now()
+	var since, until time.Time
+	var err error
+	if config.Since != "" {
+		if since, err = ParseTime(config.Since, now); err != nil {
+			return fmt.Errorf("Invalid since: %v", err)
+		}
+	} else if config.SeekPosition == SeekPositionSince || config.CursorSeekFallback == SeekPositionSince {
+		return fmt.Errorf("Seeking to %s requires since to be set", SeekPositionSince)
+	}
+	if config.Until != "" {
+		if until, err = ParseTime(config.Until, now); err != nil {
+			return fmt.Errorf("Invalid until: %v", err)
+		}
+		if !since.IsZero() && !since.Before(until) {
+			return fmt.Errorf("since (%s) has to be before until (%s)", config.Since, config.Until)
+		}
+	}
+
+	if _, ok := backends[config.Backend]; !ok {
+		return fmt.Errorf("Invalid Backend: %v. Should be %s, %s or %s", config.Backend, BackendSystemd, BackendFile, BackendFixture)
+	}
+
+	if config.Backend == BackendFixture && config.FixtureFile == "" {
+		return fmt.Errorf("The %s backend requires fixture_file to be set", BackendFixture)
+	}
+
+	if len(config.JournalDirectories) > 0 {
+		if config.Backend == BackendFixture {
+			return fmt.Errorf("journal_directories can not be used with the %s backend", BackendFixture)
+		}
+		if len(config.JournalFiles) > 0 {
+			return fmt.Errorf("journal_directories and journal_files can not be used together")
+		}
+		seen := map[string]struct{}{}
+		for _, dir := range config.JournalDirectories {
+			if dir == "" {
+				return fmt.Errorf("Empty path in journal_directories")
+			}
+			if _, ok := seen[dir]; ok {
+				return fmt.Errorf("Duplicate path in journal_directories: %s", dir)
+			}
+			seen[dir] = struct{}{}
+		}
+	}
+
+	if config.ReopenBackoff <= 0 || config.ReopenMaxBackoff < config.ReopenBackoff {
+		return fmt.Errorf("reopen_backoff has to be positive and not greater than reopen_max_backoff")
+	}
+
+	if config.FollowBufferSize <= 0 {
+		return fmt.Errorf("follow_buffer_size has to be positive")
+	}
+
+	if config.WaitTimeout <= 0 {
+		return fmt.Errorf("wait_timeout has to be positive")
+	}
+
+	if config.CorrelateAudit && !config.ParseAudit {
+		return fmt.Errorf("correlate_audit needs parse_audit")
+	}
+
+	if config.AuditTimeout <= 0 {
+		return fmt.Errorf("audit_timeout has to be positive")
+	}
+
+	if config.KernelReportTimeout <= 0 {
+		return fmt.Errorf("kernel_report_timeout has to be positive")
+	}
+
+	if config.KernelReportMaxLines <= 0 {
+		return fmt.Errorf("kernel_report_max_lines has to be positive")
+	}
+
+	if config.KernelReportMaxAge <= 0 {
+		return fmt.Errorf("kernel_report_max_age has to be positive")
+	}
+
+	if config.ContainerMetadata {
+		if config.ContainerSocket == "" {
+			return fmt.Errorf("container_metadata needs container_socket")
+		}
+		if config.ContainerTimeout <= 0 || config.ContainerCacheTTL <= 0 || config.ContainerNegativeTTL <= 0 {
+			return fmt.Errorf("container_timeout, container_cache_ttl and container_negative_ttl have to be positive")
+		}
+		if config.ContainerCacheSize <= 0 {
+			return fmt.Errorf("container_cache_size has to be positive")
+		}
+	}
+
+	if config.KubeletPodsURL != "" && !config.KubernetesMetadata {
+		return fmt.Errorf("kubelet_pods_url needs kubernetes_metadata")
+	}
+
+	if config.KubeletRefresh <= 0 {
+		return fmt.Errorf("kubelet_refresh has to be positive")
+	}
+
+	if config.KubeletCAFile != "" && config.KubeletInsecure {
+		return fmt.Errorf("kubelet_ca_file and kubelet_insecure can not be set both")
+	}
+
+	if _, ok := partitionKeys[config.PartitionBy]; !ok {
+		return fmt.Errorf("Unknown partition_by %q, use %s or %s", config.PartitionBy, PartitionContainerTag, PartitionPod)
+	}
+	if config.PartitionBy == PartitionPod && !config.KubernetesMetadata {
+		return fmt.Errorf("partition_by %s needs kubernetes_metadata", PartitionPod)
+	}
+
+	for _, field := range config.ResolveIDs {
+		if _, ok := resolvableIDs[field]; !ok {
+			return fmt.Errorf("Unknown field %s in resolve_ids, use _UID, _GID, _AUDIT_LOGINUID, OBJECT_UID or OBJECT_GID", field)
+		}
+	}
+
+	if err := config.FieldSelection.validate("include_fields/exclude_fields"); err != nil {
+		return err
+	}
+	for eventType, selection := range config.FieldOverrides {
+		if err := selection.validate("field_overrides." + eventType); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := timestampSources[config.TimestampSource]; !ok {
+		return fmt.Errorf("Unknown timestamp_source %q, use %s, %s or %s", config.TimestampSource, TimestampSource, TimestampRealtime, TimestampPublish)
+	}
+
+	if _, err := ParseSeverity(config.MinSeverity); err != nil {
+		return fmt.Errorf("Invalid min_severity: %v", err)
+	}
+	for i, override := range config.MinSeverityOverrides {
+		if _, err := ParseSeverity(override.MinSeverity); err != nil {
+			return fmt.Errorf("Invalid min_severity in min_severity_overrides entry %d: %v", i+1, err)
+		}
+		for _, pattern := range append(append([]string{}, override.Units...), override.Types...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid pattern %q in min_severity_overrides entry %d: %v", pattern, i+1, err)
+			}
+		}
+	}
+
+	for i, decoding := range config.JSONDecoding {
+		for _, pattern := range append(append([]string{}, decoding.Units...), decoding.Types...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid pattern %q in json_decoding entry %d: %v", pattern, i+1, err)
+			}
+		}
+		if validID.MatchString(decoding.Target) || strings.HasPrefix(decoding.Target, ".") || reservedTarget(decoding.Target) {
+			return fmt.Errorf("Invalid target in json_decoding entry %d: %s", i+1, decoding.Target)
+		}
+	}
+
+	for i, parsing := range config.Logfmt {
+		if len(parsing.Units) == 0 && len(parsing.Identifiers) == 0 {
+			return fmt.Errorf("logfmt entry %d needs units or identifiers", i+1)
+		}
+		for _, pattern := range append(append([]string{}, parsing.Units...), parsing.Identifiers...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid pattern %q in logfmt entry %d: %v", pattern, i+1, err)
+			}
+		}
+		if validID.MatchString(parsing.Target) || strings.HasPrefix(parsing.Target, ".") || reservedTarget(parsing.Target) {
+			return fmt.Errorf("Invalid target in logfmt entry %d: %s", i+1, parsing.Target)
+		}
+		for key, typ := range parsing.Types {
+			if _, ok := fieldTypes[typ]; !ok {
+				return fmt.Errorf("Unknown type %q for key %s in logfmt entry %d", typ, key, i+1)
+			}
+		}
+	}
+
+	library := grok.NewLibrary(config.GrokPatterns)
+	for name, pattern := range config.GrokPatterns {
+		if _, err := library.Compile(pattern); err != nil {
+			return fmt.Errorf("Invalid grok_patterns entry %s: %v", name, err)
+		}
+	}
+	names := map[string]struct{}{}
+	for i, extraction := range config.Grok {
+		if extraction.Name == "" {
+			return fmt.Errorf("grok entry %d needs a name", i+1)
+		}
+		if _, ok := names[extraction.Name]; ok {
+			return fmt.Errorf("Duplicate name in grok entries: %s", extraction.Name)
+		}
+		names[extraction.Name] = struct{}{}
+		if len(extraction.Units) == 0 && len(extraction.Identifiers) == 0 {
+			return fmt.Errorf("grok entry %s needs units or identifiers", extraction.Name)
+		}
+		for _, pattern := range append(append([]string{}, extraction.Units...), extraction.Identifiers...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid pattern %q in grok entry %s: %v", pattern, extraction.Name, err)
+			}
+		}
+		if len(extraction.Patterns) == 0 {
+			return fmt.Errorf("grok entry %s needs patterns", extraction.Name)
+		}
+		for _, pattern := range extraction.Patterns {
+			p, err := library.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("Invalid grok pattern %q in grok entry %s: %v", pattern, extraction.Name, err)
+			}
+			if extraction.Target != "" {
+				continue
+			}
+			for _, field := range p.Fields() {
+				if reservedTarget(field) {
+					return fmt.Errorf("Grok pattern %q in grok entry %s captures the reserved field %s, set a target", pattern, extraction.Name, field)
+				}
+			}
+		}
+		if validID.MatchString(extraction.Target) || strings.HasPrefix(extraction.Target, ".") || reservedTarget(extraction.Target) {
+			return fmt.Errorf("Invalid target in grok entry %s: %s", extraction.Name, extraction.Target)
+		}
+	}
+
+	redactionNames := map[string]struct{}{}
+	for i, rule := range config.Redaction {
+		if rule.Name == "" {
+			return fmt.Errorf("redaction entry %d needs a name", i+1)
+		}
+		if _, ok := redactionNames[rule.Name]; ok {
+			return fmt.Errorf("Duplicate name in redaction entries: %s", rule.Name)
+		}
+		redactionNames[rule.Name] = struct{}{}
+		if (rule.Pattern == "") == (rule.Detector == "") {
+			return fmt.Errorf("redaction entry %s needs either a pattern or a detector", rule.Name)
+		}
+		if rule.Pattern != "" {
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("Invalid pattern in redaction entry %s: %v", rule.Name, err)
+			}
+		}
+		if _, ok := detectors[rule.Detector]; !ok && rule.Detector != "" {
+			return fmt.Errorf("Unknown detector %q in redaction entry %s, use %s, %s, %s or %s", rule.Detector, rule.Name, DetectorPAN, DetectorJWT, DetectorAWSKey, DetectorBasicAuthURL)
+		}
+		if _, ok := redactActions[rule.Action]; !ok && rule.Action != "" {
+			return fmt.Errorf("Unknown action %q in redaction entry %s, use %s, %s or %s", rule.Action, rule.Name, RedactMask, RedactHash, RedactDrop)
+		}
+		// the hash of a card number is reversed by hashing all of them
+		if rule.Action == RedactHash && rule.Detector == DetectorPAN && rule.HashKey == "" {
+			return fmt.Errorf("redaction entry %s hashes card numbers, it needs a hash_key", rule.Name)
+		}
+		for _, field := range rule.Fields {
+			if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+				return fmt.Errorf("Invalid field %q in redaction entry %s", field, rule.Name)
+			}
+			if field != "message" && reservedTarget(field) {
+				return fmt.Errorf("Field %s of redaction entry %s is one of the keys journalbeat relies on", field, rule.Name)
+			}
+		}
+	}
+
+	if err := ValidateFieldMapping(config.FieldMapping); err != nil {
+		return err
+	}
+
+	for field, typ := range config.FieldTypes {
+		if _, ok := fieldTypes[typ]; !ok {
+			return fmt.Errorf("Unknown type %q for field %s in field_types", typ, field)
+		}
+		if field == "MESSAGE" && typ != FieldTypeString {
+			return fmt.Errorf("The type of MESSAGE can not be changed in field_types")
+		}
+	}
+
+	for i, rule := range config.ClassificationRules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("Invalid classification_rules entry %d: %v", i+1, err)
+		}
+	}
+
+	if config.Filter != "" {
+		if _, err := filter.Parse(config.Filter); err != nil {
+			return fmt.Errorf("Invalid filter %q: %v", config.Filter, err)
+		}
 	}
 	return nil
 }
+
+// templateField matches the %{FIELD} references of the templates
+var templateField = regexp.MustCompile(`%\{([^}]*)\}`)
+
+// ParseSeverity parses a syslog severity given by name, e.g. "warning", or
+// code, e.g. "4". "error" and "warn" are accepted as well.
+func ParseSeverity(value string) (int, error) {
+	switch v := strings.ToLower(value); v {
+	case "error":
+		return 3, nil
+	case "warn":
+		return 4, nil
+	default:
+		for code, name := range Severities {
+			if v == name || v == strconv.Itoa(code) {
+				return code, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q", value)
+}
+
+// ValidateFieldMapping checks a field_mapping setting, which can also be
+// given in the output config
+func ValidateFieldMapping(mapping string) error {
+	if _, ok := fieldMappings[mapping]; !ok {
+		return fmt.Errorf("Unknown field_mapping %q, use %s or %s", mapping, FieldMappingJournal, FieldMappingECS)
+	}
+	return nil
+}
+
+// ExpandTemplate replaces the %{FIELD} references of a classification rule
+// template with the values of fields, missing fields expand to ""
+func ExpandTemplate(tmpl string, fields map[string]string) string {
+	if !strings.Contains(tmpl, "%{") {
+		return tmpl
+	}
+	return templateField.ReplaceAllStringFunc(tmpl, func(ref string) string {
+		return fields[ref[2:len(ref)-1]]
+	})
+}
+
+func (rule ClassificationRule) validate() error {
+	if rule.Type == "" {
+		return fmt.Errorf("type is missing")
+	}
+	for field, pattern := range rule.Match {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q for %s: %v", pattern, field, err)
+		}
+	}
+	for _, tmpl := range append([]string{rule.Type, rule.BufferingKey}, rule.Tags...) {
+		for _, m := range templateField.FindAllStringSubmatch(tmpl, -1) {
+			if m[1] == "" {
+				return fmt.Errorf("empty field reference in %q", tmpl)
+			}
+		}
+		if strings.Contains(templateField.ReplaceAllString(tmpl, ""), "%{") {
+			return fmt.Errorf("unterminated field reference in %q", tmpl)
+		}
+	}
+	return nil
+}
+
+func (s FieldSelection) validate(name string) error {
+	for _, pattern := range append(append([]string{}, s.IncludeFields...), s.ExcludeFields...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid field pattern %q in %s: %v", pattern, name, err)
+		}
+	}
+	return nil
+}
+
+// ParseTime parses the since and until settings. They are either absolute
+// RFC3339 timestamps or durations relative to now, e.g. "-2h" or "-90m".
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if value == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,467 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *Config) {},
+			valid:  true,
+		},
+		{
+			name: "since and until",
+			modify: func(c *Config) {
+				c.SeekPosition = SeekPositionSince
+				c.Since = "-2h"
+				c.Until = "2099-01-01T00:00:00Z"
+			},
+			valid: true,
+		},
+		{
+			name: "seek to since without since",
+			modify: func(c *Config) {
+				c.SeekPosition = SeekPositionSince
+			},
+		},
+		{
+			name: "cursor fallback to since without since",
+			modify: func(c *Config) {
+				c.CursorSeekFallback = SeekPositionSince
+			},
+		},
+		{
+			name: "since after until",
+			modify: func(c *Config) {
+				c.Since = "2017-01-02T00:00:00Z"
+				c.Until = "2017-01-01T00:00:00Z"
+			},
+		},
+		{
+			name: "since equal to until",
+			modify: func(c *Config) {
+				c.Since = "now"
+				c.Until = "now"
+			},
+		},
+		{
+			name: "invalid since",
+			modify: func(c *Config) {
+				c.Since = "yesterday"
+			},
+		},
+		{
+			name: "invalid until",
+			modify: func(c *Config) {
+				c.Until = "-2 hours"
+			},
+		},
+		{
+			name: "follow buffer size and wait timeout",
+			modify: func(c *Config) {
+				c.FollowBufferSize = 1
+				c.WaitTimeout = 10 * time.Millisecond
+			},
+			valid: true,
+		},
+		{
+			name: "no follow buffer",
+			modify: func(c *Config) {
+				c.FollowBufferSize = 0
+			},
+		},
+		{
+			name: "negative wait timeout",
+			modify: func(c *Config) {
+				c.WaitTimeout = -time.Second
+			},
+		},
+		{
+			name: "no wait timeout",
+			modify: func(c *Config) {
+				c.WaitTimeout = 0
+			},
+		},
+		{
+			name: "field selection",
+			modify: func(c *Config) {
+				c.IncludeFields = []string{"REQUEST_ID", "UPSTREAM_*"}
+				c.ExcludeFields = []string{"_CAP_*"}
+				c.FieldOverrides = map[string]FieldSelection{"container": {AllFields: true, ExcludeFields: []string{"_*"}}}
+			},
+			valid: true,
+		},
+		{
+			name: "invalid include pattern",
+			modify: func(c *Config) {
+				c.IncludeFields = []string{"UPSTREAM_[A-"}
+			},
+		},
+		{
+			name: "invalid exclude pattern of an override",
+			modify: func(c *Config) {
+				c.FieldOverrides = map[string]FieldSelection{"nginx": {ExcludeFields: []string{"["}}}
+			},
+		},
+		{
+			name: "classification rules",
+			modify: func(c *Config) {
+				c.ClassificationRules = []ClassificationRule{{
+					Match:        map[string]string{"_HOSTNAME": "nspawn-*"},
+					Type:         "machine-%{_HOSTNAME}",
+					BufferingKey: "%{_HOSTNAME}/%{_PID}",
+					Tags:         []string{"nspawn"},
+				}}
+			},
+			valid: true,
+		},
+		{
+			name: "classification rule without type",
+			modify: func(c *Config) {
+				c.ClassificationRules = []ClassificationRule{{Match: map[string]string{"_COMM": "conmon"}, BufferingKey: "%{CONTAINER_ID}"}}
+			},
+		},
+		{
+			name: "classification rule with an invalid pattern",
+			modify: func(c *Config) {
+				c.ClassificationRules = []ClassificationRule{{Match: map[string]string{"_HOSTNAME": "nspawn-[a"}, Type: "machine"}}
+			},
+		},
+		{
+			name: "classification rule with an empty reference",
+			modify: func(c *Config) {
+				c.ClassificationRules = []ClassificationRule{{Type: "machine-%{}"}}
+			},
+		},
+		{
+			name: "classification rule with an unterminated reference",
+			modify: func(c *Config) {
+				c.ClassificationRules = []ClassificationRule{{Type: "machine", Tags: []string{"%{_HOSTNAME"}}}
+			},
+		},
+		{
+			name: "realtime timestamp source",
+			modify: func(c *Config) {
+				c.TimestampSource = TimestampRealtime
+			},
+			valid: true,
+		},
+		{
+			name: "publish timestamp source",
+			modify: func(c *Config) {
+				c.TimestampSource = TimestampPublish
+			},
+			valid: true,
+		},
+		{
+			name: "unknown timestamp source",
+			modify: func(c *Config) {
+				c.TimestampSource = "__REALTIME_TIMESTAMP"
+			},
+		},
+		{
+			name: "logfmt target",
+			modify: func(c *Config) {
+				c.Logfmt = []LogfmtParsing{{Units: []string{"api.service"}, Target: "api.fields"}}
+			},
+			valid: true,
+		},
+		{
+			name: "logfmt target message",
+			modify: func(c *Config) {
+				c.Logfmt = []LogfmtParsing{{Units: []string{"api.service"}, Target: "message"}}
+			},
+		},
+		{
+			name: "logfmt target below type",
+			modify: func(c *Config) {
+				c.Logfmt = []LogfmtParsing{{Units: []string{"api.service"}, Target: "type.logfmt"}}
+			},
+		},
+		{
+			name: "logfmt types",
+			modify: func(c *Config) {
+				c.Logfmt = []LogfmtParsing{{Units: []string{"api.service"}, Types: map[string]string{"status": FieldTypeInteger}}}
+			},
+			valid: true,
+		},
+		{
+			name: "logfmt unknown type",
+			modify: func(c *Config) {
+				c.Logfmt = []LogfmtParsing{{Units: []string{"api.service"}, Types: map[string]string{"status": "number"}}}
+			},
+		},
+		{
+			name: "json target cursor",
+			modify: func(c *Config) {
+				c.JSONDecoding = []JSONDecoding{{Target: "cursor"}}
+			},
+		},
+		{
+			name: "json target",
+			modify: func(c *Config) {
+				c.JSONDecoding = []JSONDecoding{{Target: "json"}}
+			},
+			valid: true,
+		},
+		{
+			name: "json target below a reserved key",
+			modify: func(c *Config) {
+				c.JSONDecoding = []JSONDecoding{{Target: "message.json"}}
+			},
+		},
+		{
+			name: "json target with a leading dot",
+			modify: func(c *Config) {
+				c.JSONDecoding = []JSONDecoding{{Target: ".json"}}
+			},
+		},
+		{
+			name: "json units and types",
+			modify: func(c *Config) {
+				c.JSONDecoding = []JSONDecoding{{Units: []string{"api*.service"}, Types: []string{"app"}, OverwriteKeys: true, KeepMessage: true}}
+			},
+			valid: true,
+		},
+		{
+			name: "json invalid unit pattern",
+			modify: func(c *Config) {
+				c.JSONDecoding = []JSONDecoding{{Units: []string{"api[.service"}}}
+			},
+		},
+		{
+			name: "grok target @timestamp",
+			modify: func(c *Config) {
+				c.Grok = []GrokExtraction{{Name: "api", Units: []string{"api.service"}, Patterns: []string{"%{INT:status}"}, Target: "@timestamp"}}
+			},
+		},
+		{
+			name: "grok capture of message",
+			modify: func(c *Config) {
+				c.Grok = []GrokExtraction{{Name: "api", Units: []string{"api.service"}, Patterns: []string{"%{INT:status} %{GREEDYDATA:message}"}}}
+			},
+		},
+		{
+			name: "grok capture below logBufferingType",
+			modify: func(c *Config) {
+				c.Grok = []GrokExtraction{{Name: "api", Units: []string{"api.service"}, Patterns: []string{"%{INT:logBufferingType.status}"}}}
+			},
+		},
+		{
+			name: "grok capture of message under a target",
+			modify: func(c *Config) {
+				c.Grok = []GrokExtraction{{Name: "api", Units: []string{"api.service"}, Patterns: []string{"%{INT:status} %{GREEDYDATA:message}"}, Target: "api"}}
+			},
+			valid: true,
+		},
+		{
+			name: "redaction fields",
+			modify: func(c *Config) {
+				c.Redaction = []RedactionRule{{Name: "cards", Detector: DetectorPAN, Fields: []string{"message", "json", "audit.*.args", "*"}}}
+			},
+			valid: true,
+		},
+		{
+			name: "hashed card numbers",
+			modify: func(c *Config) {
+				c.Redaction = []RedactionRule{{Name: "cards", Detector: DetectorPAN, Action: RedactHash, HashKey: "secret"}}
+			},
+			valid: true,
+		},
+		{
+			name: "hashed card numbers without hash key",
+			modify: func(c *Config) {
+				c.Redaction = []RedactionRule{{Name: "cards", Detector: DetectorPAN, Action: RedactHash}}
+			},
+		},
+		{
+			name: "hashed pattern without hash key",
+			modify: func(c *Config) {
+				c.Redaction = []RedactionRule{{Name: "sessions", Pattern: "session=(?P<secret>[0-9a-f]{32})", Action: RedactHash}}
+			},
+			valid: true,
+		},
+		{
+			name: "redaction of the cursor",
+			modify: func(c *Config) {
+				c.Redaction = []RedactionRule{{Name: "cards", Detector: DetectorPAN, Fields: []string{"cursor"}}}
+			},
+		},
+		{
+			name: "redaction field with an empty key",
+			modify: func(c *Config) {
+				c.Redaction = []RedactionRule{{Name: "cards", Detector: DetectorPAN, Fields: []string{"json..card"}}}
+			},
+		},
+		{
+			name: "filter",
+			modify: func(c *Config) {
+				c.Filter = `(_SYSTEMD_UNIT=sshd.service OR SYSLOG_IDENTIFIER=sudo) AND PRIORITY<=4 AND NOT MESSAGE="session closed"`
+			},
+			valid: true,
+		},
+		{
+			name: "filter without closing parenthesis",
+			modify: func(c *Config) {
+				c.Filter = "(_SYSTEMD_UNIT=sshd.service OR SYSLOG_IDENTIFIER=sudo"
+			},
+		},
+		{
+			name: "filter with a dangling operator",
+			modify: func(c *Config) {
+				c.Filter = "_SYSTEMD_UNIT=sshd.service AND"
+			},
+		},
+		{
+			name: "filter range of a name",
+			modify: func(c *Config) {
+				c.Filter = "PRIORITY<=warning"
+			},
+		},
+		{
+			name: "filter lower case field",
+			modify: func(c *Config) {
+				c.Filter = "_systemd_unit=sshd.service"
+			},
+		},
+		{
+			name: "filter unterminated quote",
+			modify: func(c *Config) {
+				c.Filter = `MESSAGE="session closed`
+			},
+		},
+		{
+			name: "kubelet ca file and insecure",
+			modify: func(c *Config) {
+				c.KubernetesMetadata = true
+				c.KubeletPodsURL = "https://127.0.0.1:10250/pods"
+				c.KubeletCAFile = "/etc/kubernetes/pki/ca.crt"
+				c.KubeletInsecure = true
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig
+			tt.modify(&c)
+			err := c.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Validate() = %v, want no error", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Validate() accepted an invalid config")
+			}
+		})
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		value string
+		code  int
+		valid bool
+	}{
+		{"emerg", 0, true},
+		{"alert", 1, true},
+		{"crit", 2, true},
+		{"err", 3, true},
+		{"error", 3, true},
+		{"warning", 4, true},
+		{"warn", 4, true},
+		{"notice", 5, true},
+		{"info", 6, true},
+		{"debug", 7, true},
+		{"DEBUG", 7, true},
+		{"0", 0, true},
+		{"7", 7, true},
+		{"8", 0, false},
+		{"-1", 0, false},
+		{"fatal", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			code, err := ParseSeverity(tt.value)
+			if tt.valid && (err != nil || code != tt.code) {
+				t.Errorf("ParseSeverity() = %d, %v, want %d", code, err, tt.code)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("ParseSeverity() = %d, want an error", code)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+		valid bool
+	}{
+		{"now", now, true},
+		{"-2h", now.Add(-2 * time.Hour), true},
+		{"-90m", now.Add(-90 * time.Minute), true},
+		{"+1h30m", now.Add(90 * time.Minute), true},
+		{"2017-01-01T00:00:00Z", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2017-01-01T02:00:00+02:00", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2017-01-01", time.Time{}, false},
+		{"-2d", time.Time{}, false},
+		{"2h", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTime(tt.value, now)
+			if tt.valid && (err != nil || !got.Equal(tt.want)) {
+				t.Errorf("ParseTime() = %v, %v, want %v", got, err, tt.want)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("ParseTime() = %v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	fields := map[string]string{"_HOSTNAME": "nspawn-db", "_PID": "7"}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"container", "container"},
+		{"%{_HOSTNAME}", "nspawn-db"},
+		{"machine-%{_HOSTNAME}/%{_PID}", "machine-nspawn-db/7"},
+		{"%{_UID}", ""},
+		{"user-%{_UID}", "user-"},
+		{"100%", "100%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			if got := ExpandTemplate(tt.tmpl, fields); got != tt.want {
+				t.Errorf("ExpandTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"sort"
+	"strconv"
+)
+
+// maxTerms bounds the size of a disjunction handed over to journald. Larger
+// parts of an expression are left to the in-process evaluation.
+const maxTerms = 64
+
+// enumerable lists the fields whose domain is small enough to turn numeric
+// comparisons into a disjunction of matches
+var enumerable = map[string][]int{
+	"PRIORITY": {0, 1, 2, 3, 4, 5, 6, 7},
+}
+
+// Matcher is the match API of sd-journal, implemented by journal.Matcher
+type Matcher interface {
+	AddMatch(match string) error
+	AddDisjunction() error
+	AddConjunction() error
+}
+
+// term is a conjunction of matches on distinct fields
+type term map[string]string
+
+// Apply adds matches to m that select a superset of the entries matched by
+// n: equality matches, AND, OR and comparisons on PRIORITY are translated,
+// negations and other comparisons are left out. The entries returned by the
+// journal still have to be checked with n.Match. Apply does not flush the
+// existing matches of m, it adds the expression as a new conjunction.
+func Apply(n Node, m Matcher) error {
+	first := true
+	for _, c := range conjuncts(n, false, nil) {
+		terms, ok := disjunction(c.node, c.negated)
+		if !ok || len(terms) == 0 {
+			continue
+		}
+		if !first {
+			if err := m.AddConjunction(); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		for i, t := range terms {
+			if i > 0 {
+				if err := m.AddDisjunction(); err != nil {
+					return err
+				}
+			}
+			fields := make([]string, 0, len(t))
+			for f := range t {
+				fields = append(fields, f)
+			}
+			sort.Strings(fields)
+			for _, f := range fields {
+				if err := m.AddMatch(f + "=" + t[f]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type conjunct struct {
+	node    Node
+	negated bool
+}
+
+// conjuncts splits n into the parts that are AND'ed at the top level,
+// pushing negations down with De Morgan's laws
+func conjuncts(n Node, negated bool, acc []conjunct) []conjunct {
+	switch n := n.(type) {
+	case And:
+		if !negated {
+			for _, c := range n {
+				acc = conjuncts(c, false, acc)
+			}
+			return acc
+		}
+	case Or:
+		if negated {
+			for _, c := range n {
+				acc = conjuncts(c, true, acc)
+			}
+			return acc
+		}
+	case Not:
+		return conjuncts(n.Node, !negated, acc)
+	}
+	return append(acc, conjunct{n, negated})
+}
+
+// disjunction returns the terms of a disjunction that is true for every entry
+// n is true for. ok is false if there is no such disjunction, i.e. the part
+// can not be restricted by journald.
+func disjunction(n Node, negated bool) (terms []term, ok bool) {
+	switch n := n.(type) {
+	case Not:
+		return disjunction(n.Node, !negated)
+	case And:
+		if negated {
+			return or(Or(n), true)
+		}
+		return and(n, false)
+	case Or:
+		if negated {
+			return and(And(n), true)
+		}
+		return or(n, false)
+	case Comparison:
+		return comparison(n, negated)
+	}
+	return nil, false
+}
+
+func or(nodes []Node, negated bool) ([]term, bool) {
+	var terms []term
+	for _, c := range nodes {
+		t, ok := disjunction(c, negated)
+		if !ok {
+			return nil, false
+		}
+		terms = append(terms, t...)
+	}
+	if len(terms) > maxTerms {
+		return nil, false
+	}
+	return terms, true
+}
+
+func and(nodes []Node, negated bool) ([]term, bool) {
+	var terms []term
+	restricted := false
+	for _, c := range nodes {
+		t, ok := disjunction(c, negated)
+		if !ok {
+			continue
+		}
+		if !restricted {
+			terms, restricted = t, true
+			continue
+		}
+
+		var product []term
+		for _, a := range terms {
+			for _, b := range t {
+				if merged, ok := merge(a, b); ok {
+					product = append(product, merged)
+				}
+			}
+		}
+		if len(product) > maxTerms {
+			return nil, false
+		}
+		terms = product
+	}
+	return terms, restricted
+}
+
+// merge returns the conjunction of two terms, ok is false if they contradict
+func merge(a, b term) (term, bool) {
+	t := make(term, len(a)+len(b))
+	for f, v := range a {
+		t[f] = v
+	}
+	for f, v := range b {
+		if w, found := t[f]; found && w != v {
+			return nil, false
+		}
+		t[f] = v
+	}
+	return t, true
+}
+
+func comparison(c Comparison, negated bool) ([]term, bool) {
+	op := c.Op
+	if negated {
+		// only the negation of != can be expressed, NOT F<x also matches
+		// entries without F
+		if op != OpNotEqual {
+			return nil, false
+		}
+		op = OpEqual
+	}
+
+	if op == OpEqual {
+		return []term{{c.Field: c.Value}}, true
+	}
+	values, found := enumerable[c.Field]
+	if !found || op == OpNotEqual {
+		return nil, false
+	}
+
+	var terms []term
+	for _, v := range values {
+		if (Comparison{Field: c.Field, Op: op, Value: c.Value}).Match(map[string]string{c.Field: strconv.Itoa(v)}) {
+			terms = append(terms, term{c.Field: strconv.Itoa(v)})
+		}
+	}
+	return terms, true
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filter implements the journal filter expressions of the config, e.g.
+//
+//	(_SYSTEMD_UNIT=sshd.service OR SYSLOG_IDENTIFIER=sudo) AND PRIORITY<=4 AND NOT _COMM=cron
+//
+// Expressions are evaluated in process with Match. The part of an
+// expression that journald can evaluate itself is handed over with Apply.
+package filter
+
+import (
+	"strconv"
+)
+
+// Comparison operators
+const (
+	OpEqual        = "="
+	OpNotEqual     = "!="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+)
+
+// Node is a node of a parsed filter expression
+type Node interface {
+	// Match evaluates the node against the fields of a journal entry
+	Match(fields map[string]string) bool
+	String() string
+}
+
+// And is true if all of its children are true
+type And []Node
+
+// Or is true if any of its children is true
+type Or []Node
+
+// Not negates its child
+type Not struct {
+	Node Node
+}
+
+// Comparison compares a journal field against a value. Ordering operators
+// compare numerically, entries where the field is missing or not a number
+// do not match.
+type Comparison struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// Match implements Node
+func (a And) Match(fields map[string]string) bool {
+	for _, n := range a {
+		if !n.Match(fields) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a And) String() string {
+	return join(a, " AND ")
+}
+
+// Match implements Node
+func (o Or) Match(fields map[string]string) bool {
+	for _, n := range o {
+		if n.Match(fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Or) String() string {
+	return join(o, " OR ")
+}
+
+// Match implements Node
+func (n Not) Match(fields map[string]string) bool {
+	return !n.Node.Match(fields)
+}
+
+func (n Not) String() string {
+	return "NOT " + n.Node.String()
+}
+
+// Match implements Node
+func (c Comparison) Match(fields map[string]string) bool {
+	v, ok := fields[c.Field]
+	switch c.Op {
+	case OpEqual:
+		return ok && v == c.Value
+	case OpNotEqual:
+		return !ok || v != c.Value
+	}
+
+	if !ok {
+		return false
+	}
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+	y, _ := strconv.ParseFloat(c.Value, 64)
+	switch c.Op {
+	case OpLess:
+		return x < y
+	case OpLessEqual:
+		return x <= y
+	case OpGreater:
+		return x > y
+	case OpGreaterEqual:
+		return x >= y
+	}
+	return false
+}
+
+func (c Comparison) String() string {
+	return c.Field + c.Op + strconv.Quote(c.Value)
+}
+
+func join(nodes []Node, sep string) string {
+	s := "("
+	for i, n := range nodes {
+		if i > 0 {
+			s += sep
+		}
+		s += n.String()
+	}
+	return s + ")"
+}
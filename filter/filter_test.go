@@ -0,0 +1,279 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recorder is a Matcher that records the calls, AddDisjunction as "OR" and
+// AddConjunction as "AND"
+type recorder struct {
+	calls []string
+	err   error
+}
+
+func (r *recorder) AddMatch(match string) error {
+	r.calls = append(r.calls, match)
+	return r.err
+}
+
+func (r *recorder) AddDisjunction() error {
+	r.calls = append(r.calls, "OR")
+	return r.err
+}
+
+func (r *recorder) AddConjunction() error {
+	r.calls = append(r.calls, "AND")
+	return r.err
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		// tree is the String of the parsed expression
+		tree    string
+		calls   []string
+		match   []map[string]string
+		nomatch []map[string]string
+	}{
+		{
+			name:    "AND binds tighter than OR",
+			expr:    "A=1 OR B=2 AND C=3",
+			tree:    `(A="1" OR (B="2" AND C="3"))`,
+			calls:   []string{"A=1", "OR", "B=2", "C=3"},
+			match:   []map[string]string{{"A": "1"}, {"B": "2", "C": "3"}},
+			nomatch: []map[string]string{{"B": "2"}, {"C": "3"}, {}},
+		},
+		{
+			name:    "parentheses",
+			expr:    "(A=1 OR B=2) AND C=3",
+			tree:    `((A="1" OR B="2") AND C="3")`,
+			calls:   []string{"A=1", "OR", "B=2", "AND", "C=3"},
+			match:   []map[string]string{{"A": "1", "C": "3"}, {"B": "2", "C": "3"}},
+			nomatch: []map[string]string{{"A": "1"}, {"C": "3"}},
+		},
+		{
+			name:  "keywords are case insensitive",
+			expr:  "A=1 and (B=2 or C=3)",
+			tree:  `(A="1" AND (B="2" OR C="3"))`,
+			calls: []string{"A=1", "AND", "B=2", "OR", "C=3"},
+		},
+		{
+			name:    "quoted values",
+			expr:    `MESSAGE="hello (world)" AND SYSLOG_IDENTIFIER="say \"hi\""`,
+			tree:    `(MESSAGE="hello (world)" AND SYSLOG_IDENTIFIER="say \"hi\"")`,
+			calls:   []string{"MESSAGE=hello (world)", "AND", `SYSLOG_IDENTIFIER=say "hi"`},
+			match:   []map[string]string{{"MESSAGE": "hello (world)", "SYSLOG_IDENTIFIER": `say "hi"`}},
+			nomatch: []map[string]string{{"MESSAGE": "hello", "SYSLOG_IDENTIFIER": `say "hi"`}},
+		},
+		{
+			name:    "bare values end at parentheses",
+			expr:    "(_SYSTEMD_UNIT=sshd.service)",
+			calls:   []string{"_SYSTEMD_UNIT=sshd.service"},
+			match:   []map[string]string{{"_SYSTEMD_UNIT": "sshd.service"}},
+			nomatch: []map[string]string{{"_SYSTEMD_UNIT": "sshd"}},
+		},
+		{
+			name:    "NOT is left to the in-process evaluation",
+			expr:    "_SYSTEMD_UNIT=cron.service AND NOT _COMM=run-parts",
+			tree:    `(_SYSTEMD_UNIT="cron.service" AND NOT _COMM="run-parts")`,
+			calls:   []string{"_SYSTEMD_UNIT=cron.service"},
+			match:   []map[string]string{{"_SYSTEMD_UNIT": "cron.service", "_COMM": "cron"}, {"_SYSTEMD_UNIT": "cron.service"}},
+			nomatch: []map[string]string{{"_SYSTEMD_UNIT": "cron.service", "_COMM": "run-parts"}},
+		},
+		{
+			name:    "NOT of !=",
+			expr:    "NOT _COMM!=sshd",
+			calls:   []string{"_COMM=sshd"},
+			match:   []map[string]string{{"_COMM": "sshd"}},
+			nomatch: []map[string]string{{"_COMM": "cron"}, {}},
+		},
+		{
+			name:    "double NOT",
+			expr:    "NOT NOT A=1",
+			tree:    `NOT NOT A="1"`,
+			calls:   []string{"A=1"},
+			match:   []map[string]string{{"A": "1"}},
+			nomatch: []map[string]string{{"A": "2"}},
+		},
+		{
+			name:    "!= matches missing fields",
+			expr:    "A!=1",
+			match:   []map[string]string{{"A": "2"}, {}},
+			nomatch: []map[string]string{{"A": "1"}},
+		},
+		{
+			name:    "PRIORITY<=",
+			expr:    "PRIORITY<=3",
+			calls:   []string{"PRIORITY=0", "OR", "PRIORITY=1", "OR", "PRIORITY=2", "OR", "PRIORITY=3"},
+			match:   []map[string]string{{"PRIORITY": "0"}, {"PRIORITY": "3"}},
+			nomatch: []map[string]string{{"PRIORITY": "4"}, {"PRIORITY": "err"}, {}},
+		},
+		{
+			name:    "PRIORITY>=",
+			expr:    "PRIORITY>=6",
+			calls:   []string{"PRIORITY=6", "OR", "PRIORITY=7"},
+			match:   []map[string]string{{"PRIORITY": "6"}, {"PRIORITY": "7"}},
+			nomatch: []map[string]string{{"PRIORITY": "5"}, {}},
+		},
+		{
+			name:  "PRIORITY< and >",
+			expr:  "PRIORITY<2 OR PRIORITY>6",
+			calls: []string{"PRIORITY=0", "OR", "PRIORITY=1", "OR", "PRIORITY=7"},
+		},
+		{
+			name:    "PRIORITY range",
+			expr:    "PRIORITY>=3 AND PRIORITY<=4",
+			calls:   []string{"PRIORITY=3", "OR", "PRIORITY=4", "OR", "PRIORITY=5", "OR", "PRIORITY=6", "OR", "PRIORITY=7", "AND", "PRIORITY=0", "OR", "PRIORITY=1", "OR", "PRIORITY=2", "OR", "PRIORITY=3", "OR", "PRIORITY=4"},
+			match:   []map[string]string{{"PRIORITY": "3"}, {"PRIORITY": "4"}},
+			nomatch: []map[string]string{{"PRIORITY": "2"}, {"PRIORITY": "5"}},
+		},
+		{
+			name:    "ranges of other fields are evaluated in process",
+			expr:    "_UID<1000 AND _SYSTEMD_UNIT=sshd.service",
+			calls:   []string{"_SYSTEMD_UNIT=sshd.service"},
+			match:   []map[string]string{{"_UID": "0", "_SYSTEMD_UNIT": "sshd.service"}, {"_UID": "999.5", "_SYSTEMD_UNIT": "sshd.service"}},
+			nomatch: []map[string]string{{"_UID": "1000", "_SYSTEMD_UNIT": "sshd.service"}, {"_SYSTEMD_UNIT": "sshd.service"}},
+		},
+		{
+			name:    "De Morgan over OR",
+			expr:    "NOT (A!=1 OR B!=2)",
+			calls:   []string{"A=1", "AND", "B=2"},
+			match:   []map[string]string{{"A": "1", "B": "2"}},
+			nomatch: []map[string]string{{"A": "1"}, {"A": "1", "B": "3"}},
+		},
+		{
+			name:    "De Morgan over AND",
+			expr:    "NOT (A!=1 AND B!=2)",
+			calls:   []string{"A=1", "OR", "B=2"},
+			match:   []map[string]string{{"A": "1"}, {"B": "2"}},
+			nomatch: []map[string]string{{"A": "2", "B": "1"}, {}},
+		},
+		{
+			name:    "De Morgan down nested negations",
+			expr:    "NOT (NOT (A=1 OR B=2) OR C!=3)",
+			calls:   []string{"A=1", "OR", "B=2", "AND", "C=3"},
+			match:   []map[string]string{{"A": "1", "C": "3"}},
+			nomatch: []map[string]string{{"A": "1"}, {"C": "3"}},
+		},
+		{
+			name:    "a negation in a disjunction can not be restricted",
+			expr:    "A=1 OR NOT B=2",
+			match:   []map[string]string{{"A": "1", "B": "2"}, {"B": "3"}, {}},
+			nomatch: []map[string]string{{"B": "2"}},
+		},
+		{
+			name:    "unrestricted parts of a conjunction are dropped",
+			expr:    "A=1 AND (B=2 OR NOT C=3)",
+			calls:   []string{"A=1"},
+			match:   []map[string]string{{"A": "1", "B": "2", "C": "3"}, {"A": "1"}},
+			nomatch: []map[string]string{{"A": "1", "C": "3"}},
+		},
+		{
+			name:  "conjunctions are distributed over disjunctions",
+			expr:  "X=1 OR (A=1 OR A=2) AND (B=1 OR B=2)",
+			calls: []string{"X=1", "OR", "A=1", "B=1", "OR", "A=1", "B=2", "OR", "A=2", "B=1", "OR", "A=2", "B=2"},
+		},
+		{
+			name:    "contradicting terms are left out",
+			expr:    "X=1 OR A=1 AND A=2",
+			calls:   []string{"X=1"},
+			nomatch: []map[string]string{{"A": "1"}},
+		},
+		{
+			name:  "too many terms are evaluated in process",
+			expr:  "X=1 OR PRIORITY>=0 AND (" + strings.TrimSuffix(strings.Repeat("A=1 OR A=2 OR A=3 OR ", 3), " OR ") + ")",
+			match: []map[string]string{{"X": "1"}, {"PRIORITY": "1", "A": "3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tree != "" && n.String() != tt.tree {
+				t.Errorf("parsed %s, want %s", n, tt.tree)
+			}
+			m := &recorder{}
+			if err := Apply(n, m); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.calls, tt.calls) {
+				t.Errorf("calls = %q, want %q", m.calls, tt.calls)
+			}
+			for _, fields := range tt.match {
+				if !n.Match(fields) {
+					t.Errorf("%v does not match", fields)
+				}
+			}
+			for _, fields := range tt.nomatch {
+				if n.Match(fields) {
+					t.Errorf("%v matches", fields)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	n, err := Parse("A=1 OR B=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("failed")
+	m := &recorder{err: failed}
+	if err := Apply(n, m); err != failed {
+		t.Errorf("err = %v, want %v", err, failed)
+	}
+	if len(m.calls) != 1 {
+		t.Errorf("calls = %q after the error", m.calls)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "unexpected end of expression"},
+		{"A=1 AND", "unexpected end of expression"},
+		{"NOT", "unexpected end of expression"},
+		{"A=", "expected a value at position 3"},
+		{"A", "expected a comparison operator after A at position 2"},
+		{"=1", "expected a field name at position 1"},
+		{"a=1", `invalid field name "a" at position 1`},
+		{"(A=1", "missing closing parenthesis at position 5"},
+		{"A=1)", "unexpected input at position 4"},
+		{"A=1 B=2", "unexpected input at position 5"},
+		{"AND A=1", "unexpected token at position 1"},
+		{"PRIORITY<=err", `<= needs a numeric value, got "err" at position 1`},
+		{`A="open`, "unterminated quoted value at position 3"},
+		{`A="\q"`, `invalid quoted value "\q" at position 3`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("err = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
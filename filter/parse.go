@@ -0,0 +1,264 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// token kinds
+const (
+	tokenEOF = iota
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenComparison
+)
+
+type token struct {
+	kind int
+	pos  int
+	cmp  Comparison
+}
+
+// Parse parses a filter expression. The grammar is
+//
+//	expr       = term { "OR" term }
+//	term       = factor { "AND" factor }
+//	factor     = "NOT" factor | "(" expr ")" | comparison
+//	comparison = FIELD ( "=" | "!=" | "<" | "<=" | ">" | ">=" ) VALUE
+//
+// Keywords are case insensitive. FIELD is a journal field name (upper case
+// letters, digits and underscores). VALUE is either a bare word up to the
+// next whitespace or parenthesis, or a double quoted Go string.
+func Parse(expr string) (Node, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected input at position %d", t.pos+1)
+	}
+	return n, nil
+}
+
+func isFieldChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			return append(tokens, token{kind: tokenEOF, pos: i}), nil
+		}
+
+		start := i
+		switch s[i] {
+		case '(':
+			tokens = append(tokens, token{kind: tokenLParen, pos: i})
+			i++
+			continue
+		case ')':
+			tokens = append(tokens, token{kind: tokenRParen, pos: i})
+			i++
+			continue
+		}
+
+		// a keyword or the field name of a comparison
+		for i < len(s) && !isSpace(s[i]) && !strings.ContainsRune("()=!<>", rune(s[i])) {
+			i++
+		}
+		word := s[start:i]
+		switch strings.ToUpper(word) {
+		case "AND":
+			tokens = append(tokens, token{kind: tokenAnd, pos: start})
+			continue
+		case "OR":
+			tokens = append(tokens, token{kind: tokenOr, pos: start})
+			continue
+		case "NOT":
+			tokens = append(tokens, token{kind: tokenNot, pos: start})
+			continue
+		}
+
+		if word == "" {
+			return nil, fmt.Errorf("expected a field name at position %d", start+1)
+		}
+		for j := 0; j < len(word); j++ {
+			if !isFieldChar(word[j]) {
+				return nil, fmt.Errorf("invalid field name %q at position %d", word, start+1)
+			}
+		}
+
+		op := ""
+		for _, candidate := range []string{OpLessEqual, OpGreaterEqual, OpNotEqual, OpEqual, OpLess, OpGreater} {
+			if strings.HasPrefix(s[i:], candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("expected a comparison operator after %s at position %d", word, i+1)
+		}
+		i += len(op)
+
+		value, n, err := lexValue(s[i:])
+		if err != nil {
+			return nil, fmt.Errorf("%v at position %d", err, i+1)
+		}
+		i += n
+
+		cmp := Comparison{Field: word, Op: op, Value: value}
+		if op != OpEqual && op != OpNotEqual {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("%s needs a numeric value, got %q at position %d", op, value, start+1)
+			}
+		}
+		tokens = append(tokens, token{kind: tokenComparison, pos: start, cmp: cmp})
+	}
+}
+
+// lexValue reads a bare or quoted value and returns it with the number of bytes consumed
+func lexValue(s string) (string, int, error) {
+	if strings.HasPrefix(s, `"`) {
+		escaped := false
+		for i := 1; i < len(s); i++ {
+			switch {
+			case escaped:
+				escaped = false
+			case s[i] == '\\':
+				escaped = true
+			case s[i] == '"':
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid quoted value %s", s[:i+1])
+				}
+				return v, i + 1, nil
+			}
+		}
+		return "", 0, fmt.Errorf("unterminated quoted value")
+	}
+
+	i := 0
+	for i < len(s) && !isSpace(s[i]) && s[i] != '(' && s[i] != ')' {
+		i++
+	}
+	if i == 0 {
+		return "", 0, fmt.Errorf("expected a value")
+	}
+	return s[:i], i, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (Node, error) {
+	n, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := Or{n}
+	for p.peek().kind == tokenOr {
+		p.next()
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, n)
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	n, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	and := And{n}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, n)
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *parser) parseNot() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNot:
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not{n}, nil
+	case tokenLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, fmt.Errorf("missing closing parenthesis at position %d", t.pos+1)
+		}
+		return n, nil
+	case tokenComparison:
+		return t.cmp, nil
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected token at position %d", t.pos+1)
+	}
+}
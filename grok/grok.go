@@ -0,0 +1,185 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grok implements grok-style patterns: regular expressions that
+// reference named patterns of a library with %{NAME}, and capture what they
+// match into a field with %{NAME:field} or %{NAME:field:int}.
+package grok
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDepth bounds the nesting of pattern references, deeper references are
+// most likely a cycle
+const maxDepth = 32
+
+// captureGroup prefixes the names of the regexp groups of %{NAME:field}
+const captureGroup = "grok__"
+
+// Named constants for the conversions of captured values
+const (
+	TypeString = ""
+	TypeInt    = "int"
+	TypeFloat  = "float"
+)
+
+var reference = regexp.MustCompile(`%\{(\w+)(?::([\w@.\[\]-]+))?(?::(\w+))?\}`)
+
+// Library holds the named patterns that patterns can reference
+type Library struct {
+	patterns map[string]string
+}
+
+// NewLibrary returns the built-in patterns extended by custom ones, which
+// replace built-in patterns of the same name
+func NewLibrary(custom map[string]string) *Library {
+	l := &Library{patterns: make(map[string]string, len(builtin)+len(custom))}
+	for name, pattern := range builtin {
+		l.patterns[name] = pattern
+	}
+	for name, pattern := range custom {
+		l.patterns[name] = pattern
+	}
+	return l
+}
+
+// Pattern is a compiled grok pattern
+type Pattern struct {
+	re       *regexp.Regexp
+	captures []capture
+}
+
+type capture struct {
+	group int
+	field string
+	typ   string
+}
+
+// Compile expands the references of a pattern and compiles it. Named groups
+// of plain regexp syntax, (?P<field>...), capture as well.
+func (l *Library) Compile(pattern string) (*Pattern, error) {
+	var fields []capture
+	expanded, err := l.expand(pattern, 0, &fields)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pattern{re: re}
+	for group, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		c := capture{group: group, field: name}
+		if strings.HasPrefix(name, captureGroup) {
+			if n, err := strconv.Atoi(strings.TrimPrefix(name, captureGroup)); err == nil && n < len(fields) {
+				c.field, c.typ = fields[n].field, fields[n].typ
+			}
+		}
+		p.captures = append(p.captures, c)
+	}
+	return p, nil
+}
+
+// expand replaces the references of a pattern by the patterns they name,
+// collecting the captures into fields
+func (l *Library) expand(pattern string, depth int, fields *[]capture) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("pattern references nested deeper than %d", maxDepth)
+	}
+	var err error
+	expanded := reference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := reference.FindStringSubmatch(ref)
+		name, field, typ := m[1], m[2], m[3]
+		sub, ok := l.patterns[name]
+		if !ok {
+			err = fmt.Errorf("unknown pattern %s", name)
+			return ""
+		}
+		if typ != TypeString && typ != TypeInt && typ != TypeFloat {
+			err = fmt.Errorf("unknown type %s in %s, use int or float", typ, ref)
+			return ""
+		}
+		var s string
+		if s, err = l.expand(sub, depth+1, fields); err != nil {
+			return ""
+		}
+		if field == "" {
+			return "(?:" + s + ")"
+		}
+		*fields = append(*fields, capture{field: field, typ: typ})
+		return fmt.Sprintf("(?P<%s%d>%s)", captureGroup, len(*fields)-1, s)
+	})
+	return expanded, err
+}
+
+// Fields returns the fields the pattern captures into
+func (p *Pattern) Fields() []string {
+	fields := make([]string, 0, len(p.captures))
+	for _, c := range p.captures {
+		fields = append(fields, c.field)
+	}
+	return fields
+}
+
+// Match returns the values the pattern captures from s, and whether it
+// matches. Captures of alternatives that did not take part in the match are
+// left out. Values whose conversion fails are left out as well, they are
+// returned in failed by field.
+func (p *Pattern) Match(s string) (values map[string]interface{}, failed map[string]string, ok bool) {
+	loc := p.re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil, nil, false
+	}
+	values = make(map[string]interface{}, len(p.captures))
+	for _, c := range p.captures {
+		start, end := loc[2*c.group], loc[2*c.group+1]
+		if start < 0 {
+			continue
+		}
+		if _, ok := values[c.field]; ok {
+			continue
+		}
+		v, err := convert(s[start:end], c.typ)
+		if err != nil {
+			if failed == nil {
+				failed = map[string]string{}
+			}
+			failed[c.field] = s[start:end]
+			continue
+		}
+		values[c.field] = v
+	}
+	return values, failed, true
+}
+
+func convert(value, typ string) (interface{}, error) {
+	switch typ {
+	case TypeInt:
+		return strconv.ParseInt(value, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(value, 64)
+	}
+	return value, nil
+}
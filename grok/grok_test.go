@@ -0,0 +1,349 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grok
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		custom  map[string]string
+		pattern string
+		input   string
+		ok      bool
+		want    map[string]interface{}
+		failed  map[string]string
+	}{
+		{
+			name:    "reference",
+			pattern: `^%{WORD:verb} %{INT:count:int} %{NUMBER:ratio:float}$`,
+			input:   "took 42 0.5",
+			ok:      true,
+			want:    map[string]interface{}{"verb": "took", "count": int64(42), "ratio": 0.5},
+		},
+		{
+			name:    "references are grouped",
+			custom:  map[string]string{"GREETING": `hello|hi`},
+			pattern: `^%{GREETING}!$`,
+			input:   "hello",
+		},
+		{
+			name:    "reference without a capture",
+			custom:  map[string]string{"GREETING": `hello|hi`},
+			pattern: `^%{GREETING} %{USER:user.name}$`,
+			input:   "hi bob",
+			ok:      true,
+			want:    map[string]interface{}{"user.name": "bob"},
+		},
+		{
+			name:    "nested references",
+			custom:  map[string]string{"PAIR": `%{WORD:key}=%{VALUE}`, "VALUE": `%{INT:value:int}`},
+			pattern: `^%{PAIR:pair}$`,
+			input:   "retries=3",
+			ok:      true,
+			want:    map[string]interface{}{"pair": "retries=3", "key": "retries", "value": int64(3)},
+		},
+		{
+			name:    "custom patterns replace built-in ones",
+			custom:  map[string]string{"INT": `[0-9]{3}`},
+			pattern: `^%{INT:code:int}$`,
+			input:   "42",
+		},
+		{
+			name:    "regexp groups",
+			pattern: `^(?P<level>[A-Z]+): %{GREEDYDATA:text}$`,
+			input:   "WARN: disk full",
+			ok:      true,
+			want:    map[string]interface{}{"level": "WARN", "text": "disk full"},
+		},
+		{
+			name:    "alternatives that did not match are left out",
+			pattern: `^(?:%{INT:number:int}|%{WORD:word})$`,
+			input:   "ten",
+			ok:      true,
+			want:    map[string]interface{}{"word": "ten"},
+		},
+		{
+			name:    "the first capture of a field wins",
+			pattern: `^%{WORD:word} %{WORD:word}$`,
+			input:   "one two",
+			ok:      true,
+			want:    map[string]interface{}{"word": "one"},
+		},
+		{
+			name:    "failed conversions",
+			pattern: `^%{NOTSPACE:status:int} %{NOTSPACE:took:float}$`,
+			input:   "OK 1.5s",
+			ok:      true,
+			want:    map[string]interface{}{},
+			failed:  map[string]string{"status": "OK", "took": "1.5s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewLibrary(tt.custom).Compile(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, failed, ok := p.Match(tt.input)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("values = %#v, want %#v", values, tt.want)
+			}
+			if !reflect.DeepEqual(failed, tt.failed) {
+				t.Errorf("failed = %v, want %v", failed, tt.failed)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		custom  map[string]string
+		pattern string
+		err     string
+	}{
+		{
+			name:    "unknown pattern",
+			pattern: `%{NOPE:x}`,
+			err:     "unknown pattern NOPE",
+		},
+		{
+			name:    "unknown nested pattern",
+			custom:  map[string]string{"OUTER": `a%{INNER}`, "INNER": `%{NOPE}`},
+			pattern: `%{OUTER}`,
+			err:     "unknown pattern NOPE",
+		},
+		{
+			name:    "unknown type",
+			pattern: `%{INT:x:long}`,
+			err:     "unknown type long",
+		},
+		{
+			name:    "recursive pattern",
+			custom:  map[string]string{"LOOP": `x%{LOOP}`},
+			pattern: `%{LOOP}`,
+			err:     "nested deeper than",
+		},
+		{
+			name:    "mutually recursive patterns",
+			custom:  map[string]string{"PING": `%{PONG}`, "PONG": `%{PING}`},
+			pattern: `%{PING:ball}`,
+			err:     "nested deeper than",
+		},
+		{
+			name:    "invalid regexp",
+			pattern: `%{WORD:x}(`,
+			err:     "missing closing )",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLibrary(tt.custom).Compile(tt.pattern)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFields(t *testing.T) {
+	p, err := NewLibrary(nil).Compile(`^%{IP:source.ip} (?P<verb>\w+) %{INT:status:int}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Fields(), []string{"source.ip", "verb", "status"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %q, want %q", got, want)
+	}
+}
+
+func TestBuiltinPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    map[string]interface{}
+	}{
+		{
+			pattern: "SSHD",
+			input:   "Accepted publickey for alice from 10.0.0.1 port 51234 ssh2: RSA SHA256:Hn2Uq0a3ZhA5VwC9pKk4Ue1MpvQGkNwN0N0kQmB7XgA",
+			want: map[string]interface{}{
+				"event.action":  "Accepted",
+				"ssh.method":    "publickey",
+				"user.name":     "alice",
+				"source.ip":     "10.0.0.1",
+				"source.port":   int64(51234),
+				"ssh.signature": "RSA SHA256:Hn2Uq0a3ZhA5VwC9pKk4Ue1MpvQGkNwN0N0kQmB7XgA",
+			},
+		},
+		{
+			pattern: "SSHD",
+			input:   "Failed password for invalid user admin from 2001:db8::7 port 40022 ssh2",
+			want: map[string]interface{}{
+				"event.action": "Failed",
+				"ssh.method":   "password",
+				"user.name":    "admin",
+				"source.ip":    "2001:db8::7",
+				"source.port":  int64(40022),
+			},
+		},
+		{
+			pattern: "SSHD",
+			input:   "Invalid user oracle from 198.51.100.7 port 33810",
+			want: map[string]interface{}{
+				"user.name":   "oracle",
+				"source.ip":   "198.51.100.7",
+				"source.port": int64(33810),
+			},
+		},
+		{
+			pattern: "SSHD",
+			input:   "Disconnected from authenticating user root 192.0.2.1 port 22222 [preauth]",
+			want: map[string]interface{}{
+				"user.name":   "root",
+				"source.ip":   "192.0.2.1",
+				"source.port": int64(22222),
+			},
+		},
+		{
+			pattern: "SSHD",
+			input:   "pam_unix(sshd:session): session opened for user alice(uid=1000) by (uid=0)",
+			want: map[string]interface{}{
+				"ssh.session": "opened",
+				"user.name":   "alice",
+			},
+		},
+		{
+			pattern: "NGINX",
+			input:   `203.0.113.5 - - [02/Jan/2017:03:04:05 +0000] "GET /index.html?page=2 HTTP/1.1" 200 612 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64; rv:50.0) Gecko/20100101 Firefox/50.0"`,
+			want: map[string]interface{}{
+				"source.address":            "203.0.113.5",
+				"user.name":                 "-",
+				"nginx.access.time":         "02/Jan/2017:03:04:05 +0000",
+				"http.request.method":       "GET",
+				"url.original":              "/index.html?page=2",
+				"http.version":              "1.1",
+				"http.response.status_code": int64(200),
+				"http.response.body.bytes":  int64(612),
+				"http.request.referrer":     "https://example.com/",
+				"user_agent.original":       "Mozilla/5.0 (X11; Linux x86_64; rv:50.0) Gecko/20100101 Firefox/50.0",
+			},
+		},
+		{
+			pattern: "NGINX",
+			input:   `2017/01/02 03:04:05 [error] 1234#0: *56 open() "/usr/share/nginx/html/favicon.ico" failed (2: No such file or directory), client: 203.0.113.5, server: localhost, request: "GET /favicon.ico HTTP/1.1", host: "example.com"`,
+			want: map[string]interface{}{
+				"nginx.error.time":          "2017/01/02 03:04:05",
+				"nginx.error.level":         "error",
+				"process.pid":               int64(1234),
+				"process.thread.id":         int64(0),
+				"nginx.error.connection_id": int64(56),
+				"nginx.error.message":       `open() "/usr/share/nginx/html/favicon.ico" failed (2: No such file or directory), client: 203.0.113.5, server: localhost, request: "GET /favicon.ico HTTP/1.1", host: "example.com"`,
+			},
+		},
+		{
+			pattern: "POSTFIX",
+			input:   "4F9D195432C: to=<bob@example.com>, relay=mx.example.com[192.0.2.25]:25, delay=0.42, delays=0.1/0.01/0.2/0.11, dsn=2.0.0, status=sent (250 2.0.0 OK 1483326245 x1si1234567qkb.12 - gsmtp)",
+			want: map[string]interface{}{
+				"postfix.queue_id": "4F9D195432C",
+				"postfix.to":       "bob@example.com",
+				"postfix.relay":    "mx.example.com[192.0.2.25]:25",
+				"postfix.delay":    0.42,
+				"postfix.delays":   "0.1/0.01/0.2/0.11",
+				"postfix.dsn":      "2.0.0",
+				"postfix.status":   "sent",
+				"postfix.response": "(250 2.0.0 OK 1483326245 x1si1234567qkb.12 - gsmtp)",
+			},
+		},
+		{
+			pattern: "POSTFIX",
+			input:   "4F9D195432C: from=<alice@example.com>, size=1234, nrcpt=1 (queue active)",
+			want: map[string]interface{}{
+				"postfix.queue_id": "4F9D195432C",
+				"postfix.from":     "alice@example.com",
+				"postfix.size":     int64(1234),
+				"postfix.nrcpt":    int64(1),
+			},
+		},
+		{
+			pattern: "POSTFIX",
+			input:   "4F9D195432C: client=mail.example.org[198.51.100.20]",
+			want: map[string]interface{}{
+				"postfix.queue_id":        "4F9D195432C",
+				"postfix.client_hostname": "mail.example.org",
+				"source.ip":               "198.51.100.20",
+			},
+		},
+		{
+			pattern: "POSTFIX",
+			input:   "connect from unknown[198.51.100.20]",
+			want: map[string]interface{}{
+				"postfix.event":           "connect",
+				"postfix.client_hostname": "unknown",
+				"source.ip":               "198.51.100.20",
+			},
+		},
+		{
+			pattern: "POSTFIX",
+			input:   "4F9D195432C: removed",
+			want: map[string]interface{}{
+				"postfix.queue_id": "4F9D195432C",
+				"postfix.message":  "removed",
+			},
+		},
+	}
+	library := NewLibrary(nil)
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.input, func(t *testing.T) {
+			p, err := library.Compile("%{" + tt.pattern + "}")
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, failed, ok := p.Match(tt.input)
+			if !ok {
+				t.Fatal("no match")
+			}
+			if len(failed) != 0 {
+				t.Errorf("failed = %v", failed)
+			}
+			if !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("values = %#v, want %#v", values, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinPatternsMiss(t *testing.T) {
+	library := NewLibrary(nil)
+	for pattern, input := range map[string]string{
+		"SSHD":    "Server listening on 0.0.0.0 port 22.",
+		"NGINX":   "nginx: [emerg] unknown directive",
+		"POSTFIX": "warning: hostname example.org does not resolve",
+	} {
+		p, err := library.Compile("%{" + pattern + "}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values, _, ok := p.Match(input); ok {
+			t.Errorf("%s matches %q: %v", pattern, input, values)
+		}
+	}
+}
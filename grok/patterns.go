@@ -0,0 +1,68 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grok
+
+// builtin are the patterns every library starts with: the common building
+// blocks of the Logstash pattern set, and patterns for the messages of sshd,
+// nginx and postfix that capture into Elastic Common Schema fields where
+// there is one
+var builtin = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"INT":          `[+-]?[0-9]+`,
+	"POSINT":       `[1-9][0-9]*`,
+	"NONNEGINT":    `[0-9]+`,
+	"NUMBER":       `[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)`,
+	"BASE16NUM":    `(?:0[xX])?[0-9A-Fa-f]+`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"`,
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"IPV4":         `(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`,
+	"IPV6":         `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:%{IPV4}|[0-9A-Fa-f]{0,4})(?:%[0-9A-Za-z]+)?`,
+	"IP":           `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME":     `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":     `(?:%{IP}|%{HOSTNAME})`,
+	"EMAILADDRESS": `[a-zA-Z0-9!#$%&'*+/=?^_{|}~.-]+@%{HOSTNAME}`,
+	"PATH":         `(?:/[^/\s]*)+`,
+	"URIPATHPARAM": `\S+`,
+	"HTTPDATE":     `[0-9]{2}/\w{3}/[0-9]{4}:[0-9]{2}:[0-9]{2}:[0-9]{2} [+-][0-9]{4}`,
+	"DATESTAMP":    `[0-9]{4}/[0-9]{2}/[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}`,
+
+	// sshd, e.g. "Accepted publickey for alice from 10.0.0.1 port 51234 ssh2"
+	"SSHD_AUTH":         `^%{WORD:event.action} %{WORD:ssh.method} for (?:invalid user )?%{USERNAME:user.name} from %{IP:source.ip} port %{INT:source.port:int} ssh2(?:: %{GREEDYDATA:ssh.signature})?$`,
+	"SSHD_INVALID_USER": `^Invalid user (?:%{USERNAME:user.name} )?from %{IP:source.ip}(?: port %{INT:source.port:int})?$`,
+	"SSHD_DISCONNECTED": `^(?:Disconnected from|Connection closed by|Received disconnect from) (?:(?:invalid |authenticating )?user %{USERNAME:user.name} )?%{IP:source.ip} port %{INT:source.port:int}`,
+	"SSHD_SESSION":      `^pam_unix\(sshd:session\): session %{WORD:ssh.session} for user %{USERNAME:user.name}`,
+	"SSHD":              `%{SSHD_AUTH}|%{SSHD_INVALID_USER}|%{SSHD_DISCONNECTED}|%{SSHD_SESSION}`,
+
+	// nginx access logs in the combined format and error logs
+	"NGINX_ACCESS": `^%{IPORHOST:source.address} - %{DATA:user.name} \[%{HTTPDATE:nginx.access.time}\] "%{WORD:http.request.method} %{DATA:url.original} HTTP/%{NUMBER:http.version}" %{INT:http.response.status_code:int} %{INT:http.response.body.bytes:int} "%{DATA:http.request.referrer}" "%{DATA:user_agent.original}"`,
+	"NGINX_ERROR":  `^%{DATESTAMP:nginx.error.time} \[%{WORD:nginx.error.level}\] %{INT:process.pid:int}#%{INT:process.thread.id:int}: (?:\*%{INT:nginx.error.connection_id:int} )?%{GREEDYDATA:nginx.error.message}`,
+	"NGINX":        `%{NGINX_ACCESS}|%{NGINX_ERROR}`,
+
+	// postfix, e.g. "4F9D195432C: to=<bob@example.com>, relay=..., status=sent"
+	"POSTFIX_QUEUEID":  `[0-9A-F]{6,}|[0-9a-zA-Z]{12,}`,
+	"POSTFIX_RELAY":    `^%{POSTFIX_QUEUEID:postfix.queue_id}: to=<%{DATA:postfix.to}>,(?: orig_to=<%{DATA:postfix.orig_to}>,)? relay=%{DATA:postfix.relay}, delay=%{NUMBER:postfix.delay:float},(?: delays=%{DATA:postfix.delays},)?(?: dsn=%{DATA:postfix.dsn},)? status=%{WORD:postfix.status}(?: %{GREEDYDATA:postfix.response})?$`,
+	"POSTFIX_QMGR":     `^%{POSTFIX_QUEUEID:postfix.queue_id}: from=<%{DATA:postfix.from}>, size=%{INT:postfix.size:int}, nrcpt=%{INT:postfix.nrcpt:int}`,
+	"POSTFIX_CLIENT":   `^%{POSTFIX_QUEUEID:postfix.queue_id}: client=%{DATA:postfix.client_hostname}\[%{IP:source.ip}\]`,
+	"POSTFIX_CONNECT":  `^%{WORD:postfix.event} from %{DATA:postfix.client_hostname}\[%{IP:source.ip}\]`,
+	"POSTFIX_QUEUEMSG": `^%{POSTFIX_QUEUEID:postfix.queue_id}: %{GREEDYDATA:postfix.message}`,
+	"POSTFIX":          `%{POSTFIX_RELAY}|%{POSTFIX_QMGR}|%{POSTFIX_CLIENT}|%{POSTFIX_CONNECT}|%{POSTFIX_QUEUEMSG}`,
+}
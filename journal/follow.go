@@ -15,95 +15,301 @@
 package journal
 
 import (
+	"errors"
 	"io"
+	"syscall"
 	"time"
 
-	"github.com/coreos/go-systemd/sdjournal"
 	"github.com/elastic/beats/libbeat/logp"
 )
 
-// SD_JOURNAL_FIELD_CATALOG_ENTRY stores the name of the JournalEntry field to export Catalog entry to.
-const SD_JOURNAL_FIELD_CATALOG_ENTRY = "CATALOG_ENTRY"
+const (
+	// SD_JOURNAL_FIELD_CATALOG_ENTRY stores the name of the JournalEntry field to export Catalog entry to.
+	SD_JOURNAL_FIELD_CATALOG_ENTRY = "CATALOG_ENTRY"
+
+	// SD_JOURNAL_FIELD_MESSAGE_ID is the field of an entry that links it to the message catalog
+	SD_JOURNAL_FIELD_MESSAGE_ID = "MESSAGE_ID"
+
+	// maxEntryErrors is how many unreadable entries in a row are skipped
+	// before the journal is considered broken and reopened
+	maxEntryErrors = 10
+
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+
+	defaultBufferSize  = 100
+	defaultWaitTimeout = time.Second
+)
 
 // Follow follows the journald and writes the entries to the output channel
 // It is a slightly reworked version of sdjournal.Follow to fit our needs.
-func Follow(journal *sdjournal.Journal, stop <-chan struct{}) <-chan *sdjournal.JournalEntry {
-	readEntry := func(journal *sdjournal.Journal) (*sdjournal.JournalEntry, error) {
-		c, err := journal.Next()
-		if err != nil {
-			return nil, err
-		}
+func Follow(journal Source, stop <-chan struct{}) <-chan *Entry {
+	return (&Follower{}).Follow(journal, stop)
+}
 
-		if c == 0 {
-			return nil, io.EOF
-		}
+// FollowUntil is like Follow, but closes the output channel once it read
+// all the entries written up to until, i.e. when it reads an entry written
+// after until or reaches the end of the journal after until has passed.
+func FollowUntil(journal Source, stop <-chan struct{}, until time.Time) <-chan *Entry {
+	return (&Follower{Until: until}).Follow(journal, stop)
+}
 
-		entry, err := journal.GetEntry()
-		if err != nil {
-			return nil, err
+// Restart describes how a Follower recovered from a read error
+type Restart struct {
+	// Err is the error that made the follower reopen the journal
+	Err error
+	// Attempts is the number of times the journal was opened until it worked
+	Attempts int
+	// Downtime is the time between the error and reading again
+	Downtime time.Duration
+	// Cursor is the cursor of the last entry delivered before the error,
+	// reading resumed behind it. It is empty if no entry was delivered.
+	Cursor string
+	// Seek is how the position was restored: "cursor", "realtime" if the
+	// cursor is gone from the journal or "initial" if no entry was delivered
+	Seek string
+}
+
+// Follower follows a journal like Follow and recovers from read errors by
+// reopening the journal and seeking behind the last delivered entry
+type Follower struct {
+	// Reopen opens the journal again after a read error, it is responsible
+	// for closing the broken one. The source has to be set up like the
+	// original one, i.e. with the same matches and initial position. Without
+	// Reopen a read error ends the follow.
+	Reopen func() (Source, error)
+	// OnRestart is called once the journal was reopened
+	OnRestart func(Restart)
+	// OnGap is called before an entry that follows a gap in the seqnums of
+	// its journal is written to the output channel
+	OnGap func(Gap)
+	// Filtered tells that the journal has matches. Its entries are not
+	// consecutive then, so gaps can not be told from entries that do not
+	// match and OnGap is never called.
+	Filtered bool
+	// MinBackoff and MaxBackoff bound the exponential backoff between the
+	// attempts to reopen the journal (defaults to 1s and 1m)
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// After is the cursor the journal was positioned on, e.g. the saved
+	// cursor of the last published entry. The entry is skipped if it is the
+	// first one read.
+	After string
+	// Until makes the follower close the output channel like FollowUntil,
+	// a zero time follows forever
+	Until time.Time
+	// BufferSize is the capacity of the output channel (defaults to 100)
+	BufferSize int
+	// WaitTimeout bounds how long the follower waits for the journal to
+	// change at its tail before it checks for stop and until again
+	// (defaults to 1s)
+	WaitTimeout time.Duration
+}
+
+// errorClass tells how the follower reacts to a read error
+type errorClass int
+
+const (
+	// errorEntry only affects the entry under the read pointer, it is skipped
+	errorEntry errorClass = iota
+	// errorJournal leaves the journal unusable, it is reopened
+	errorJournal
+)
+
+// classifyError tells entry errors from journal errors. libsystemd reports
+// corrupted objects with EBADMSG and entries that vanished with
+// EADDRNOTAVAIL or ENODATA, Next moves past those entries.
+func classifyError(err error) errorClass {
+	for _, errno := range []syscall.Errno{syscall.EBADMSG, syscall.EADDRNOTAVAIL, syscall.ENODATA} {
+		if errors.Is(err, errno) {
+			return errorEntry
 		}
-		return entry, nil
 	}
+	return errorJournal
+}
+
+// Follow follows the journal and writes the entries to the output channel
+func (f *Follower) Follow(journal Source, stop <-chan struct{}) <-chan *Entry {
+	bufferSize := f.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	out := make(chan *Entry, bufferSize)
+	go f.follow(journal, stop, out)
+	return out
+}
 
-	//make buffer size a parameter in journalbeat.Config...
-	out := make(chan *sdjournal.JournalEntry, 100)
+func readEntry(journal Source) (*Entry, error) {
+	c, err := journal.Next()
+	if err != nil {
+		return nil, err
+	}
 
-	go func(journal *sdjournal.Journal, stop <-chan struct{}, out chan<- *sdjournal.JournalEntry) {
-		defer close(out)
+	if c == 0 {
+		return nil, io.EOF
+	}
+
+	entry, err := journal.GetEntry()
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
 
-	process:
-		for {
-			select {
-			case <-stop:
-				return
-			default:
-				entry, err := readEntry(journal)
-				if err != nil && err != io.EOF {
+func (f *Follower) follow(journal Source, stop <-chan struct{}, out chan<- *Entry) {
+	defer close(out)
+
+	var until uint64
+	if !f.Until.IsZero() {
+		until = uint64(f.Until.UnixNano() / 1000)
+	}
+	waitTimeout := f.WaitTimeout
+	if waitTimeout <= 0 {
+		waitTimeout = defaultWaitTimeout
+	}
+
+	// last is the last entry written to out
+	var last *Entry
+	entryErrors := 0
+	gaps := &gapDetector{}
+
+process:
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+			entry, err := readEntry(journal)
+			if err != nil && err != io.EOF {
+				if classifyError(err) == errorEntry && entryErrors < maxEntryErrors {
+					entryErrors++
+					logp.Warn("Skipping a journal entry that can not be read: %v", err)
+					continue process
+				}
+				if f.Reopen == nil {
 					logp.Err("Received unknown error when reading a new entry: %v", err)
 					return
 				}
-				if entry != nil {
-					if _, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE_ID]; ok {
-						if catalogEntry, err := journal.GetCatalog(); err == nil {
-							entry.Fields[SD_JOURNAL_FIELD_CATALOG_ENTRY] = catalogEntry
+				logp.Err("Reading the journal failed, reopening it: %v", err)
+				if journal = f.reopen(err, last, stop); journal == nil {
+					return
+				}
+				gaps.journalChanged()
+				entryErrors = 0
+				continue process
+			}
+			if entry != nil {
+				entryErrors = 0
+				// seeking to a cursor positions on the entry itself
+				if (last != nil && entry.Cursor == last.Cursor) || (last == nil && f.After != "" && entry.Cursor == f.After) {
+					continue process
+				}
+				if until != 0 && entry.RealtimeTimestamp > until {
+					return
+				}
+				if f.OnGap != nil && !f.Filtered {
+					if gap := gaps.check(entry); gap != nil {
+						if gap.Rotation {
+							logp.Info("Detected a gap of %d journal entries between %s and %s after a file rotation", gap.Lost, gap.After, gap.Before)
+						} else {
+							logp.Warn("Detected a gap of %d journal entries between %s and %s", gap.Lost, gap.After, gap.Before)
 						}
+						f.OnGap(*gap)
 					}
-					out <- entry
-					continue process
 				}
-			}
-			// We're at the tail, so wait for new events or time out.
-			// Holds journal events to process. Tightly bounded for now unless there's a
-			// reason to unblock the journal watch routine more quickly.
-			events := make(chan int, 1)
-			pollDone := make(chan bool, 1)
-			go func() {
-				for {
-					select {
-					case <-pollDone:
-						return
-					default:
-						events <- journal.Wait(time.Duration(100) * time.Millisecond)
+				if _, ok := entry.Fields[SD_JOURNAL_FIELD_MESSAGE_ID]; ok {
+					if catalogEntry, err := journal.GetCatalog(); err == nil {
+						entry.Fields[SD_JOURNAL_FIELD_CATALOG_ENTRY] = catalogEntry
 					}
 				}
-			}()
-
-			select {
-			case <-stop:
-				pollDone <- true
-				return
-			case e := <-events:
-				pollDone <- true
-				switch e {
-				case sdjournal.SD_JOURNAL_NOP, sdjournal.SD_JOURNAL_APPEND, sdjournal.SD_JOURNAL_INVALIDATE:
-					// TODO: need to account for any of these?
-				default:
-					logp.Err("Received unknown event: %d", e)
+				select {
+				case out <- entry:
+				case <-stop:
+					return
 				}
+				last = entry
 				continue process
 			}
 		}
-	}(journal, stop, out)
 
-	return out
+		if until != 0 && uint64(time.Now().UnixNano()/1000) > until {
+			return
+		}
+
+		// We're at the tail, so wait for the journal to change. Every backend
+		// sleeps on its files until then, sd_journal_wait(3) on the inotify
+		// fd of the journal, the timeout only bounds how long stop goes
+		// unnoticed.
+		switch e := journal.Wait(waitTimeout); e {
+		case SD_JOURNAL_NOP, SD_JOURNAL_APPEND:
+		case SD_JOURNAL_INVALIDATE:
+			// files were rotated, vacuumed or added. The read position
+			// survives this, but entries that were not read yet may be
+			// gone with a vacuumed file.
+			logp.Info("Journal files were added or removed")
+			gaps.journalChanged()
+		default:
+			logp.Err("Received unknown event: %d", e)
+		}
+	}
+}
+
+// reopen reopens the journal with exponential backoff until it works or
+// stop is closed, in which case it returns nil
+func (f *Follower) reopen(cause error, last *Entry, stop <-chan struct{}) Source {
+	backoff, maxBackoff := f.MinBackoff, f.MaxBackoff
+	if backoff <= 0 {
+		backoff = defaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		journal, err := f.Reopen()
+		if err == nil {
+			var seek string
+			if seek, err = reseek(journal, last); err == nil {
+				restart := Restart{
+					Err:      cause,
+					Attempts: attempt,
+					Downtime: time.Since(start),
+					Seek:     seek,
+				}
+				if last != nil {
+					restart.Cursor = last.Cursor
+				}
+				logp.Info("Reopened the journal after %d attempts, resuming from %s", attempt, seek)
+				if f.OnRestart != nil {
+					f.OnRestart(restart)
+				}
+				return journal
+			}
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		logp.Err("Reopening the journal failed (attempt %d, next in %v): %v", attempt, backoff, err)
+	}
+}
+
+// reseek positions a reopened journal behind the last delivered entry
+func reseek(journal Source, last *Entry) (string, error) {
+	if last == nil {
+		return "initial", nil
+	}
+	err := journal.SeekCursor(last.Cursor)
+	if err == nil {
+		return "cursor", nil
+	}
+	logp.Warn("Could not seek to the last delivered cursor, seeking to its timestamp: %v", err)
+	return "realtime", journal.SeekRealtimeUsec(last.RealtimeTimestamp + 1)
 }
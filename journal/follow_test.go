@@ -0,0 +1,128 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitSource records the timeouts of the waits on a MemorySource
+type waitSource struct {
+	*MemorySource
+	mu       sync.Mutex
+	timeouts []time.Duration
+}
+
+func (s *waitSource) Wait(timeout time.Duration) int {
+	s.mu.Lock()
+	s.timeouts = append(s.timeouts, timeout)
+	s.mu.Unlock()
+	return s.MemorySource.Wait(timeout)
+}
+
+func (s *waitSource) waits() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Duration{}, s.timeouts...)
+}
+
+// receive returns the next entry of out, nil if it was closed
+func receive(t *testing.T, out <-chan *Entry) *Entry {
+	select {
+	case e := <-out:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("the follower is stuck")
+		return nil
+	}
+}
+
+func TestFollowWakesOnAppend(t *testing.T) {
+	// the timeout is far longer than the test may take, only appends can
+	// wake the follower up
+	source := &waitSource{MemorySource: NewMemorySource(seqnumEntry("a", 1))}
+	stop := make(chan struct{})
+	out := (&Follower{WaitTimeout: time.Hour}).Follow(source, stop)
+
+	if e := receive(t, out); e == nil || e.Fields["MESSAGE"] != "1" {
+		t.Fatalf("entry = %v, want 1", e)
+	}
+	source.Append(seqnumEntry("a", 2))
+	if e := receive(t, out); e == nil || e.Fields["MESSAGE"] != "2" {
+		t.Fatalf("entry = %v, want 2", e)
+	}
+
+	// stop is noticed as soon as the wait returns
+	close(stop)
+	source.Append(seqnumEntry("a", 3))
+	if e := receive(t, out); e != nil {
+		t.Errorf("entry %v after stop", e.Fields["MESSAGE"])
+	}
+	for _, timeout := range source.waits() {
+		if timeout != time.Hour {
+			t.Errorf("waited %v, want %v", timeout, time.Hour)
+		}
+	}
+}
+
+func TestFollowIdle(t *testing.T) {
+	source := &waitSource{MemorySource: NewMemorySource()}
+	stop := make(chan struct{})
+	out := (&Follower{WaitTimeout: 20 * time.Millisecond}).Follow(source, stop)
+
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	if e := receive(t, out); e != nil {
+		t.Fatalf("entry %v from an empty journal", e.Fields)
+	}
+	// one wait per timeout, not a busy loop
+	if n := len(source.waits()); n < 2 || n > 20 {
+		t.Errorf("waited %d times in 200ms with a 20ms timeout", n)
+	}
+}
+
+func TestFollowDefaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		follower   *Follower
+		bufferSize int
+		timeout    time.Duration
+	}{
+		{"defaults", &Follower{}, defaultBufferSize, defaultWaitTimeout},
+		{"configured", &Follower{BufferSize: 7, WaitTimeout: 30 * time.Millisecond}, 7, 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &waitSource{MemorySource: NewMemorySource()}
+			stop := make(chan struct{})
+			out := tt.follower.Follow(source, stop)
+			if cap(out) != tt.bufferSize {
+				t.Errorf("buffer size = %d, want %d", cap(out), tt.bufferSize)
+			}
+			// wake the follower up after its first wait
+			for len(source.waits()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			close(stop)
+			source.Append(seqnumEntry("a", 1))
+			receive(t, out)
+			if waits := source.waits(); waits[0] != tt.timeout {
+				t.Errorf("waited %v, want %v", waits[0], tt.timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// rotationSeqnums is the number of seqnums journald consumes when it rotates
+// a file: the entry that did not fit any more was numbered already, it gets
+// the next seqnum in the new file. Jumps that small are expected if the
+// journal files changed in between, they are reported as rotation gaps.
+const rotationSeqnums = 1
+
+// maxSeqnumSpaces bounds the number of seqnum ids a gap detector tracks. A
+// journal directory collecting the journals of many machines has one per
+// machine and boot of a volatile journal.
+const maxSeqnumSpaces = 1024
+
+// Gap describes entries missing between two consecutive entries of the same
+// seqnum space, e.g. because journald vacuumed them before they were read
+type Gap struct {
+	// SeqnumID identifies the journald instance that numbered the entries
+	SeqnumID string
+	// After and Before are the cursors of the entries around the gap
+	After  string
+	Before string
+	// AfterBootID and BeforeBootID are the boots of those entries
+	AfterBootID  string
+	BeforeBootID string
+	// Lost is the estimated number of missing entries
+	Lost uint64
+	// Rotation is set if the journal files changed between the entries and
+	// the gap is not larger than a rotation of a file skips, the entries
+	// are most likely not lost
+	Rotation bool
+}
+
+// cursorFields are the fields of a cursor the gap detection looks at
+type cursorFields struct {
+	seqnumID string
+	seqnum   uint64
+	bootID   string
+}
+
+// parseCursorFields parses a cursor in the format of sd_journal_get_cursor(3)
+func parseCursorFields(cursor string) (cursorFields, bool) {
+	var c cursorFields
+	var hasSeqnumID, hasSeqnum bool
+	for _, part := range strings.Split(cursor, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "s":
+			c.seqnumID, hasSeqnumID = kv[1], true
+		case "i":
+			seqnum, err := strconv.ParseUint(kv[1], 16, 64)
+			if err != nil {
+				return c, false
+			}
+			c.seqnum, hasSeqnum = seqnum, true
+		case "b":
+			c.bootID = kv[1]
+		}
+	}
+	return c, hasSeqnumID && hasSeqnum
+}
+
+// seqnumSpace is the last entry read of a seqnum space
+type seqnumSpace struct {
+	seqnum  uint64
+	cursor  string
+	bootID  string
+	changes uint64
+}
+
+// gapDetector compares the seqnums of consecutive entries. Entries of
+// different seqnum spaces can be interleaved, so each space is tracked on
+// its own. It only works on unfiltered journals, whose entries of a seqnum
+// space are consecutive.
+type gapDetector struct {
+	// changes counts the rotations, vacuums and reopens seen
+	changes uint64
+	spaces  map[string]*seqnumSpace
+}
+
+// journalChanged records that files of the journal were added or removed
+func (d *gapDetector) journalChanged() {
+	d.changes++
+}
+
+// check returns the gap between entry and the previous entry of its seqnum
+// space, if there is one
+func (d *gapDetector) check(entry *Entry) *Gap {
+	c, ok := parseCursorFields(entry.Cursor)
+	if !ok {
+		return nil
+	}
+	if d.spaces == nil || len(d.spaces) >= maxSeqnumSpaces {
+		d.spaces = map[string]*seqnumSpace{}
+	}
+
+	prev, found := d.spaces[c.seqnumID]
+	d.spaces[c.seqnumID] = &seqnumSpace{seqnum: c.seqnum, cursor: entry.Cursor, bootID: c.bootID, changes: d.changes}
+	if !found || c.seqnum <= prev.seqnum+1 {
+		return nil
+	}
+	return &Gap{
+		SeqnumID:     c.seqnumID,
+		After:        prev.cursor,
+		Before:       entry.Cursor,
+		AfterBootID:  prev.bootID,
+		BeforeBootID: c.bootID,
+		Lost:         c.seqnum - prev.seqnum - 1,
+		Rotation:     prev.changes != d.changes && c.seqnum <= prev.seqnum+1+rotationSeqnums,
+	}
+}
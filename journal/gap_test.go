@@ -0,0 +1,149 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// seqnumEntry is an entry with the seqnum of a seqnum space
+func seqnumEntry(space string, seqnum uint64) *Entry {
+	return &Entry{
+		Cursor: fmt.Sprintf("s=%s;i=%x;b=boot", space, seqnum),
+		Fields: map[string]string{"MESSAGE": fmt.Sprint(seqnum)},
+	}
+}
+
+func TestGapDetector(t *testing.T) {
+	// changed marks entries read after the journal files changed
+	type read struct {
+		space   string
+		seqnum  uint64
+		changed bool
+	}
+	tests := []struct {
+		name  string
+		reads []read
+		lost  []uint64
+		// rotation flags the gaps of lost
+		rotation []bool
+	}{
+		{
+			name:  "consecutive",
+			reads: []read{{"a", 1, false}, {"a", 2, false}, {"a", 3, false}},
+		},
+		{
+			name:  "one entry missing",
+			reads: []read{{"a", 1, false}, {"a", 3, false}},
+			lost:  []uint64{1},
+		},
+		{
+			name:  "vacuumed",
+			reads: []read{{"a", 1, false}, {"a", 10, true}},
+			lost:  []uint64{8},
+		},
+		{
+			name:     "rotated",
+			reads:    []read{{"a", 1, false}, {"a", 3, true}, {"a", 4, false}},
+			lost:     []uint64{1},
+			rotation: []bool{true},
+		},
+		{
+			name:     "rotated and vacuumed",
+			reads:    []read{{"a", 1, false}, {"a", 4, true}},
+			lost:     []uint64{2},
+			rotation: []bool{false},
+		},
+		{
+			name:     "only the first entry after a change",
+			reads:    []read{{"a", 1, true}, {"a", 2, false}, {"a", 4, false}},
+			lost:     []uint64{1},
+			rotation: []bool{false},
+		},
+		{
+			name:  "interleaved seqnum spaces",
+			reads: []read{{"a", 1, false}, {"b", 7, false}, {"a", 2, false}, {"b", 8, false}, {"b", 10, false}},
+			lost:  []uint64{1},
+		},
+		{
+			name:  "seqnums restart",
+			reads: []read{{"a", 5, false}, {"a", 1, false}, {"a", 2, false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &gapDetector{}
+			var lost []uint64
+			var rotation []bool
+			for _, r := range tt.reads {
+				if r.changed {
+					d.journalChanged()
+				}
+				if gap := d.check(seqnumEntry(r.space, r.seqnum)); gap != nil {
+					lost = append(lost, gap.Lost)
+					rotation = append(rotation, gap.Rotation)
+				}
+			}
+			if !reflect.DeepEqual(lost, tt.lost) {
+				t.Errorf("lost = %v, want %v", lost, tt.lost)
+			}
+			if tt.rotation == nil {
+				tt.rotation = make([]bool, len(tt.lost))
+			}
+			if len(lost) > 0 && !reflect.DeepEqual(rotation, tt.rotation) {
+				t.Errorf("rotation = %v, want %v", rotation, tt.rotation)
+			}
+		})
+	}
+}
+
+func TestFollowGaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		filtered bool
+		gaps     int
+	}{
+		{name: "unfiltered", gaps: 1},
+		{name: "filtered", filtered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := NewMemorySource(seqnumEntry("a", 1), seqnumEntry("a", 2), seqnumEntry("a", 5))
+			var gaps []Gap
+			f := &Follower{
+				Filtered:    tt.filtered,
+				Until:       time.Now(),
+				WaitTimeout: 10 * time.Millisecond,
+				OnGap:       func(gap Gap) { gaps = append(gaps, gap) },
+			}
+			n := 0
+			for range f.Follow(source, make(chan struct{})) {
+				n++
+			}
+			if n != 3 {
+				t.Errorf("followed %d entries, want 3", n)
+			}
+			if len(gaps) != tt.gaps {
+				t.Fatalf("gaps = %v, want %d", gaps, tt.gaps)
+			}
+			if tt.gaps > 0 && (gaps[0].Lost != 2 || gaps[0].After != seqnumEntry("a", 2).Cursor) {
+				t.Errorf("gap = %+v, want 2 entries lost after seqnum 2", gaps[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldMatcher evaluates sd-journal style matches in process. It is used by
+// the sources that can not hand the matches over to libsystemd. Like in
+// sd-journal the matches form a conjunction of disjunctions of terms, where
+// a term ANDs matches on different fields and ORs matches on the same field.
+type FieldMatcher struct {
+	clauses [][]map[string][]string
+}
+
+// AddMatch adds a FIELD=value match to the current term
+func (fm *FieldMatcher) AddMatch(match string) error {
+	i := strings.IndexByte(match, '=')
+	if i <= 0 {
+		return fmt.Errorf("Invalid match: %s", match)
+	}
+	if len(fm.clauses) == 0 {
+		fm.clauses = [][]map[string][]string{{}}
+	}
+	clause := fm.clauses[len(fm.clauses)-1]
+	if len(clause) == 0 {
+		clause = append(clause, map[string][]string{})
+		fm.clauses[len(fm.clauses)-1] = clause
+	}
+	term := clause[len(clause)-1]
+	term[match[:i]] = append(term[match[:i]], match[i+1:])
+	return nil
+}
+
+// AddDisjunction starts a new term that is OR'ed with the current one
+func (fm *FieldMatcher) AddDisjunction() error {
+	if len(fm.clauses) == 0 {
+		return nil
+	}
+	clause := fm.clauses[len(fm.clauses)-1]
+	if len(clause) == 0 || len(clause[len(clause)-1]) == 0 {
+		return nil
+	}
+	fm.clauses[len(fm.clauses)-1] = append(clause, map[string][]string{})
+	return nil
+}
+
+// AddConjunction starts a new disjunction that is AND'ed with the current one
+func (fm *FieldMatcher) AddConjunction() error {
+	if len(fm.clauses) == 0 || len(fm.clauses[len(fm.clauses)-1]) == 0 {
+		return nil
+	}
+	fm.clauses = append(fm.clauses, nil)
+	return nil
+}
+
+// FlushMatches removes all matches
+func (fm *FieldMatcher) FlushMatches() {
+	fm.clauses = nil
+}
+
+// Match reports whether the fields of an entry satisfy the matches
+func (fm *FieldMatcher) Match(fields map[string]string) bool {
+	for _, clause := range fm.clauses {
+		matched, empty := false, true
+		for _, term := range clause {
+			if len(term) == 0 {
+				continue
+			}
+			empty = false
+			if matchTerm(term, fields) {
+				matched = true
+				break
+			}
+		}
+		if !empty && !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerm(term map[string][]string, fields map[string]string) bool {
+	for field, values := range term {
+		v, ok := fields[field]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, value := range values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+// +build cgo
+
+package journal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/sdjournal"
+)
+
+// systemdJournal is the Source backed by libsystemd's sd-journal API
+type systemdJournal struct {
+	*sdjournal.Journal
+}
+
+// NewSystemdSource opens the local system journal through libsystemd
+func NewSystemdSource() (Source, error) {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		return nil, err
+	}
+	return &systemdJournal{j}, nil
+}
+
+// NewSystemdDirectorySource opens the journal files in a directory through
+// libsystemd, e.g. a journal of a container host or of systemd-journal-remote
+func NewSystemdDirectorySource(path string) (Source, error) {
+	j, err := sdjournal.NewJournalFromDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return &systemdJournal{j}, nil
+}
+
+// errnoError restores the errno of an error returned by sdjournal, which
+// formats it as a number, e.g. "failed to iterate journal: 74"
+func errnoError(err error) error {
+	i := strings.LastIndex(err.Error(), ": ")
+	if i < 0 {
+		return err
+	}
+	errno, convErr := strconv.Atoi(err.Error()[i+2:])
+	if convErr != nil || errno <= 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", err.Error()[:i], syscall.Errno(errno))
+}
+
+func (j *systemdJournal) Next() (uint64, error) {
+	n, err := j.Journal.Next()
+	if err != nil {
+		return 0, errnoError(err)
+	}
+	return n, nil
+}
+
+func (j *systemdJournal) GetEntry() (*Entry, error) {
+	e, err := j.Journal.GetEntry()
+	if err != nil {
+		return nil, errnoError(err)
+	}
+	return &Entry{
+		Fields:             e.Fields,
+		Cursor:             e.Cursor,
+		RealtimeTimestamp:  e.RealtimeTimestamp,
+		MonotonicTimestamp: e.MonotonicTimestamp,
+	}, nil
+}
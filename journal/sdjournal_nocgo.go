@@ -0,0 +1,32 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cgo
+// +build !cgo
+
+package journal
+
+import (
+	"errors"
+)
+
+// NewSystemdSource is not available without cgo as go-systemd links against libsystemd
+func NewSystemdSource() (Source, error) {
+	return nil, errors.New("journalbeat was built without cgo, the sdjournal backend is not available")
+}
+
+// NewSystemdDirectorySource is not available without cgo as go-systemd links against libsystemd
+func NewSystemdDirectorySource(path string) (Source, error) {
+	return NewSystemdSource()
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"time"
+)
+
+// Values returned by Source.Wait. They carry the same meaning and values as
+// the ones returned by sd_journal_wait(3).
+const (
+	SD_JOURNAL_NOP        = 0
+	SD_JOURNAL_APPEND     = 1
+	SD_JOURNAL_INVALIDATE = 2
+)
+
+// Entry is a single journal entry as returned by a Source
+type Entry struct {
+	Fields             map[string]string
+	Cursor             string
+	RealtimeTimestamp  uint64
+	MonotonicTimestamp uint64
+}
+
+// Source is the reading surface journalbeat needs from a journal. The
+// semantics follow sd-journal: Next moves the read pointer and returns 0 at
+// the end of the journal, GetEntry returns the entry under the read pointer
+// and Wait blocks until the journal changed or the timeout expired.
+type Source interface {
+	Next() (uint64, error)
+	GetEntry() (*Entry, error)
+	GetCatalog() (string, error)
+	GetCursor() (string, error)
+	SeekHead() error
+	SeekTail() error
+	SeekCursor(cursor string) error
+	SeekRealtimeUsec(usec uint64) error
+	Wait(timeout time.Duration) int
+	Close() error
+}
+
+// Matcher is implemented by sources that can filter entries by field
+// matches, e.g. "_SYSTEMD_UNIT=sshd.service". Matches on the same field are
+// OR'ed, matches on different fields are AND'ed. AddDisjunction and
+// AddConjunction combine the matches added so far with the following ones
+// like sd_journal_add_disjunction(3) and sd_journal_add_conjunction(3).
+type Matcher interface {
+	AddMatch(match string) error
+	AddDisjunction() error
+	AddConjunction() error
+	FlushMatches()
+}
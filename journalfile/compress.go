@@ -0,0 +1,70 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journalfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+)
+
+// zstdDecoder is shared by all files, DecodeAll is safe for concurrent use
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxObjectSize))
+
+// decompress returns the uncompressed payload of a DATA object
+func decompress(flags uint8, payload []byte) ([]byte, error) {
+	switch flags & objectCompressionMask {
+	case 0:
+		return payload, nil
+
+	case objectCompressedXZ:
+		r, err := xz.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("xz: %v", err)
+		}
+		return ioutil.ReadAll(r)
+
+	case objectCompressedLZ4:
+		// journald prefixes the LZ4 block with the uncompressed size
+		if len(payload) < 8 {
+			return nil, errCorrupted
+		}
+		size := binary.LittleEndian.Uint64(payload)
+		if size > maxObjectSize {
+			return nil, errCorrupted
+		}
+		out := make([]byte, size)
+		n, err := lz4.UncompressBlock(payload[8:], out, 0)
+		if err != nil {
+			return nil, fmt.Errorf("lz4: %v", err)
+		}
+		return out[:n], nil
+
+	case objectCompressedZSTD:
+		out, err := zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %v", err)
+		}
+		return out, nil
+
+	default:
+		return nil, fmt.Errorf("invalid compression flags %#x", flags)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journalfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressXZ compresses like journald with XZ
+func compressXZ(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// lz4Block is an LZ4 block of "abcd" repeated 6 times and "efghi": the
+// literals "abcd", a match of 20 bytes 4 bytes back and the literals "efghi"
+var lz4Block = []byte{0x4f, 'a', 'b', 'c', 'd', 0x04, 0x00, 0x01, 0x50, 'e', 'f', 'g', 'h', 'i'}
+
+// lz4Payload prefixes an LZ4 block with the uncompressed size like journald
+func lz4Payload(size uint64, block []byte) []byte {
+	out := make([]byte, 8, 8+len(block))
+	binary.LittleEndian.PutUint64(out, size)
+	return append(out, block...)
+}
+
+// compressZSTD compresses like journald with ZSTD
+func compressZSTD(t *testing.T, data []byte) []byte {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil)
+}
+
+func TestDecompress(t *testing.T) {
+	data := []byte("MESSAGE=" + fixtureBig)
+	tests := []struct {
+		name    string
+		flags   uint8
+		payload []byte
+		want    []byte
+	}{
+		{name: "none", flags: 0, payload: data, want: data},
+		{name: "xz", flags: objectCompressedXZ, payload: compressXZ(t, data), want: data},
+		{name: "lz4", flags: objectCompressedLZ4, payload: lz4Payload(29, lz4Block), want: []byte("abcdabcdabcdabcdabcdabcdefghi")},
+		{name: "zstd", flags: objectCompressedZSTD, payload: compressZSTD(t, data), want: data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decompress(tt.flags, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("decompressed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	data := []byte("MESSAGE=" + fixtureBig)
+	tests := []struct {
+		name    string
+		flags   uint8
+		payload []byte
+	}{
+		{name: "xz", flags: objectCompressedXZ, payload: data},
+		{name: "lz4 without size", flags: objectCompressedLZ4, payload: []byte{1, 2, 3}},
+		{name: "lz4 too large", flags: objectCompressedLZ4, payload: lz4Payload(maxObjectSize+1, lz4Block)},
+		{name: "lz4 too small", flags: objectCompressedLZ4, payload: lz4Payload(28, lz4Block)},
+		{name: "zstd", flags: objectCompressedZSTD, payload: data},
+		{name: "several compressions", flags: objectCompressedXZ | objectCompressedZSTD, payload: data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decompress(tt.flags, tt.payload); err == nil {
+				t.Error("decompressing a bad payload did not fail")
+			}
+		})
+	}
+}
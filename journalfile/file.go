@@ -0,0 +1,306 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journalfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// maxCachedData bounds the number of decoded DATA objects kept per file.
+// Entries share most of their data objects (hostname, unit, ...), so even a
+// small cache saves most of the reads.
+const maxCachedData = 4096
+
+// File is a single journal file. Entries are discovered by walking the
+// global entry array chain and new entries are picked up by Refresh, which
+// allows following an active file while journald appends to it.
+type File struct {
+	path   string
+	f      *os.File
+	info   os.FileInfo
+	header *header
+
+	// offsets of the entry objects discovered so far, in order
+	entries []uint64
+
+	// where the walk of the entry array chain continues on the next refresh
+	arrayOffset uint64
+	arrayIndex  int
+
+	dataCache map[uint64]string
+}
+
+// OpenFile opens a journal file and discovers the entries it contains
+func OpenFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	jf := &File{
+		path:      path,
+		f:         f,
+		info:      info,
+		dataCache: map[uint64]string{},
+	}
+	if _, err = jf.Refresh(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Reading journal file %s failed: %v", path, err)
+	}
+	return jf, nil
+}
+
+// Path returns the path the file was opened with
+func (jf *File) Path() string {
+	return jf.path
+}
+
+// FileID returns the unique id of the file which survives renames
+func (jf *File) FileID() ID128 {
+	return jf.header.fileID
+}
+
+// MachineID returns the id of the machine that wrote the file
+func (jf *File) MachineID() ID128 {
+	return jf.header.machineID
+}
+
+// SeqnumID returns the id of the sequence number space of the file
+func (jf *File) SeqnumID() ID128 {
+	return jf.header.seqnumID
+}
+
+// Archived reports whether journald finished writing to the file
+func (jf *File) Archived() bool {
+	return jf.header.state == stateArchived
+}
+
+// Len returns the number of entries discovered so far
+func (jf *File) Len() int {
+	return len(jf.entries)
+}
+
+// removed reports whether the file was deleted. journald deallocates the
+// files it vacuums, so their contents are gone even while they are open.
+func (jf *File) removed() bool {
+	fi, err := jf.f.Stat()
+	if err != nil {
+		return true
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Nlink == 0
+}
+
+// Close closes the underlying file
+func (jf *File) Close() error {
+	return jf.f.Close()
+}
+
+// Refresh re-reads the header and discovers entries that were appended since
+// the last call. It reports whether new entries were found.
+func (jf *File) Refresh() (bool, error) {
+	buf := make([]byte, minHeaderSize)
+	if _, err := jf.f.ReadAt(buf, 0); err != nil {
+		return false, err
+	}
+	h, err := parseHeader(buf)
+	if err != nil {
+		return false, err
+	}
+	jf.header = h
+
+	if jf.arrayOffset == 0 {
+		jf.arrayOffset = h.entryArrayOffset
+	}
+
+	found := len(jf.entries)
+	for jf.arrayOffset != 0 && uint64(len(jf.entries)) < h.nEntries {
+		next, offsets, err := jf.readEntryArray(jf.arrayOffset, jf.arrayIndex)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// journald has not finished writing the array yet
+			break
+		} else if err != nil {
+			return false, err
+		}
+
+		for _, o := range offsets {
+			if o == 0 || uint64(len(jf.entries)) >= h.nEntries {
+				break
+			}
+			jf.entries = append(jf.entries, o)
+			jf.arrayIndex++
+		}
+
+		if jf.arrayIndex < jf.arrayCapacity(jf.arrayOffset) || next == 0 {
+			break
+		}
+		jf.arrayOffset = next
+		jf.arrayIndex = 0
+	}
+	return len(jf.entries) > found, nil
+}
+
+// arrayCapacity returns the number of items an entry array object can hold
+func (jf *File) arrayCapacity(offset uint64) int {
+	var oh [objectHeaderSize]byte
+	if _, err := jf.f.ReadAt(oh[:], int64(offset)); err != nil {
+		return 0
+	}
+	size := binary.LittleEndian.Uint64(oh[8:])
+	if size < entryArrayItemsOffset {
+		return 0
+	}
+	return int((size - entryArrayItemsOffset) / jf.arrayItemSize())
+}
+
+func (jf *File) arrayItemSize() uint64 {
+	if jf.header.compact() {
+		return 4
+	}
+	return 8
+}
+
+// readEntryArray reads the items of an entry array starting at index from,
+// without reading the part that was consumed already.
+func (jf *File) readEntryArray(offset uint64, from int) (uint64, []uint64, error) {
+	var head [entryArrayItemsOffset]byte
+	if err := jf.readAt(head[:], offset); err != nil {
+		return 0, nil, err
+	}
+	if head[0] == objectUnused {
+		// the space is allocated but journald has not written the array yet
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if head[0] != objectEntryArray {
+		return 0, nil, errCorrupted
+	}
+	size := binary.LittleEndian.Uint64(head[8:])
+	if size < entryArrayItemsOffset || size > maxObjectSize {
+		return 0, nil, errCorrupted
+	}
+
+	start := entryArrayItemsOffset + uint64(from)*jf.arrayItemSize()
+	if start > size {
+		return 0, nil, errCorrupted
+	}
+	b := make([]byte, size-start+entryArrayItemsOffset)
+	copy(b, head[:])
+	// items of compact files are 4 bytes, so start is not necessarily 8 byte
+	// aligned like the object offsets readAt checks
+	if _, err := jf.f.ReadAt(b[entryArrayItemsOffset:], int64(offset+start)); err != nil {
+		return 0, nil, err
+	}
+	return parseEntryArray(b, jf.header.compact())
+}
+
+func (jf *File) readAt(b []byte, offset uint64) error {
+	if offset%8 != 0 || offset < jf.header.headerSize {
+		return errCorrupted
+	}
+	_, err := jf.f.ReadAt(b, int64(offset))
+	if err == io.EOF {
+		// objects are only linked once they are written
+		return errCorrupted
+	}
+	return err
+}
+
+// readObject reads the complete object at offset and checks its type
+func (jf *File) readObject(offset uint64, typ uint8) ([]byte, error) {
+	var oh [objectHeaderSize]byte
+	if err := jf.readAt(oh[:], offset); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint64(oh[8:])
+	if oh[0] != typ || size < objectHeaderSize || size > maxObjectSize {
+		return nil, errCorrupted
+	}
+
+	b := make([]byte, size)
+	if err := jf.readAt(b, offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// entry returns the i-th entry of the file
+func (jf *File) entry(i int) (*entryObject, error) {
+	if i < 0 || i >= len(jf.entries) {
+		return nil, io.EOF
+	}
+	b, err := jf.readObject(jf.entries[i], objectEntry)
+	if err != nil {
+		return nil, err
+	}
+	return parseEntryObject(b, jf.header.compact())
+}
+
+// fields returns the data of an entry as a map of field names to values
+func (jf *File) fields(e *entryObject) (map[string]string, error) {
+	fields := make(map[string]string, len(e.items))
+	for _, offset := range e.items {
+		data, err := jf.data(offset)
+		if err != nil {
+			return nil, err
+		}
+		i := strings.IndexByte(data, '=')
+		if i <= 0 {
+			continue
+		}
+		fields[data[:i]] = data[i+1:]
+	}
+	return fields, nil
+}
+
+// data returns the decompressed payload of the DATA object at offset
+func (jf *File) data(offset uint64) (string, error) {
+	if d, ok := jf.dataCache[offset]; ok {
+		return d, nil
+	}
+
+	b, err := jf.readObject(offset, objectData)
+	if err != nil {
+		return "", err
+	}
+	payloadOffset := dataPayloadOffset
+	if jf.header.compact() {
+		payloadOffset = dataCompactPayloadOffset
+	}
+	if len(b) < payloadOffset {
+		return "", errCorrupted
+	}
+	payload, err := decompress(b[1], b[payloadOffset:])
+	if err != nil {
+		return "", err
+	}
+
+	if len(jf.dataCache) >= maxCachedData {
+		jf.dataCache = map[uint64]string{}
+	}
+	d := string(payload)
+	jf.dataCache[offset] = d
+	return d, nil
+}
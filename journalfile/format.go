@@ -0,0 +1,237 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journalfile reads journald's on-disk .journal files without going
+// through libsystemd. The format is documented at
+// https://systemd.io/JOURNAL_FILE_FORMAT/
+package journalfile
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+var signature = [8]byte{'L', 'P', 'K', 'S', 'H', 'H', 'R', 'H'}
+
+// Header incompatible flags
+const (
+	incompatibleCompressedXZ   uint32 = 1 << 0
+	incompatibleCompressedLZ4  uint32 = 1 << 1
+	incompatibleKeyedHash      uint32 = 1 << 2
+	incompatibleCompressedZSTD uint32 = 1 << 3
+	incompatibleCompact        uint32 = 1 << 4
+
+	incompatibleSupported = incompatibleCompressedXZ | incompatibleCompressedLZ4 |
+		incompatibleKeyedHash | incompatibleCompressedZSTD | incompatibleCompact
+)
+
+// Header states
+const (
+	stateOffline  uint8 = 0
+	stateOnline   uint8 = 1
+	stateArchived uint8 = 2
+)
+
+// Object types
+const (
+	objectUnused         uint8 = 0
+	objectData           uint8 = 1
+	objectField          uint8 = 2
+	objectEntry          uint8 = 3
+	objectDataHashTable  uint8 = 4
+	objectFieldHashTable uint8 = 5
+	objectEntryArray     uint8 = 6
+	objectTag            uint8 = 7
+)
+
+// Object compression flags
+const (
+	objectCompressedXZ   uint8 = 1 << 0
+	objectCompressedLZ4  uint8 = 1 << 1
+	objectCompressedZSTD uint8 = 1 << 2
+
+	objectCompressionMask = objectCompressedXZ | objectCompressedLZ4 | objectCompressedZSTD
+)
+
+const (
+	// minHeaderSize is the size of the header as written by the first
+	// systemd versions, all fields we need are contained in it.
+	minHeaderSize = 208
+
+	objectHeaderSize = 16
+
+	// offsets of the payloads within the objects
+	dataPayloadOffset        = 64
+	dataCompactPayloadOffset = 72
+	entryItemsOffset         = 64
+	entryArrayItemsOffset    = 24
+
+	// maxObjectSize guards against reading garbage from corrupted files
+	maxObjectSize = 1 << 30
+)
+
+// errCorrupted wraps EBADMSG, which is what libsystemd reports for corrupted
+// objects, so callers can handle both backends alike
+var errCorrupted = fmt.Errorf("journal file is corrupted: %w", syscall.EBADMSG)
+
+// ID128 is a 128 bit id as used by systemd for machine, boot and file ids
+type ID128 [16]byte
+
+func (id ID128) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func parseID128(s string) (ID128, error) {
+	var id ID128
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("invalid 128 bit id: %s", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// header contains the fields of the journal file header we care about
+type header struct {
+	compatibleFlags    uint32
+	incompatibleFlags  uint32
+	state              uint8
+	fileID             ID128
+	machineID          ID128
+	bootID             ID128
+	seqnumID           ID128
+	headerSize         uint64
+	arenaSize          uint64
+	tailObjectOffset   uint64
+	nObjects           uint64
+	nEntries           uint64
+	tailEntrySeqnum    uint64
+	headEntrySeqnum    uint64
+	entryArrayOffset   uint64
+	headEntryRealtime  uint64
+	tailEntryRealtime  uint64
+	tailEntryMonotonic uint64
+}
+
+func parseHeader(b []byte) (*header, error) {
+	if len(b) < minHeaderSize {
+		return nil, errCorrupted
+	}
+	for i := range signature {
+		if b[i] != signature[i] {
+			return nil, errors.New("not a journal file: invalid signature")
+		}
+	}
+
+	le := binary.LittleEndian
+	h := &header{
+		compatibleFlags:    le.Uint32(b[8:]),
+		incompatibleFlags:  le.Uint32(b[12:]),
+		state:              b[16],
+		headerSize:         le.Uint64(b[88:]),
+		arenaSize:          le.Uint64(b[96:]),
+		tailObjectOffset:   le.Uint64(b[136:]),
+		nObjects:           le.Uint64(b[144:]),
+		nEntries:           le.Uint64(b[152:]),
+		tailEntrySeqnum:    le.Uint64(b[160:]),
+		headEntrySeqnum:    le.Uint64(b[168:]),
+		entryArrayOffset:   le.Uint64(b[176:]),
+		headEntryRealtime:  le.Uint64(b[184:]),
+		tailEntryRealtime:  le.Uint64(b[192:]),
+		tailEntryMonotonic: le.Uint64(b[200:]),
+	}
+	copy(h.fileID[:], b[24:40])
+	copy(h.machineID[:], b[40:56])
+	copy(h.bootID[:], b[56:72])
+	copy(h.seqnumID[:], b[72:88])
+
+	if h.incompatibleFlags&^incompatibleSupported != 0 {
+		return nil, fmt.Errorf("journal file uses unsupported features (incompatible flags %#x)", h.incompatibleFlags)
+	}
+	if h.headerSize < minHeaderSize {
+		return nil, errCorrupted
+	}
+	return h, nil
+}
+
+func (h *header) compact() bool {
+	return h.incompatibleFlags&incompatibleCompact != 0
+}
+
+// entryObject is a decoded ENTRY object, without its data items
+type entryObject struct {
+	seqnum    uint64
+	realtime  uint64
+	monotonic uint64
+	bootID    ID128
+	xorHash   uint64
+	items     []uint64
+}
+
+func parseEntryObject(b []byte, compact bool) (*entryObject, error) {
+	if len(b) < entryItemsOffset {
+		return nil, errCorrupted
+	}
+	le := binary.LittleEndian
+	e := &entryObject{
+		seqnum:    le.Uint64(b[16:]),
+		realtime:  le.Uint64(b[24:]),
+		monotonic: le.Uint64(b[32:]),
+		xorHash:   le.Uint64(b[56:]),
+	}
+	copy(e.bootID[:], b[40:56])
+
+	itemSize := 16
+	if compact {
+		itemSize = 4
+	}
+	items := b[entryItemsOffset:]
+	e.items = make([]uint64, 0, len(items)/itemSize)
+	for i := 0; i+itemSize <= len(items); i += itemSize {
+		if compact {
+			e.items = append(e.items, uint64(le.Uint32(items[i:])))
+		} else {
+			e.items = append(e.items, le.Uint64(items[i:]))
+		}
+	}
+	return e, nil
+}
+
+// parseEntryArray returns the offset of the next entry array and the
+// entry offsets stored in this one. Unused slots are returned as 0.
+func parseEntryArray(b []byte, compact bool) (uint64, []uint64, error) {
+	if len(b) < entryArrayItemsOffset {
+		return 0, nil, errCorrupted
+	}
+	le := binary.LittleEndian
+	next := le.Uint64(b[16:])
+
+	itemSize := 8
+	if compact {
+		itemSize = 4
+	}
+	items := b[entryArrayItemsOffset:]
+	offsets := make([]uint64, 0, len(items)/itemSize)
+	for i := 0; i+itemSize <= len(items); i += itemSize {
+		if compact {
+			offsets = append(offsets, uint64(le.Uint32(items[i:])))
+		} else {
+			offsets = append(offsets, le.Uint64(items[i:]))
+		}
+	}
+	return next, offsets, nil
+}
@@ -0,0 +1,685 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journalfile
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/medallia/journalbeat/journal"
+)
+
+const (
+	// pollInterval is how often Wait checks the files for new entries when
+	// they can not be watched
+	pollInterval = 100 * time.Millisecond
+
+	// rescanInterval is how often directories are checked for rotated files
+	rescanInterval = time.Second
+
+	machineIDFile = "/etc/machine-id"
+)
+
+// SystemDirectories are the directories journald writes the system journal to
+var SystemDirectories = []string{"/var/log/journal", "/run/log/journal"}
+
+// filePosition tracks the read position within one file of a Reader
+type filePosition struct {
+	file *File
+	// next is the index of the entry returned by the next call to Next
+	next int
+	// head caches the entry at index next
+	head *entryObject
+	// pinned files were opened explicitly and are not subject to rescans
+	pinned bool
+}
+
+func (fp *filePosition) peek() (*entryObject, error) {
+	if fp.head == nil && fp.next < fp.file.Len() {
+		e, err := fp.file.entry(fp.next)
+		if err != nil {
+			return nil, err
+		}
+		fp.head = e
+	}
+	return fp.head, nil
+}
+
+func (fp *filePosition) seek(i int) {
+	fp.next = i
+	fp.head = nil
+}
+
+// current is the entry under the read pointer of a Reader
+type current struct {
+	file   *File
+	entry  *entryObject
+	fields map[string]string
+}
+
+// Reader reads a set of journal files and interleaves their entries in the
+// same order sd-journal does. It implements journal.Source and
+// journal.Matcher, so it can be used in place of libsystemd.
+type Reader struct {
+	dirs      []string
+	machineID *ID128
+
+	files      []*filePosition
+	lastRescan time.Time
+	// ignored holds files of other machines and files that could not be opened
+	ignored map[string]os.FileInfo
+
+	cur     *current
+	matches journal.FieldMatcher
+
+	// skipped counts the entries that could not be read, skipErr is the
+	// first of their errors, which Next returns at the end of the journal
+	skipped int
+	skipErr error
+
+	// watcher is set up by the first Wait, without it Wait polls
+	watcher     *watcher
+	watchFailed bool
+}
+
+// Open opens the given journal files and directories. Directories are
+// handled like in OpenDirectory. Without any paths the system journal is
+// opened, see OpenSystem.
+func Open(paths ...string) (*Reader, error) {
+	if len(paths) == 0 {
+		return OpenSystem()
+	}
+
+	r := &Reader{ignored: map[string]os.FileInfo{}}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		if fi.IsDir() {
+			r.dirs = append(r.dirs, p)
+			continue
+		}
+		f, err := OpenFile(p)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, &filePosition{file: f, pinned: true})
+	}
+	if err := r.rescan(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenFiles opens a fixed set of journal files, e.g. archived .journal~ files
+func OpenFiles(paths ...string) (*Reader, error) {
+	r := &Reader{}
+	for _, p := range paths {
+		f, err := OpenFile(p)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, &filePosition{file: f, pinned: true})
+	}
+	return r, nil
+}
+
+// OpenDirectory opens all journal files within the directories and their
+// machine id subdirectories, like sd_journal_open_directory(3). Files that
+// are created or rotated later are picked up while following.
+func OpenDirectory(dirs ...string) (*Reader, error) {
+	r := &Reader{dirs: dirs, ignored: map[string]os.FileInfo{}}
+	if err := r.rescan(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenSystem opens the journal files of the local machine in the system
+// journal directories, like sd_journal_open(3) with SD_JOURNAL_LOCAL_ONLY
+func OpenSystem() (*Reader, error) {
+	var dirs []string
+	for _, d := range SystemDirectories {
+		if _, err := os.Stat(d); err == nil {
+			dirs = append(dirs, d)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("None of the system journal directories exist: %s", strings.Join(SystemDirectories, ", "))
+	}
+
+	r := &Reader{dirs: dirs, ignored: map[string]os.FileInfo{}}
+	if b, err := ioutil.ReadFile(machineIDFile); err == nil {
+		if id, err := parseID128(strings.TrimSpace(string(b))); err == nil {
+			r.machineID = &id
+		}
+	}
+	if err := r.rescan(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// journalFiles lists the journal files of a directory and of its machine id
+// subdirectories, along with the directories it looked into
+func journalFiles(dir string) ([]string, []string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths, dirs := []string{}, []string{dir}
+	for _, fi := range infos {
+		p := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			if _, err := parseID128(fi.Name()); err != nil {
+				continue
+			}
+			sub, subDirs, err := journalFiles(p)
+			if err != nil {
+				return nil, nil, err
+			}
+			paths = append(paths, sub...)
+			dirs = append(dirs, subDirs...)
+			continue
+		}
+		if strings.HasSuffix(fi.Name(), ".journal") || strings.HasSuffix(fi.Name(), ".journal~") {
+			paths = append(paths, p)
+		}
+	}
+	return paths, dirs, nil
+}
+
+// rescan synchronizes the set of open files with the directories
+func (r *Reader) rescan() error {
+	r.lastRescan = time.Now()
+	if len(r.dirs) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for _, d := range r.dirs {
+		p, dirs, err := journalFiles(d)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p...)
+		if r.watcher != nil {
+			for _, dir := range dirs {
+				if err := r.watcher.watchDir(dir); err != nil {
+					logp.Warn("Could not watch journal directory %s: %v", dir, err)
+				}
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	present := map[*filePosition]bool{}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+
+		// archiving renames the active file, keep reading from the already open one
+		var known *filePosition
+		for _, fp := range r.files {
+			if os.SameFile(fp.file.info, fi) {
+				known = fp
+				break
+			}
+		}
+		if known != nil {
+			known.file.path = p
+			present[known] = true
+			continue
+		}
+		// retry files that failed to open once they changed
+		if ignored, ok := r.ignored[p]; ok && os.SameFile(ignored, fi) &&
+			ignored.Size() == fi.Size() && ignored.ModTime().Equal(fi.ModTime()) {
+			continue
+		}
+
+		f, err := OpenFile(p)
+		if err != nil {
+			// files can be half written or corrupted, sd-journal skips them as well
+			logp.Warn("Skipping journal file %s: %v", p, err)
+			r.ignored[p] = fi
+			continue
+		}
+		if r.machineID != nil && f.MachineID() != *r.machineID {
+			f.Close()
+			r.ignored[p] = fi
+			continue
+		}
+		fp := &filePosition{file: f}
+		r.files = append(r.files, fp)
+		present[fp] = true
+	}
+
+	// files that vanished were vacuumed, journald deallocated their entries
+	// already. Files that are only missing from the listing because they
+	// were renamed in the meantime are kept.
+	for _, fp := range append([]*filePosition(nil), r.files...) {
+		if !fp.pinned && !present[fp] && fp.file.removed() {
+			r.drop(fp)
+		}
+	}
+	return nil
+}
+
+// drop closes a file and stops reading from it
+func (r *Reader) drop(fp *filePosition) {
+	logp.Info("Journal file %s was removed", fp.file.Path())
+	fp.file.Close()
+	files := r.files[:0]
+	for _, f := range r.files {
+		if f != fp {
+			files = append(files, f)
+		}
+	}
+	r.files = files
+}
+
+// compareEntries orders entries from different files like sd-journal does:
+// by sequence number within the same sequence number space, by monotonic
+// time within the same boot and by wallclock time otherwise
+func compareEntries(af *File, a *entryObject, bf *File, b *entryObject) int {
+	var x, y uint64
+	switch {
+	case af.SeqnumID() == bf.SeqnumID():
+		x, y = a.seqnum, b.seqnum
+	case a.bootID == b.bootID:
+		x, y = a.monotonic, b.monotonic
+	default:
+		x, y = a.realtime, b.realtime
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func (r *Reader) isCurrent(f *File, e *entryObject) bool {
+	return r.cur != nil && r.cur.file.SeqnumID() == f.SeqnumID() &&
+		r.cur.entry.seqnum == e.seqnum && r.cur.entry.xorHash == e.xorHash
+}
+
+// Next moves the read pointer to the next entry that satisfies the matches.
+// It returns 0 if the end of the journal was reached. Entries that can not
+// be read are skipped, the error of the first one is returned once the end
+// of the journal is reached.
+func (r *Reader) Next() (uint64, error) {
+	for {
+		var best *filePosition
+		var bestEntry *entryObject
+		for _, fp := range r.files {
+			e, err := fp.peek()
+			if err != nil && !fp.pinned && fp.file.removed() {
+				// vacuumed under us, the remaining entries are lost
+				r.drop(fp)
+				return r.Next()
+			}
+			for err != nil {
+				// move past the entry, the other files are read on
+				r.skip(fp, err)
+				e, err = fp.peek()
+			}
+			if e == nil {
+				continue
+			}
+			if best == nil || compareEntries(fp.file, e, best.file, bestEntry) < 0 {
+				best, bestEntry = fp, e
+			}
+		}
+		if best == nil {
+			err := r.skipErr
+			r.skipErr = nil
+			return 0, err
+		}
+		best.seek(best.next + 1)
+
+		// the same entry can be contained in several files
+		if r.isCurrent(best.file, bestEntry) {
+			continue
+		}
+
+		fields, err := best.file.fields(bestEntry)
+		if err != nil {
+			r.skip(nil, err)
+			continue
+		}
+		r.cur = &current{file: best.file, entry: bestEntry, fields: fields}
+		if r.matches.Match(fields) {
+			return 1, nil
+		}
+	}
+}
+
+// skip counts an entry that can not be read and moves fp past it, unless
+// fp already moved on
+func (r *Reader) skip(fp *filePosition, err error) {
+	if fp != nil {
+		logp.Warn("Skipping entry %d of journal file %s: %v", fp.next, fp.file.Path(), err)
+		fp.seek(fp.next + 1)
+	} else {
+		logp.Warn("Skipping an entry whose data can not be read: %v", err)
+	}
+	r.skipped++
+	if r.skipErr == nil {
+		r.skipErr = err
+	}
+}
+
+// Skipped returns the number of entries that could not be read
+func (r *Reader) Skipped() int {
+	return r.skipped
+}
+
+// GetEntry returns the entry under the read pointer
+func (r *Reader) GetEntry() (*journal.Entry, error) {
+	if r.cur == nil {
+		return nil, errors.New("no entry under the read pointer")
+	}
+	fields := make(map[string]string, len(r.cur.fields))
+	for k, v := range r.cur.fields {
+		fields[k] = v
+	}
+	return &journal.Entry{
+		Fields:             fields,
+		Cursor:             r.cursor(),
+		RealtimeTimestamp:  r.cur.entry.realtime,
+		MonotonicTimestamp: r.cur.entry.monotonic,
+	}, nil
+}
+
+// GetCatalog is not supported, the message catalog is a libsystemd database
+func (r *Reader) GetCatalog() (string, error) {
+	return "", errors.New("the message catalog is not available when reading journal files directly")
+}
+
+// GetCursor returns the cursor of the entry under the read pointer. The
+// format is the same as the one of sd_journal_get_cursor(3), so cursors can
+// be exchanged with the sdjournal backend.
+func (r *Reader) GetCursor() (string, error) {
+	if r.cur == nil {
+		return "", errors.New("no entry under the read pointer")
+	}
+	return r.cursor(), nil
+}
+
+func (r *Reader) cursor() string {
+	e := r.cur.entry
+	return fmt.Sprintf("s=%s;i=%x;b=%s;m=%x;t=%x;x=%x",
+		r.cur.file.SeqnumID(), e.seqnum, e.bootID, e.monotonic, e.realtime, e.xorHash)
+}
+
+// SeekHead moves the read pointer before the first entry
+func (r *Reader) SeekHead() error {
+	for _, fp := range r.files {
+		fp.seek(0)
+	}
+	r.cur = nil
+	return nil
+}
+
+// SeekTail moves the read pointer behind the last entry
+func (r *Reader) SeekTail() error {
+	if _, err := r.refresh(); err != nil {
+		return err
+	}
+	for _, fp := range r.files {
+		fp.seek(fp.file.Len())
+	}
+	r.cur = nil
+	return nil
+}
+
+// cursorLocation is a parsed cursor
+type cursorLocation struct {
+	seqnumID ID128
+	seqnum   uint64
+	realtime uint64
+}
+
+func parseCursor(cursor string) (*cursorLocation, error) {
+	loc := &cursorLocation{}
+	var hasSeqnumID, hasSeqnum, hasRealtime bool
+	for _, part := range strings.Split(cursor, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+		var err error
+		switch kv[0] {
+		case "s":
+			loc.seqnumID, err = parseID128(kv[1])
+			hasSeqnumID = true
+		case "i":
+			loc.seqnum, err = strconv.ParseUint(kv[1], 16, 64)
+			hasSeqnum = true
+		case "t":
+			loc.realtime, err = strconv.ParseUint(kv[1], 16, 64)
+			hasRealtime = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+	}
+	if !(hasSeqnumID && hasSeqnum) && !hasRealtime {
+		return nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	return loc, nil
+}
+
+// SeekCursor moves the read pointer so that the next call to Next returns
+// the entry with the given cursor, or the first entry after it if it was
+// vacuumed already.
+func (r *Reader) SeekCursor(cursor string) error {
+	loc, err := parseCursor(cursor)
+	if err != nil {
+		return err
+	}
+	return r.seek(func(f *File, e *entryObject) bool {
+		if f.SeqnumID() == loc.seqnumID {
+			return e.seqnum >= loc.seqnum
+		}
+		return e.realtime >= loc.realtime
+	})
+}
+
+// SeekRealtimeUsec moves the read pointer so that the next call to Next
+// returns the first entry written at or after usec microseconds since the
+// epoch
+func (r *Reader) SeekRealtimeUsec(usec uint64) error {
+	return r.seek(func(f *File, e *entryObject) bool {
+		return e.realtime >= usec
+	})
+}
+
+// seek moves the read pointer of every file to the first entry for which
+// after returns true, using a binary search
+func (r *Reader) seek(after func(f *File, e *entryObject) bool) error {
+	for _, fp := range r.files {
+		f := fp.file
+		var searchErr error
+		i := sort.Search(f.Len(), func(i int) bool {
+			e, err := f.entry(i)
+			if err != nil {
+				searchErr = err
+				return true
+			}
+			return after(f, e)
+		})
+		if searchErr != nil {
+			return searchErr
+		}
+		fp.seek(i)
+	}
+	r.cur = nil
+	return nil
+}
+
+// refresh picks up new entries and files, it returns the sd_journal_wait(3)
+// event that describes the change
+func (r *Reader) refresh() (int, error) {
+	event := journal.SD_JOURNAL_NOP
+	if len(r.dirs) > 0 && time.Since(r.lastRescan) >= rescanInterval {
+		before := len(r.files)
+		if err := r.rescan(); err != nil {
+			return event, err
+		}
+		if len(r.files) != before {
+			event = journal.SD_JOURNAL_INVALIDATE
+		}
+	}
+
+	// a broken file must not keep the others from being refreshed
+	var refreshErr error
+	for _, fp := range append([]*filePosition(nil), r.files...) {
+		appended, err := fp.file.Refresh()
+		if err != nil && !fp.pinned && fp.file.removed() {
+			r.drop(fp)
+			event = journal.SD_JOURNAL_INVALIDATE
+			continue
+		}
+		if err != nil {
+			if refreshErr == nil {
+				refreshErr = fmt.Errorf("Refreshing journal file %s failed: %v", fp.file.Path(), err)
+			}
+			continue
+		}
+		if appended && event == journal.SD_JOURNAL_NOP {
+			event = journal.SD_JOURNAL_APPEND
+		}
+	}
+	return event, refreshErr
+}
+
+// startWatching sets up the inotify watches of the directories and files
+func (r *Reader) startWatching() {
+	w, err := newWatcher()
+	if err != nil {
+		logp.Warn("Could not watch the journal files, polling them: %v", err)
+		r.watchFailed = true
+		return
+	}
+	for _, fp := range r.files {
+		if fp.pinned {
+			if err := w.watchFile(fp.file.Path()); err != nil {
+				logp.Warn("Could not watch journal file %s: %v", fp.file.Path(), err)
+			}
+		}
+	}
+	r.watcher = w
+	// the directories are watched by the rescan
+	r.lastRescan = time.Time{}
+}
+
+// Wait blocks until new entries were appended, files were added or removed,
+// or the timeout expired. Like sd_journal_wait(3) it sleeps on inotify
+// events, it falls back to polling the files where that is not possible.
+func (r *Reader) Wait(timeout time.Duration) int {
+	if r.watcher == nil && !r.watchFailed {
+		r.startWatching()
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		event, err := r.refresh()
+		if err != nil {
+			logp.Err("%v", err)
+		}
+		if event != journal.SD_JOURNAL_NOP {
+			return event
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return journal.SD_JOURNAL_NOP
+		}
+		if r.watcher == nil {
+			if remaining > pollInterval {
+				remaining = pollInterval
+			}
+			time.Sleep(remaining)
+			continue
+		}
+
+		dirChanged, err := r.watcher.wait(remaining)
+		if err != nil {
+			logp.Warn("Watching the journal files failed, polling them: %v", err)
+			r.watcher.close()
+			r.watcher = nil
+			r.watchFailed = true
+		}
+		if dirChanged {
+			r.lastRescan = time.Time{}
+		}
+	}
+}
+
+// AddMatch adds a FIELD=value match. Matches on the same field are OR'ed,
+// matches on different fields are AND'ed.
+func (r *Reader) AddMatch(match string) error {
+	return r.matches.AddMatch(match)
+}
+
+// AddDisjunction ORs the matches added so far with the following ones
+func (r *Reader) AddDisjunction() error {
+	return r.matches.AddDisjunction()
+}
+
+// AddConjunction ANDs the disjunctions added so far with the following ones
+func (r *Reader) AddConjunction() error {
+	return r.matches.AddConjunction()
+}
+
+// FlushMatches removes all matches
+func (r *Reader) FlushMatches() {
+	r.matches.FlushMatches()
+}
+
+// Close closes all files
+func (r *Reader) Close() error {
+	if r.watcher != nil {
+		r.watcher.close()
+		r.watcher = nil
+	}
+	for _, fp := range r.files {
+		fp.file.Close()
+	}
+	r.files = nil
+	r.cur = nil
+	return nil
+}
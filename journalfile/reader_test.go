@@ -0,0 +1,370 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journalfile
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/medallia/journalbeat/journal"
+)
+
+// fixtureBig is the value of the BIG field of "entry 2" and "entry 5", see
+// testdata/generate.py
+var fixtureBig = strings.Repeat("journalbeat compresses nothing, journald does. ", 40)
+
+// fixtureMessages are the messages of the regular and compact fixtures, the
+// second one is a status message of journald
+var fixtureMessages = []string{
+	"Journal started", "", "entry 0", "entry 1", "entry 2", "entry 3", "entry 4", "entry 5", "Journal stopped",
+}
+
+// the cursor and timestamp of "entry 2" as journalctl shows them
+const (
+	regularCursor   = "s=198fa840649b49a1ac9274cfbf03a9d7;i=5;b=8a5ead0018c14c6caec883823b430bfc;m=11937a491;t=65df73410f9f8;x=1a7d52301bb831f1"
+	regularRealtime = 1792166172097016
+	compactCursor   = "s=c575601399404238b343fbd9f483aeba;i=5;b=8a5ead0018c14c6caec883823b430bfc;m=11953a534;t=65df7342cfa9b;x=8bde59b6c35651bc"
+)
+
+// unpackFixture writes a gzipped fixture of testdata to path
+func unpackFixture(t *testing.T, name, path string) {
+	f, err := os.Open(filepath.Join("testdata", name+".journal.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openFixture opens a gzipped fixture of testdata as system.journal of a
+// temporary directory
+func openFixture(t *testing.T, name string) (*Reader, string) {
+	dir, err := ioutil.TempDir("", "journalfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "system.journal")
+	unpackFixture(t, name, path)
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, path
+}
+
+// readAll reads the entries up to the end of the journal
+func readAll(t *testing.T, r *Reader) []*journal.Entry {
+	var entries []*journal.Entry
+	for {
+		n, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return entries
+		}
+		e, err := r.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// entryMessages returns the messages of the entries, the status message of
+// journald is left empty as it names the directory it was written to
+func entryMessages(entries []*journal.Entry) []string {
+	var msgs []string
+	for _, e := range entries {
+		msg := e.Fields["MESSAGE"]
+		if strings.HasPrefix(msg, "Runtime Journal") {
+			msg = ""
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestReadFixtures(t *testing.T) {
+	for _, name := range []string{"regular", "compact"} {
+		t.Run(name, func(t *testing.T) {
+			r, _ := openFixture(t, name)
+			entries := readAll(t, r)
+			if got := entryMessages(entries); !reflect.DeepEqual(got, fixtureMessages) {
+				t.Fatalf("messages = %q, want %q", got, fixtureMessages)
+			}
+			var last uint64
+			for i, e := range entries {
+				if e.RealtimeTimestamp < last {
+					t.Errorf("entry %d was written before the one before it", i)
+				}
+				last = e.RealtimeTimestamp
+				if !strings.HasPrefix(e.Fields["MESSAGE"], "entry ") {
+					continue
+				}
+				index := fmt.Sprint(i - 2)
+				if e.Fields["INDEX"] != index || e.Fields["SYSLOG_IDENTIFIER"] != "fixture" || e.Fields["PRIORITY"] != "6" {
+					t.Errorf("entry %d has fields %v", i, e.Fields)
+				}
+				// BIG is stored compressed
+				big, hasBig := e.Fields["BIG"]
+				if wantBig := index == "2" || index == "5"; hasBig != wantBig || (hasBig && big != fixtureBig) {
+					t.Errorf("entry %d has BIG %q", i, big)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipCorruptedEntry(t *testing.T) {
+	tests := []struct {
+		name string
+		// corrupt overwrites the header of the entry object at offset
+		corrupt func(f *os.File, offset uint64) error
+	}{
+		{"bad type", func(f *os.File, offset uint64) error {
+			_, err := f.WriteAt([]byte{objectData}, int64(offset))
+			return err
+		}},
+		{"truncated", func(f *os.File, offset uint64) error {
+			var size [8]byte
+			binary.LittleEndian.PutUint64(size[:], objectHeaderSize-1)
+			_, err := f.WriteAt(size[:], int64(offset+8))
+			return err
+		}},
+		{"beyond the file", func(f *os.File, offset uint64) error {
+			var size [8]byte
+			binary.LittleEndian.PutUint64(size[:], maxObjectSize)
+			_, err := f.WriteAt(size[:], int64(offset+8))
+			return err
+		}},
+	}
+	for _, name := range []string{"regular", "compact"} {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				dir, err := ioutil.TempDir("", "journalfile")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				path := filepath.Join(dir, "system.journal")
+				unpackFixture(t, name, path)
+
+				// "entry 2" is the fifth entry of the fixtures
+				jf, err := OpenFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				offset := jf.entries[4]
+				jf.Close()
+				f, err := os.OpenFile(path, os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tt.corrupt(f, offset); err != nil {
+					t.Fatal(err)
+				}
+				f.Close()
+
+				r, err := Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer r.Close()
+				var entries []*journal.Entry
+				var errs []error
+				errAt := -1
+				for i := 0; i < 2*len(fixtureMessages); i++ {
+					n, err := r.Next()
+					if err != nil {
+						errs = append(errs, err)
+						errAt = len(entries)
+						continue
+					}
+					if n == 0 {
+						break
+					}
+					e, err := r.GetEntry()
+					if err != nil {
+						t.Fatal(err)
+					}
+					entries = append(entries, e)
+				}
+
+				want := append(append([]string{}, fixtureMessages[:4]...), fixtureMessages[5:]...)
+				if got := entryMessages(entries); !reflect.DeepEqual(got, want) {
+					t.Errorf("messages = %q, want %q", got, want)
+				}
+				if len(errs) != 1 || !errors.Is(errs[0], syscall.EBADMSG) || errAt != len(want) {
+					t.Errorf("errors = %v after %d entries, want EBADMSG once at the end", errs, errAt)
+				}
+				if r.Skipped() != 1 {
+					t.Errorf("skipped %d entries, want 1", r.Skipped())
+				}
+			})
+		}
+	}
+}
+
+func TestSeekCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+		want   string
+	}{
+		{name: "regular", cursor: regularCursor, want: "entry 2"},
+		{name: "compact", cursor: compactCursor, want: "entry 2"},
+		// a cursor of another journal falls back to its timestamp
+		{name: "regular", cursor: "s=00000000000000000000000000000001;i=1;t=65df73410f9f9", want: "entry 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := openFixture(t, tt.name)
+			if err := r.SeekCursor(tt.cursor); err != nil {
+				t.Fatal(err)
+			}
+			entries := readAll(t, r)
+			if len(entries) == 0 || entries[0].Fields["MESSAGE"] != tt.want {
+				t.Fatalf("first entry after the seek = %q, want %s", entryMessages(entries), tt.want)
+			}
+			if tt.cursor == regularCursor || tt.cursor == compactCursor {
+				if entries[0].Cursor != tt.cursor {
+					t.Errorf("cursor = %s, want %s", entries[0].Cursor, tt.cursor)
+				}
+				if cursor, err := r.GetCursor(); err != nil || cursor != entries[len(entries)-1].Cursor {
+					t.Errorf("GetCursor = %s, %v, want the cursor of the last entry", cursor, err)
+				}
+			}
+		})
+	}
+
+	r, _ := openFixture(t, "regular")
+	if err := r.SeekCursor("i=5"); err == nil {
+		t.Error("a cursor without seqnum id and timestamp was accepted")
+	}
+}
+
+func TestSeekRealtime(t *testing.T) {
+	tests := []struct {
+		usec uint64
+		want []string
+	}{
+		{usec: regularRealtime, want: fixtureMessages[4:]},
+		{usec: regularRealtime + 1, want: fixtureMessages[5:]},
+		{usec: 0, want: fixtureMessages},
+		{usec: regularRealtime * 2, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.usec), func(t *testing.T) {
+			r, _ := openFixture(t, "regular")
+			if err := r.SeekRealtimeUsec(tt.usec); err != nil {
+				t.Fatal(err)
+			}
+			if got := entryMessages(readAll(t, r)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeekTail(t *testing.T) {
+	r, _ := openFixture(t, "regular")
+	if err := r.SeekTail(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := readAll(t, r); len(entries) != 0 {
+		t.Errorf("read %q after seeking to the tail", entryMessages(entries))
+	}
+}
+
+func TestMatches(t *testing.T) {
+	r, _ := openFixture(t, "compact")
+	if err := r.AddMatch("INDEX=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddMatch("INDEX=4"); err != nil {
+		t.Fatal(err)
+	}
+	if got := entryMessages(readAll(t, r)); !reflect.DeepEqual(got, []string{"entry 1", "entry 4"}) {
+		t.Errorf("messages = %q, want [entry 1 entry 4]", got)
+	}
+}
+
+func TestFollowGrowingFile(t *testing.T) {
+	// growing-1 is the regular fixture while journald was still writing it
+	r, path := openFixture(t, "growing-1")
+	entries := readAll(t, r)
+	if got := entryMessages(entries); !reflect.DeepEqual(got, fixtureMessages[:5]) {
+		t.Fatalf("messages = %q, want %q", got, fixtureMessages[:5])
+	}
+	if e := r.Wait(10 * time.Millisecond); e != journal.SD_JOURNAL_NOP {
+		t.Errorf("Wait on an unchanged file = %d, want SD_JOURNAL_NOP", e)
+	}
+
+	// journald appends to the file in place
+	done := make(chan int)
+	go func() { done <- r.Wait(5 * time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := os.Open(filepath.Join("testdata", "regular.journal.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(b, 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if e := <-done; e != journal.SD_JOURNAL_APPEND {
+		t.Fatalf("Wait on a growing file = %d, want SD_JOURNAL_APPEND", e)
+	}
+	if got := entryMessages(readAll(t, r)); !reflect.DeepEqual(got, fixtureMessages[5:]) {
+		t.Errorf("messages after the append = %q, want %q", got, fixtureMessages[5:])
+	}
+}
@@ -0,0 +1,78 @@
+#!/usr/bin/env python3
+# Generates the .journal fixtures of the journalfile tests with a private
+# journald namespace instance. It needs root and systemd-journald (tested
+# with systemd 252, which compresses with zstd), run it from this directory:
+#
+#   sudo ./generate.py
+#
+# Every fixture is a gzipped copy of the file journald wrote, with the six
+# entries "entry 0" to "entry 5" of SYSLOG_IDENTIFIER=fixture between the
+# "Journal started" and "Runtime Journal ..." and the "Journal stopped"
+# messages of journald:
+#   regular.journal.gz      regular format
+#   compact.journal.gz      compact format
+#   growing-1.journal.gz    the regular file up to "entry 2", copied while
+#                           journald was writing it
+# "entry 2" and "entry 5" carry BIG, a field large enough to be compressed.
+import gzip, os, shutil, signal, socket, struct, subprocess, time
+
+NAMESPACE = "jbfixture"
+CONF = "/etc/systemd/journald@%s.conf" % NAMESPACE
+SOCKET = "/run/systemd/journal.%s/socket" % NAMESPACE
+MACHINE_ID = open("/etc/machine-id").read().strip()
+DIRS = ["/var/log/journal/%s.%s" % (MACHINE_ID, NAMESPACE), "/run/log/journal/%s.%s" % (MACHINE_ID, NAMESPACE)]
+BIG = ("journalbeat compresses nothing, journald does. " * 40).encode()
+
+
+def send(sock, i):
+    msg = b"MESSAGE=entry %d\nSYSLOG_IDENTIFIER=fixture\nPRIORITY=6\nINDEX=%d\n" % (i, i)
+    if i % 3 == 2:
+        msg += b"BIG\n" + struct.pack("<Q", len(BIG)) + BIG + b"\n"
+    sock.sendto(msg, SOCKET)
+    time.sleep(0.05)
+
+
+def journal_file():
+    for d in DIRS:
+        p = os.path.join(d, "system.journal")
+        if os.path.exists(p):
+            return p
+    raise SystemExit("journald wrote no file")
+
+
+def copy(src, name):
+    with open(src, "rb") as f, gzip.open(name, "wb") as out:
+        shutil.copyfileobj(f, out)
+
+
+def run(compact, snapshot):
+    for d in DIRS:
+        shutil.rmtree(d, ignore_errors=True)
+    env = dict(os.environ, SYSTEMD_JOURNAL_COMPACT="1" if compact else "0")
+    jd = subprocess.Popen(["/lib/systemd/systemd-journald", NAMESPACE], env=env,
+                          stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+    time.sleep(1)
+    sock = socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM)
+    for i in range(3):
+        send(sock, i)
+    # SIGRTMIN+1 makes journald sync its files
+    jd.send_signal(signal.SIGRTMIN + 1)
+    time.sleep(0.5)
+    if snapshot:
+        copy(journal_file(), snapshot)
+    for i in range(3, 6):
+        send(sock, i)
+    jd.send_signal(signal.SIGTERM)
+    jd.wait()
+    return journal_file()
+
+
+with open(CONF, "w") as f:
+    f.write("[Journal]\nStorage=volatile\nRuntimeMaxFileSize=512K\nSeal=no\n")
+try:
+    copy(run(False, "growing-1.journal.gz"), "regular.journal.gz")
+    copy(run(True, None), "compact.journal.gz")
+finally:
+    os.remove(CONF)
+    for d in DIRS:
+        shutil.rmtree(d, ignore_errors=True)
@@ -0,0 +1,130 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package journalfile
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// dirEvents are the changes of a directory that add or remove files
+	dirEvents = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+	// journald truncates a file to its size after writing through mmap,
+	// which shows up as IN_MODIFY
+	fileEvents = syscall.IN_MODIFY | syscall.IN_ATTRIB
+)
+
+// watcher waits for changes of journal files and directories with inotify,
+// which is what sd_journal_wait(3) does as well
+type watcher struct {
+	inotify int
+	epoll   int
+	watched map[string]struct{}
+	buf     []byte
+}
+
+func newWatcher() (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &watcher{
+		inotify: fd,
+		epoll:   epfd,
+		watched: map[string]struct{}{},
+		buf:     make([]byte, 64*1024),
+	}, nil
+}
+
+// watchDir watches a directory for files being added, removed or written
+func (w *watcher) watchDir(path string) error {
+	return w.watch(path, dirEvents|fileEvents)
+}
+
+// watchFile watches a single file for writes
+func (w *watcher) watchFile(path string) error {
+	return w.watch(path, fileEvents)
+}
+
+func (w *watcher) watch(path string, mask uint32) error {
+	if _, ok := w.watched[path]; ok {
+		return nil
+	}
+	if _, err := syscall.InotifyAddWatch(w.inotify, path, mask); err != nil {
+		return err
+	}
+	w.watched[path] = struct{}{}
+	return nil
+}
+
+// wait blocks until a watched path changed or the timeout expired. It
+// reports whether files were added or removed.
+func (w *watcher) wait(timeout time.Duration) (bool, error) {
+	ms := int(timeout / time.Millisecond)
+	if ms == 0 && timeout > 0 {
+		ms = 1
+	}
+	events := make([]syscall.EpollEvent, 1)
+	n, err := syscall.EpollWait(w.epoll, events, ms)
+	if err == syscall.EINTR || n == 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return w.drain()
+}
+
+// drain consumes the pending inotify events
+func (w *watcher) drain() (bool, error) {
+	dirChanged := false
+	for {
+		n, err := syscall.Read(w.inotify, w.buf)
+		if err == syscall.EAGAIN {
+			return dirChanged, nil
+		}
+		if err != nil {
+			return dirChanged, err
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&w.buf[off]))
+			if ev.Mask&(dirEvents|syscall.IN_Q_OVERFLOW|syscall.IN_IGNORED) != 0 {
+				dirChanged = true
+			}
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+		}
+	}
+}
+
+func (w *watcher) close() {
+	syscall.Close(w.epoll)
+	syscall.Close(w.inotify)
+}
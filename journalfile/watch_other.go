@@ -0,0 +1,35 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package journalfile
+
+import (
+	"errors"
+	"time"
+)
+
+// watcher is only available on Linux, elsewhere Reader.Wait polls
+type watcher struct{}
+
+func newWatcher() (*watcher, error) {
+	return nil, errors.New("watching journal files is only supported on Linux")
+}
+
+func (w *watcher) watchDir(path string) error               { return nil }
+func (w *watcher) watchFile(path string) error              { return nil }
+func (w *watcher) wait(timeout time.Duration) (bool, error) { return false, nil }
+func (w *watcher) close()                                   {}